@@ -4,18 +4,24 @@ import (
 	"context"
 	"fmt"
 
-	"go-cache/internal/cache"
+	"github.com/enesunal-m/go-cache/internal/cache"
 )
 
 func main() {
 	ctx := context.Background()
 
 	// Create a new multi-tier cache
-	c, err := cache.NewMultiTierCache(100, 1000, "localhost:6379", &cache.LRUPolicy{})
+	c, err := cache.NewCache(
+		cache.WithMemoryCapacity(100),
+		cache.WithDiskCapacity(1000),
+		cache.WithRemote(cache.RemoteStoreConfig{Addr: "localhost:6379"}),
+		cache.WithPolicy(&cache.LRUPolicy{}),
+	)
 	if err != nil {
 		fmt.Printf("Error creating cache: %v\n", err)
 		return
 	}
+	defer c.Close()
 
 	// Set some values
 	c.Set(ctx, "key1", []byte("value1"))
@@ -52,7 +58,12 @@ func main() {
 	}
 
 	// Demonstrate eviction
-	smallCache, _ := cache.NewMultiTierCache(20, 40, "localhost:6379", &cache.LRUPolicy{})
+	smallCache, _ := cache.NewCache(
+		cache.WithMemoryCapacity(20),
+		cache.WithDiskCapacity(40),
+		cache.WithRemote(cache.RemoteStoreConfig{Addr: "localhost:6379"}),
+	)
+	defer smallCache.Close()
 
 	fmt.Println("\nDemonstrating eviction:")
 	smallCache.Set(ctx, "key1", []byte("value1"))
@@ -0,0 +1,175 @@
+package cache
+
+import (
+	"container/list"
+	"iter"
+	"slices"
+	"sync"
+)
+
+// ARCPolicy is an Adaptive Replacement Cache policy. Keys seen once live in
+// T1 and keys seen again in T2; B1 and B2 remember keys recently evicted
+// from each. A hit on a B1 ghost grows the share of the cache given to T1,
+// a hit on a B2 ghost grows T2's, so the policy shifts between favouring
+// recency and frequency as the workload does.
+//
+// ARCPolicy tracks the memory tier: it learns about accesses through
+// RecordAccess and about entries leaving memory through RecordEvict. Keys
+// it has no record of are chosen in LRU order.
+type ARCPolicy struct {
+	mu       sync.Mutex
+	capacity int
+	// p is the target size of T1.
+	p              int
+	t1, t2, b1, b2 *arcList
+}
+
+// NewARCPolicy creates an ARCPolicy for a memory tier holding about
+// capacity entries, which bounds the ghost lists.
+func NewARCPolicy(capacity int) *ARCPolicy {
+	return &ARCPolicy{
+		capacity: capacity,
+		t1:       newARCList(),
+		t2:       newARCList(),
+		b1:       newARCList(),
+		b2:       newARCList(),
+	}
+}
+
+func (p *ARCPolicy) RecordAccess(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.t1.has(key):
+		p.t1.remove(key)
+		p.t2.pushFront(key)
+	case p.t2.has(key):
+		p.t2.pushFront(key)
+	case p.b1.has(key):
+		p.p = min(p.p+max(p.b2.len()/p.b1.len(), 1), p.capacity)
+		p.b1.remove(key)
+		p.t2.pushFront(key)
+	case p.b2.has(key):
+		p.p = max(p.p-max(p.b1.len()/p.b2.len(), 1), 0)
+		p.b2.remove(key)
+		p.t2.pushFront(key)
+	default:
+		p.t1.pushFront(key)
+		p.trimGhosts()
+	}
+}
+
+// RecordEvict moves key to the ghost list matching the list it was
+// evicted from.
+func (p *ARCPolicy) RecordEvict(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch {
+	case p.t1.has(key):
+		p.t1.remove(key)
+		p.b1.pushFront(key)
+	case p.t2.has(key):
+		p.t2.remove(key)
+		p.b2.pushFront(key)
+	default:
+		return
+	}
+	p.trimGhosts()
+}
+
+func (p *ARCPolicy) Choose(entries []*CacheEntry) string {
+	return p.ChooseSeq(slices.Values(entries))
+}
+
+func (p *ARCPolicy) ChooseSeq(entries iter.Seq[*CacheEntry]) string {
+	present := make(map[string]bool)
+	for entry := range lowestPriority(entries) {
+		present[entry.Key] = true
+	}
+
+	p.mu.Lock()
+	first, second := p.t2, p.t1
+	if p.t1.len() > 0 && (p.t1.len() > p.p || p.t2.len() == 0) {
+		first, second = p.t1, p.t2
+	}
+	key := first.leastRecent(present)
+	if key == "" {
+		key = second.leastRecent(present)
+	}
+	p.mu.Unlock()
+
+	if key != "" {
+		return key
+	}
+	return (&LRUPolicy{}).ChooseSeq(entries)
+}
+
+// Target returns the current target size of T1.
+func (p *ARCPolicy) Target() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.p
+}
+
+// trimGhosts keeps T1+B1 within capacity and all four lists within twice
+// capacity.
+func (p *ARCPolicy) trimGhosts() {
+	for p.t1.len()+p.b1.len() > p.capacity && p.b1.len() > 0 {
+		p.b1.removeBack()
+	}
+	for p.t1.len()+p.t2.len()+p.b1.len()+p.b2.len() > 2*p.capacity && p.b2.len() > 0 {
+		p.b2.removeBack()
+	}
+}
+
+// arcList is a recency-ordered set of keys, most recent first.
+type arcList struct {
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newARCList() *arcList {
+	return &arcList{order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (l *arcList) has(key string) bool {
+	_, ok := l.items[key]
+	return ok
+}
+
+func (l *arcList) len() int {
+	return l.order.Len()
+}
+
+func (l *arcList) pushFront(key string) {
+	if elem, ok := l.items[key]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.items[key] = l.order.PushFront(key)
+}
+
+func (l *arcList) remove(key string) {
+	if elem, ok := l.items[key]; ok {
+		l.order.Remove(elem)
+		delete(l.items, key)
+	}
+}
+
+func (l *arcList) removeBack() {
+	if elem := l.order.Back(); elem != nil {
+		l.remove(elem.Value.(string))
+	}
+}
+
+// leastRecent returns the least recently used key that is in present.
+func (l *arcList) leastRecent(present map[string]bool) string {
+	for elem := l.order.Back(); elem != nil; elem = elem.Prev() {
+		if key := elem.Value.(string); present[key] {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// autoTuneSteps is how many steps the auto-tuner takes to move the memory
+// capacity from one of its bounds to the other.
+const autoTuneSteps = 10
+
+// autoTuneMargin is how many times more hits per byte one tier must serve
+// than the other before capacity moves to it, so that similar rates don't
+// make the split flap.
+const autoTuneMargin = 1.25
+
+// autoTuner moves capacity between the memory and disk tiers, keeping
+// their sum, towards whichever served more hits per byte of capacity since
+// its last step. The memory capacity stays within [minMemory, maxMemory].
+type autoTuner struct {
+	minMemory, maxMemory int
+	// memoryHits and diskHits are the hit counts at the last step.
+	memoryHits, diskHits int64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (c *MultiTierCache) runAutoTune(interval time.Duration) {
+	defer close(c.tuner.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.autoTune()
+		case <-c.tuner.stop:
+			return
+		}
+	}
+}
+
+// autoTune takes one step, comparing the memory and disk tiers' hits per
+// byte since the last one.
+func (c *MultiTierCache) autoTune() {
+	t := c.tuner
+	memoryHits := atomic.LoadInt64(&c.statsMemoryHits)
+	diskHits := atomic.LoadInt64(&c.statsDiskHits)
+	// ResetStats can take the counters back below the last step's.
+	dm, dd := max(memoryHits-t.memoryHits, 0), max(diskHits-t.diskHits, 0)
+	t.memoryHits, t.diskHits = memoryHits, diskHits
+
+	memCap, diskCap := c.memoryStore.GetCapacity(), c.diskStore.GetCapacity()
+	if dm+dd == 0 || memCap <= 0 {
+		return
+	}
+	memRate := float64(dm) / float64(memCap)
+	diskRate := float64(dd) / float64(max(diskCap, 1))
+
+	step := max((t.maxMemory-t.minMemory)/autoTuneSteps, 1)
+	switch {
+	case memRate > diskRate*autoTuneMargin:
+		step = min(step, t.maxMemory-memCap, diskCap)
+		if step <= 0 {
+			return
+		}
+		// Shrink disk first so the memory it gives up is never counted
+		// twice.
+		if c.SetDiskCapacity(diskCap-step) == nil {
+			c.SetMemoryCapacity(memCap + step)
+		}
+	case diskRate > memRate*autoTuneMargin:
+		step = min(step, memCap-t.minMemory)
+		if step <= 0 {
+			return
+		}
+		// Grow disk first so the entries memory evicts have room there.
+		if c.SetDiskCapacity(diskCap+step) == nil {
+			c.SetMemoryCapacity(memCap - step)
+		}
+	}
+}
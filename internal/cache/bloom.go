@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+// DefaultBloomFalsePositiveRate is used by WithRemoteBloomFilter when the
+// rate given is not between 0 and 1.
+const DefaultBloomFalsePositiveRate = 0.01
+
+// bloomFilter records the keys written to the remote tier so that Get can
+// skip the round trip for keys that were never there. It can't forget a
+// key, so deleted keys still reach the remote tier and simply miss.
+type bloomFilter struct {
+	mu     sync.RWMutex
+	bits   []uint64
+	size   uint64
+	hashes int
+}
+
+// newBloomFilter sizes a filter to report at most falsePositiveRate of
+// absent keys as present once expectedKeys keys have been added.
+func newBloomFilter(expectedKeys int, falsePositiveRate float64) *bloomFilter {
+	n := float64(max(expectedKeys, 1))
+	p := falsePositiveRate
+	if p <= 0 || p >= 1 {
+		p = DefaultBloomFalsePositiveRate
+	}
+	m := math.Ceil(-n * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := int(math.Round(m / n * math.Ln2))
+
+	size := uint64(max(m, 64))
+	return &bloomFilter{
+		bits:   make([]uint64, (size+63)/64),
+		size:   size,
+		hashes: max(k, 1),
+	}
+}
+
+// positions calls fn with each bit index for key.
+func (f *bloomFilter) positions(key string, fn func(i uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	for i := 0; i < f.hashes; i++ {
+		fn((h1 + uint64(i)*h2) % f.size)
+	}
+}
+
+func (f *bloomFilter) add(key string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.positions(key, func(i uint64) {
+		f.bits[i/64] |= 1 << (i % 64)
+	})
+}
+
+// mayContain reports false only if key was never added.
+func (f *bloomFilter) mayContain(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	found := true
+	f.positions(key, func(i uint64) {
+		if f.bits[i/64]&(1<<(i%64)) == 0 {
+			found = false
+		}
+	})
+	return found
+}
+
+// noteRemoteKey records that key has been written to the remote tier.
+func (c *MultiTierCache) noteRemoteKey(key string) {
+	if c.remoteFilter != nil {
+		c.remoteFilter.add(key)
+	}
+}
+
+// remoteMayHave reports whether the remote tier may hold key, which is
+// always the case without a filter.
+func (c *MultiTierCache) remoteMayHave(key string) bool {
+	return c.remoteFilter == nil || c.remoteFilter.mayContain(key)
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultBreakerCooldown is how long the remote circuit breaker stays open
+// when RemoteStoreConfig.BreakerCooldown is zero.
+const DefaultBreakerCooldown = 30 * time.Second
+
+// circuitBreaker stops calls to a failing dependency. After threshold
+// consecutive failures it opens for cooldown; the first call after that is
+// let through, and a failure reopens it while a success closes it. A zero
+// threshold disables it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if cooldown <= 0 {
+		cooldown = DefaultBreakerCooldown
+	}
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a call may go ahead.
+func (b *circuitBreaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !time.Now().Before(b.openUntil)
+}
+
+// record counts the outcome of a call. Misses, values that aren't
+// integers and calls canceled by the caller don't say anything about the
+// dependency's health.
+func (b *circuitBreaker) record(err error) {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	if errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrNotInteger) || errors.Is(err, context.Canceled) {
+		err = nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (b *circuitBreaker) open() bool {
+	return !b.allow()
+}
@@ -3,8 +3,13 @@ package cache
 import (
 	"context"
 	"errors"
+	"maps"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type CacheEntry struct {
@@ -12,7 +17,85 @@ type CacheEntry struct {
 	Value      []byte
 	Size       int
 	LastAccess time.Time
-	Frequency  int
+	// AccessSeq orders accesses across the process. Unlike LastAccess it
+	// never goes backwards when the wall clock is stepped, so the policies
+	// compare it first.
+	AccessSeq uint64
+	Frequency int
+	ExpiresAt time.Time
+	// StaleUntil, if set, keeps an expired entry around so that
+	// GetStaleWhileRevalidate can serve it while refreshing.
+	StaleUntil time.Time
+	Compressed bool
+	// Streamed marks a disk entry whose value was written by SetStream to
+	// a file of its own. Entries read back with their value don't have it.
+	Streamed bool
+	// Version counts the writes of the key, Sets as well as Increments and
+	// swaps, starting at 1, so that SetWithVersion can detect concurrent
+	// updates. The remote tier doesn't
+	// keep it, so entries read back from Redis start again at 0.
+	Version uint64
+	// Tier is the tier GetWithMetadata found the entry in. Stores don't set
+	// it.
+	Tier Tier
+	// Priority ranks the entry for eviction: the built-in policies only
+	// evict an entry once no entry of lower priority remains. Like Version,
+	// the remote tier doesn't keep it.
+	Priority int
+	// Metadata holds caller-defined fields stored with the value, such as
+	// a content type. Every tier keeps it.
+	Metadata map[string]string
+	// Object, set by SetObject instead of Value, holds a value of a type
+	// registered with RegisterType. The memory tier keeps it as is and the
+	// disk tier gob-encodes it with the rest of the entry, so it is only
+	// serialized once. Copies of the entry share it.
+	Object any
+}
+
+// accessSeq hands out AccessSeq values. It starts at the wall clock in
+// nanoseconds so that entries kept on disk by an earlier process still
+// order before the ones this process touches.
+var accessSeq atomic.Uint64
+
+func init() {
+	accessSeq.Store(uint64(time.Now().UnixNano()))
+}
+
+// nextAccessSeq returns a fresh AccessSeq, later than any before it.
+func nextAccessSeq() uint64 {
+	return accessSeq.Add(1)
+}
+
+// Clone returns a deep copy of the entry, Value and Metadata included.
+func (e *CacheEntry) Clone() *CacheEntry {
+	clone := *e
+	if e.Value != nil {
+		clone.Value = append([]byte(nil), e.Value...)
+	}
+	clone.Metadata = maps.Clone(e.Metadata)
+	return &clone
+}
+
+// shallow returns a copy of the entry that shares its Value and Metadata.
+func (e *CacheEntry) shallow() *CacheEntry {
+	copied := *e
+	return &copied
+}
+
+func (e *CacheEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// stale reports whether the entry has expired but is still within its
+// stale window.
+func (e *CacheEntry) stale(now time.Time) bool {
+	return e.expired(now) && now.Before(e.StaleUntil)
+}
+
+// removable reports whether the entry has expired and is past any stale
+// window, so it can be deleted.
+func (e *CacheEntry) removable(now time.Time) bool {
+	return e.expired(now) && !e.stale(now)
 }
 
 type Store interface {
@@ -26,6 +109,42 @@ type Store interface {
 	GetAll(ctx context.Context) []*CacheEntry
 }
 
+// TierStats breaks hits down by the tier that served them.
+type TierStats struct {
+	MemoryHits int64
+	DiskHits   int64
+	RemoteHits int64
+	Misses     int64
+}
+
+// CacheStats extends TierStats with capacity evictions per tier and
+// promotions into memory from lower tiers.
+type CacheStats struct {
+	TierStats
+	MemoryEvictions int64
+	DiskEvictions   int64
+	Promotions      int64
+	// Thrashes counts entries evicted from memory soon after being
+	// promoted to it; see WithThrashProtection.
+	Thrashes int64
+}
+
+// Stats is a consistent snapshot of the cache's counters and size, taken
+// by Stats.
+type Stats struct {
+	CacheStats
+	Hits int64
+	// HitRatio is Hits over Hits plus Misses, or 0 before any lookup.
+	HitRatio float64
+	// Evictions counts capacity evictions from memory and disk.
+	Evictions int64
+	// Usage and Capacity are in bytes and cover the memory and disk tiers.
+	// The remote tier isn't counted, since Redis only reports usage for
+	// the whole server.
+	Usage    int
+	Capacity int
+}
+
 type EvictionPolicy interface {
 	Choose(entries []*CacheEntry) string
 }
@@ -39,174 +158,1455 @@ type MultiTierCache struct {
 
 	policy EvictionPolicy
 
-	statsHits   int64
-	statsMisses int64
+	writeThrough bool
+	writeBack    *writeBackQueue
+	// dirty holds the keys of memory entries not yet written to a lower
+	// tier. Eviction changes it under the read lock, so it has its own.
+	dirty dirtySet
+
+	maxEntrySize int
+	// maxKeyLength is the longest key accepted; zero means no limit.
+	maxKeyLength int
+	namespace    string
+	jitter       *ttlJitter
+	ttlBounds    ttlBounds
+	staleWindow  time.Duration
+	// promotionThreshold is the Frequency a lower-tier entry needs before a
+	// hit promotes it to memory.
+	promotionThreshold int
+	// evictionBatchSize is how many victims a BatchChooser picks per scan.
+	evictionBatchSize int
+	// evictionCascade moves evicted entries down a tier instead of
+	// dropping them.
+	evictionCascade bool
+	// zeroCopyReads lets Get return the memory tier's own value slices.
+	zeroCopyReads bool
+	// tracer, set by WithTracer, traces Get, Set and Delete; nil means
+	// no tracing.
+	tracer        Tracer
+	hashTraceKeys bool
+	// totalCapacity caps the combined usage of the memory and disk tiers;
+	// zero means no limit.
+	totalCapacity int
+
+	onEvict   EvictFunc
+	evictions evictionEvents
+	// tags indexes keys stored with SetWithTags.
+	tags tagIndex
+	// negatives holds the tombstones recorded by SetMissing.
+	negatives negativeIndex
+	// invalidator is set by WithInvalidationChannel.
+	invalidator *invalidator
+	// remoteFilter, set by WithRemoteBloomFilter, holds the keys written
+	// to the remote tier.
+	remoteFilter *bloomFilter
+	// thrash holds back promotions of entries that keep being evicted
+	// right after; nil when thrash protection is off.
+	thrash *thrashGuard
+	// remoteCapacity is sampled once at construction since asking Redis
+	// on every Set would cost a round trip. A negative value means the
+	// remote tier is unbounded or its capacity is unknown.
+	remoteCapacity int
+
+	statsMemoryHits int64
+	statsDiskHits   int64
+	statsRemoteHits int64
+	statsMisses     int64
+
+	statsMemoryEvictions int64
+	statsDiskEvictions   int64
+	statsPromotions      int64
+	statsThrashes        int64
+
+	loads  singleflight.Group
+	loader LoaderFunc
+	logger Logger
+	// clock stamps LastAccess and ExpiresAt and decides what has expired.
+	clock Clock
+
+	observersMu      sync.RWMutex
+	latencyObservers []func(op string, d time.Duration)
+
+	stopJanitor chan struct{}
+	janitorDone chan struct{}
+	// tuner, set by WithAutoTune, rebalances the memory and disk
+	// capacities.
+	tuner     *autoTuner
+	closeOnce sync.Once
 }
 
-func NewMultiTierCache(memCap, diskCap int, remoteAddr string, policy EvictionPolicy) (*MultiTierCache, error) {
-	memStore := NewMemoryStore(memCap)
-	diskStore, err := NewDiskStore(diskCap)
-	if err != nil {
-		return nil, err
+// NewCache creates a MultiTierCache configured by opts.
+func NewCache(opts ...Option) (*MultiTierCache, error) {
+	cfg := defaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
 	}
-	remoteStore, err := NewRemoteStore(remoteAddr)
-	if err != nil {
-		return nil, err
+
+	memOpts := MemoryStoreOptions{
+		MaxEntries:   cfg.maxEntries,
+		SizeFunc:     cfg.sizeFunc,
+		MaxEntrySize: int(cfg.memoryEntryFraction * float64(cfg.memoryCapacity)),
+		Clock:        cfg.clock,
+	}
+	var memStore Store = NewMemoryStoreWithOptions(cfg.memoryCapacity, memOpts)
+	if cfg.memoryShards > 1 {
+		memStore = NewShardedMemoryStore(cfg.memoryCapacity, cfg.memoryShards, memOpts)
+	}
+	var diskStore Store = NewNullStore()
+	var err error
+	if !cfg.memoryOnly {
+		diskStore, err = NewDiskStoreWithOptions(cfg.diskCapacity, DiskStoreOptions{
+			Dir:            cfg.diskDir,
+			Codec:          cfg.diskCodec,
+			MaxFiles:       cfg.maxDiskFiles,
+			EncryptionKey:  cfg.diskEncryptionKey,
+			Clock:          cfg.clock,
+			Logger:         cfg.logger,
+			MaxConcurrency: cfg.diskConcurrency,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var remoteStore Store = NewNullStore()
+	if cfg.remoteStore != nil {
+		remoteStore = cfg.remoteStore
+	} else if cfg.remote.Addr != "" {
+		if cfg.remote.Logger == nil {
+			cfg.remote.Logger = cfg.logger
+		}
+		if cfg.remote.Clock == nil {
+			cfg.remote.Clock = cfg.clock
+		}
+		remoteStore, err = NewRemoteStoreWithConfig(cfg.remote)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &MultiTierCache{
+	c := &MultiTierCache{
 		memoryStore: memStore,
 		diskStore:   diskStore,
 		remoteStore: remoteStore,
-		policy:      policy,
-	}, nil
+		policy:      cfg.policy,
+
+		writeThrough: cfg.writeThrough,
+		maxEntrySize: cfg.maxEntrySize,
+		maxKeyLength: max(cfg.maxKeyLength, 0),
+		namespace:    cfg.namespace,
+		staleWindow:  cfg.staleWindow,
+		ttlBounds:    cfg.ttlBounds,
+		onEvict:      cfg.onEvict,
+		loader:       cfg.loader,
+		logger:       cfg.logger,
+		clock:        cfg.clock,
+
+		promotionThreshold: cfg.promotionThreshold,
+		evictionBatchSize:  cfg.evictionBatchSize,
+		evictionCascade:    cfg.evictionCascade,
+		totalCapacity:      cfg.totalCapacity,
+		zeroCopyReads:      cfg.zeroCopyReads,
+		tracer:             cfg.tracer,
+		hashTraceKeys:      cfg.hashTraceKeys,
+	}
+	if cfg.ttlJitter > 0 {
+		c.jitter = newTTLJitter(cfg.ttlJitter, cfg.randSource)
+	}
+	c.remoteCapacity = remoteStore.GetCapacity()
+	if _, ok := remoteStore.(*NullStore); !ok && c.remoteCapacity <= 0 {
+		c.remoteCapacity = -1
+	}
+	if cfg.thrashThreshold > 0 {
+		c.thrash = newThrashGuard(cfg.thrashWindow, cfg.thrashThreshold, cfg.thrashCooldown)
+	}
+	if cfg.remoteBloomKeys > 0 {
+		c.remoteFilter = newBloomFilter(cfg.remoteBloomKeys, cfg.remoteBloomFPR)
+		for _, key := range remoteStore.Keys(context.Background()) {
+			c.remoteFilter.add(key)
+		}
+	}
+	if cfg.invalidationChannel != "" {
+		if err := c.subscribeInvalidations(cfg.invalidationChannel); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.writeBackQueueSize > 0 {
+		c.writeBack = newWriteBackQueue(cfg.writeBackQueueSize, cfg.writeBackPolicy)
+		go c.runWriteBack()
+	}
+	if cfg.janitorInterval > 0 {
+		c.stopJanitor = make(chan struct{})
+		c.janitorDone = make(chan struct{})
+		go c.runJanitor(cfg.janitorInterval)
+	}
+	if cfg.autoTuneInterval > 0 && !cfg.memoryOnly && cfg.autoTuneMaxMemory > cfg.autoTuneMinMemory {
+		c.tuner = &autoTuner{
+			minMemory: max(cfg.autoTuneMinMemory, 0),
+			maxMemory: cfg.autoTuneMaxMemory,
+			stop:      make(chan struct{}),
+			done:      make(chan struct{}),
+		}
+		go c.runAutoTune(cfg.autoTuneInterval)
+	}
+	return c, nil
 }
 
+// NewMemoryCache creates a cache with only a memory tier of capacity bytes,
+// evicting with policy (LRU if nil). It touches neither the filesystem nor
+// the network, which suits tests and small in-process caches.
+func NewMemoryCache(capacity int, policy EvictionPolicy) *MultiTierCache {
+	if policy == nil {
+		policy = &LRUPolicy{}
+	}
+	// NewCache can only fail creating the disk or remote tier.
+	c, _ := NewCache(WithMemoryCapacity(capacity), WithPolicy(policy), func(c *config) {
+		c.memoryOnly = true
+	})
+	return c
+}
+
+// NewMultiTierCache creates a cache backed by memory, disk and Redis. An
+// optional RemoteStoreConfig overrides the connection settings for the
+// remote tier; remoteAddr is used when its Addr is empty. An empty address
+// disables the remote tier and the cache runs on memory and disk only.
+//
+// Deprecated: Use NewCache with options instead.
+func NewMultiTierCache(memCap, diskCap int, remoteAddr string, policy EvictionPolicy, remoteCfg ...RemoteStoreConfig) (*MultiTierCache, error) {
+	cfg := RemoteStoreConfig{Addr: remoteAddr}
+	if len(remoteCfg) > 0 {
+		cfg = remoteCfg[0]
+		if cfg.Addr == "" {
+			cfg.Addr = remoteAddr
+		}
+	}
+
+	return NewCache(
+		WithMemoryCapacity(memCap),
+		WithDiskCapacity(diskCap),
+		WithRemote(cfg),
+		WithPolicy(policy),
+	)
+}
+
+// Get returns the value for key from the highest tier holding it. With a
+// loader set by WithLoader, a miss loads and caches the value instead of
+// failing with ErrKeyNotFound.
 func (c *MultiTierCache) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := c.getValue(ctx, key)
+	if c.loader != nil && errors.Is(err, ErrKeyNotFound) {
+		return c.load(ctx, key, 0, nil)
+	}
+	return value, err
+}
+
+// getValue is Get without the configured loader.
+func (c *MultiTierCache) getValue(ctx context.Context, key string) ([]byte, error) {
+	entry, _, err := c.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Value, nil
+}
+
+// GetFromTier is like Get but also reports which tier served the value.
+// The entry is promoted and stats are updated as for Get.
+func (c *MultiTierCache) GetFromTier(ctx context.Context, key string) ([]byte, Tier, error) {
+	entry, tier, err := c.get(ctx, key)
+	if err != nil {
+		return nil, TierNone, err
+	}
+	return entry.Value, tier, nil
+}
+
+// GetWithMetadata is like Get but returns a copy of the whole entry, with
+// the Metadata it was set with, its access metadata and expiry, and the
+// tier that served it. With WithZeroCopyReads its Value and Metadata may
+// be shared with the cache, as Get's value is.
+func (c *MultiTierCache) GetWithMetadata(ctx context.Context, key string) (*CacheEntry, error) {
+	entry, tier, err := c.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *entry
+	copied.Key = key
+	if !c.zeroCopyReads {
+		copied.Value = append([]byte(nil), entry.Value...)
+		copied.Metadata = maps.Clone(entry.Metadata)
+	}
+	copied.Tier = tier
+	return &copied, nil
+}
+
+// get looks key up tier by tier, updating access metadata and stats and
+// promoting lower-tier hits.
+func (c *MultiTierCache) get(ctx context.Context, key string) (entry *CacheEntry, tier Tier, err error) {
+	if c.tracer != nil {
+		var span Span
+		ctx, span = c.startSpan(ctx, "Get", key)
+		defer func() { endSpan(span, "Get", tier, err) }()
+	}
+	if err := c.checkKey("get", key); err != nil {
+		return nil, TierNone, err
+	}
+	// Deferred before the lock so these run after unlocking.
+	defer c.observeLatency("get", time.Now())
+	defer c.dispatchEvictions()
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	entry, err := c.memoryStore.Get(ctx, key)
-	if err == nil {
-		c.statsHits++
-		entry.LastAccess = time.Now()
-		entry.Frequency++
-		return entry.Value, nil
+	sk := c.storeKey(key)
+	now := c.clock.Now()
+	if c.negatives.has(sk, now) {
+		atomic.AddInt64(&c.statsMisses, 1)
+		return nil, TierNone, &CacheError{Op: "get", Key: key, Err: ErrNegativeCached}
+	}
+	if entry, tier, ok := c.getLocal(ctx, sk, now); ok {
+		return entry, tier, nil
 	}
 
-	entry, err = c.diskStore.Get(ctx, key)
+	if c.remoteMayHave(sk) {
+		if entry, err := c.remoteStore.Get(ctx, sk); err == nil {
+			c.remoteHit(ctx, sk, entry)
+			return entry, TierRemote, nil
+		}
+	}
+
+	c.recordMiss(sk)
+	atomic.AddInt64(&c.statsMisses, 1)
+	return nil, TierNone, &CacheError{Op: "get", Key: key, Err: ErrKeyNotFound}
+}
+
+// getLocal looks sk up in memory and then on disk, handling hits as get
+// does. Callers hold c.mu.
+func (c *MultiTierCache) getLocal(ctx context.Context, sk string, now time.Time) (*CacheEntry, Tier, bool) {
+	entry, err := c.getMemory(ctx, sk)
+	if err == nil && entry.expired(now) {
+		if entry.removable(now) {
+			c.memoryStore.Delete(ctx, sk)
+			c.recordMemoryRemoval(sk)
+			c.recordEviction(entry, EvictReasonExpired)
+		}
+		err = ErrKeyNotFound
+	}
 	if err == nil {
-		c.statsHits++
-		entry.LastAccess = time.Now()
-		entry.Frequency++
-		c.promoteToMemory(ctx, entry)
-		return entry.Value, nil
+		c.recordAccess(sk)
+		atomic.AddInt64(&c.statsMemoryHits, 1)
+		touch(ctx, c.memoryStore, entry, c.clock.Now())
+		return entry, TierMemory, true
 	}
 
-	entry, err = c.remoteStore.Get(ctx, key)
+	entry, err = c.diskStore.Get(ctx, sk)
+	if err == nil && entry.expired(now) {
+		if entry.removable(now) {
+			c.diskStore.Delete(ctx, sk)
+			c.recordEviction(entry, EvictReasonExpired)
+		}
+		err = ErrKeyNotFound
+	}
 	if err == nil {
-		c.statsHits++
-		entry.LastAccess = time.Now()
+		c.recordAccess(sk)
+		atomic.AddInt64(&c.statsDiskHits, 1)
+		entry.LastAccess = c.clock.Now()
+		entry.AccessSeq = nextAccessSeq()
 		entry.Frequency++
+		if entry.Frequency < c.promotionThreshold || !c.promoteToMemory(ctx, entry) {
+			// Record the access so repeated hits can earn promotion.
+			c.diskStore.Set(ctx, entry)
+		}
+		return entry, TierDisk, true
+	}
+	return nil, TierNone, false
+}
+
+// getMemory reads sk from the memory tier, sharing the stored value
+// instead of copying it when zero-copy reads are on and the store allows
+// it.
+func (c *MultiTierCache) getMemory(ctx context.Context, sk string) (*CacheEntry, error) {
+	if c.zeroCopyReads {
+		if sharer, ok := c.memoryStore.(interface {
+			GetShared(context.Context, string) (*CacheEntry, error)
+		}); ok {
+			return sharer.GetShared(ctx, sk)
+		}
+	}
+	return c.memoryStore.Get(ctx, sk)
+}
+
+// touch records an access on entry at now and, since stores hand out
+// copies, on the entry stored in store if it supports that.
+func touch(ctx context.Context, store Store, entry *CacheEntry, now time.Time) {
+	entry.LastAccess = now
+	entry.AccessSeq = nextAccessSeq()
+	entry.Frequency++
+	if toucher, ok := store.(interface {
+		Touch(context.Context, string, time.Time) error
+	}); ok {
+		toucher.Touch(ctx, entry.Key, entry.LastAccess)
+	}
+}
+
+// remoteHit records a hit on entry, fetched from the remote tier, and
+// promotes it. Callers hold c.mu.
+func (c *MultiTierCache) remoteHit(ctx context.Context, sk string, entry *CacheEntry) {
+	c.recordAccess(sk)
+	atomic.AddInt64(&c.statsRemoteHits, 1)
+	entry.LastAccess = c.clock.Now()
+	entry.AccessSeq = nextAccessSeq()
+	entry.Frequency++
+	if entry.Frequency < c.promotionThreshold {
+		// Redis doesn't keep Frequency, so count the hit on disk where
+		// the next one will find it.
+		c.diskStore.Set(ctx, entry)
+	} else {
 		c.promoteToMemory(ctx, entry)
-		return entry.Value, nil
 	}
+}
 
-	c.statsMisses++
-	return nil, errors.New("key not found")
+// GetMulti returns the values for the keys that are cached, keyed by key.
+// Keys missing from memory and disk are fetched from the remote tier in one
+// batch. Hits update stats and are promoted like Get's; a remote error is
+// returned along with whatever was found locally.
+func (c *MultiTierCache) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	for _, key := range keys {
+		if err := c.checkKey("get", key); err != nil {
+			return nil, err
+		}
+	}
+	defer c.dispatchEvictions()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now()
+	values := make(map[string][]byte, len(keys))
+	var missed []string
+	for _, key := range keys {
+		sk := c.storeKey(key)
+		if c.negatives.has(sk, now) {
+			continue
+		}
+		if entry, _, ok := c.getLocal(ctx, sk, now); ok {
+			values[key] = entry.Value
+		} else if c.remoteMayHave(sk) {
+			missed = append(missed, key)
+		} else {
+			c.recordMiss(sk)
+			atomic.AddInt64(&c.statsMisses, 1)
+		}
+	}
+	if len(missed) == 0 {
+		return values, nil
+	}
+
+	storeKeys := make([]string, len(missed))
+	for i, key := range missed {
+		storeKeys[i] = c.storeKey(key)
+	}
+	entries, err := getFromStore(ctx, c.remoteStore, storeKeys)
+	for i, key := range missed {
+		if entry, ok := entries[storeKeys[i]]; ok {
+			c.remoteHit(ctx, storeKeys[i], entry)
+			values[key] = entry.Value
+			continue
+		}
+		c.recordMiss(storeKeys[i])
+		atomic.AddInt64(&c.statsMisses, 1)
+	}
+	if err != nil {
+		return values, &CacheError{Op: "get", Tier: TierRemote, Err: err}
+	}
+	return values, nil
 }
 
-func (c *MultiTierCache) Set(ctx context.Context, key string, value []byte) error {
+// getFromStore reads keys from store in one call if it supports batch
+// reads. Missing keys are left out of the result.
+func getFromStore(ctx context.Context, store Store, keys []string) (map[string]*CacheEntry, error) {
+	if getter, ok := store.(interface {
+		GetMulti(context.Context, []string) (map[string]*CacheEntry, error)
+	}); ok {
+		return getter.GetMulti(ctx, keys)
+	}
+
+	entries := make(map[string]*CacheEntry, len(keys))
+	var errs []error
+	for _, key := range keys {
+		entry, err := store.Get(ctx, key)
+		if err == nil {
+			entries[key] = entry
+		} else if !errors.Is(err, ErrKeyNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+// GetOrLoad returns the cached value for key, or calls loader and caches
+// its result on a miss. Concurrent misses on the same key share a single
+// loader call. Loader errors are returned to every waiting caller and are
+// not cached. A key recorded with SetMissing fails with ErrNegativeCached
+// without calling loader.
+func (c *MultiTierCache) GetOrLoad(ctx context.Context, key string, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	return c.getOrLoad(ctx, key, 0, loader)
+}
+
+func (c *MultiTierCache) getOrLoad(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	value, err := c.getValue(ctx, key)
+	if err == nil || errors.Is(err, ErrNegativeCached) {
+		return value, err
+	}
+	return c.load(ctx, key, ttl, loader)
+}
+
+// load calls loader for a key that missed and caches the result with ttl.
+// A nil loader uses the one set by WithLoader, which supplies its own TTL.
+func (c *MultiTierCache) load(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	v, err, _ := c.loads.Do(key, func() (interface{}, error) {
+		// Another caller may have finished loading between our miss and
+		// joining the group.
+		if c.Has(ctx, key) {
+			if value, err := c.getValue(ctx, key); err == nil {
+				return value, nil
+			}
+		}
+
+		var value []byte
+		var err error
+		if loader != nil {
+			value, err = loader(ctx)
+		} else {
+			value, ttl, err = c.loader(ctx, key)
+			if errors.Is(err, ErrKeyNotFound) && ttl > 0 {
+				c.SetMissing(ctx, key, ttl)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := c.SetWithTTL(ctx, key, value, ttl); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// WarmUp loads keys from the disk and remote tiers into memory, evicting
+// through the policy when memory fills up. Keys that aren't found, or don't
+// fit in memory at all, are skipped. It returns how many keys were loaded.
+func (c *MultiTierCache) WarmUp(ctx context.Context, keys []string) (int, error) {
+	defer c.dispatchEvictions()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	now := c.clock.Now()
+	warmed := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return warmed, err
+		}
+
+		sk := c.storeKey(key)
+		if _, err := c.memoryStore.Get(ctx, sk); err == nil {
+			continue
+		}
+
+		var entry *CacheEntry
+		for _, store := range []Store{c.diskStore, c.remoteStore} {
+			if e, err := store.Get(ctx, sk); err == nil && !e.expired(now) {
+				entry = e
+				break
+			}
+		}
+		if entry == nil {
+			continue
+		}
+		if entry.Size == 0 {
+			entry.Size = len(entry.Value)
+		}
+
+		if err := c.setInStore(ctx, c.memoryStore, entry); err == nil {
+			warmed++
+		}
+	}
+	return warmed, nil
+}
+
+// Has reports whether key is present in any tier. Unlike Get it does not
+// update access metadata, hit/miss counters, or promote the entry.
+func (c *MultiTierCache) Has(ctx context.Context, key string) bool {
+	if c.checkKey("has", key) != nil {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sk := c.storeKey(key)
+	now := c.clock.Now()
+	if c.negatives.has(sk, now) {
+		return false
+	}
+	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+		if storeHas(ctx, store, sk, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Peek returns the value for key from whichever tier holds it without
+// counting as an access: recency, frequency and stats are left alone and
+// the entry isn't promoted.
+func (c *MultiTierCache) Peek(ctx context.Context, key string) ([]byte, error) {
+	if err := c.checkKey("peek", key); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sk := c.storeKey(key)
+	now := c.clock.Now()
+	if c.negatives.has(sk, now) {
+		return nil, &CacheError{Op: "peek", Key: key, Err: ErrNegativeCached}
+	}
+	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+		entry, err := peekEntry(ctx, store, sk)
+		if err == nil && !entry.expired(now) {
+			return append([]byte(nil), entry.Value...), nil
+		}
+	}
+	return nil, &CacheError{Op: "peek", Key: key, Err: ErrKeyNotFound}
+}
+
+// storeHas reports whether store holds an unexpired entry for key, using
+// the store's own Has when it has one.
+func storeHas(ctx context.Context, store Store, key string, now time.Time) bool {
+	if checker, ok := store.(interface {
+		Has(context.Context, string) bool
+	}); ok {
+		return checker.Has(ctx, key)
+	}
+	entry, err := store.Get(ctx, key)
+	return err == nil && !entry.expired(now)
+}
+
+func (c *MultiTierCache) Set(ctx context.Context, key string, value []byte) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL stores value so that it expires after ttl. A ttl of zero means
+// the entry never expires.
+func (c *MultiTierCache) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.set(ctx, key, value, SetOptions{TTL: ttl}, nil)
+}
+
+// SetOptions configures a write made with SetWithOptions.
+type SetOptions struct {
+	// TTL is how long the entry lives; zero means it never expires.
+	TTL time.Duration
+	// Priority protects the entry from eviction while entries of lower
+	// priority remain. Zero, the default, is the lowest; negative values
+	// are treated as zero. MaxPriority pins the entry under a
+	// PinnedPolicy.
+	Priority int
+	// Metadata is stored with the value; see SetWithMetadata.
+	Metadata map[string]string
+
+	// object and objectSize are set by SetObject.
+	object     any
+	objectSize int
+}
+
+// SetWithOptions stores value with the given TTL and eviction priority.
+func (c *MultiTierCache) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	return c.set(ctx, key, value, opts, nil)
+}
+
+// set stores value at key. If expectedVersion is non-nil the write only
+// happens if the key's current version matches it.
+func (c *MultiTierCache) set(ctx context.Context, key string, value []byte, opts SetOptions, expectedVersion *uint64) (err error) {
+	if c.tracer != nil {
+		var span Span
+		ctx, span = c.startSpan(ctx, "Set", key)
+		defer func() { endSpan(span, "Set", TierNone, err) }()
+	}
+	defer c.observeLatency("set", time.Now())
+	defer c.dispatchEvictions()
+
+	entry, err := c.newEntry(key, value, opts)
+	if err != nil {
+		return err
+	}
+	c.negatives.remove(entry.Key)
+	c.recordAccess(entry.Key)
+
+	if c.writeBack != nil {
+		err = c.setWriteBack(ctx, entry, expectedVersion)
+	} else {
+		err = c.setLocked(ctx, entry, expectedVersion)
+	}
+	if err == nil {
+		c.publishInvalidation(ctx, entry.Key)
+	}
+	return err
+}
+
+// newEntry builds the entry set stores for value at key, applying the TTL
+// settings, and checks it isn't too large.
+func (c *MultiTierCache) newEntry(key string, value []byte, opts SetOptions) (*CacheEntry, error) {
+	if err := c.checkKey("set", key); err != nil {
+		return nil, err
+	}
+	now := c.clock.Now()
 	entry := &CacheEntry{
-		Key:        key,
+		Key:        c.storeKey(key),
 		Value:      value,
 		Size:       len(value),
-		LastAccess: time.Now(),
+		LastAccess: now,
+		AccessSeq:  nextAccessSeq(),
 		Frequency:  1,
+		Priority:   max(opts.Priority, 0),
+		Metadata:   maps.Clone(opts.Metadata),
+	}
+	if opts.object != nil {
+		entry.Object = opts.object
+		entry.Size = opts.objectSize
 	}
+	expiresAt, staleUntil, ok := c.expiry(opts.TTL, now)
+	if !ok {
+		return nil, &CacheError{Op: "set", Key: key, Err: ErrTTLRequired}
+	}
+	entry.ExpiresAt, entry.StaleUntil = expiresAt, staleUntil
 
-	// Try to set in memory first
-	err := c.memoryStore.Set(ctx, entry)
-	if err == nil {
-		return nil
+	if c.tooLarge(entry.Size) {
+		return nil, &CacheError{Op: "set", Key: key, Err: ErrEntryTooLarge}
 	}
+	return entry, nil
+}
 
-	// If memory is full, try to evict
-	if errors.Is(err, ErrInsufficientCapacity) {
-		evicted := c.evict(ctx, c.memoryStore, entry.Size)
-		if evicted {
-			err = c.memoryStore.Set(ctx, entry)
-			if err == nil {
-				return nil
-			}
+// expiry returns when an entry given ttl at now expires and stops being
+// served stale, after jitter and the TTL bounds are applied. Zero times
+// mean never; false means the bounds require a TTL and ttl is zero.
+func (c *MultiTierCache) expiry(ttl time.Duration, now time.Time) (expiresAt, staleUntil time.Time, ok bool) {
+	if ttl > 0 && c.jitter != nil {
+		ttl = c.jitter.apply(ttl)
+	}
+	ttl, ok = c.ttlBounds.apply(ttl)
+	if !ok || ttl <= 0 {
+		return time.Time{}, time.Time{}, ok
+	}
+	expiresAt = now.Add(ttl)
+	if c.staleWindow > 0 {
+		staleUntil = expiresAt.Add(c.staleWindow)
+	}
+	return expiresAt, staleUntil, true
+}
+
+func (c *MultiTierCache) setLocked(ctx context.Context, entry *CacheEntry, expectedVersion *uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.assignVersion(ctx, entry, expectedVersion); err != nil {
+		return err
+	}
+	defer c.fitTotalCapacity(ctx, entry.Key)
+	if c.writeThrough {
+		return c.setWriteThrough(ctx, entry)
+	}
+	return c.setEntry(ctx, entry)
+}
+
+// SetMemoryCapacity changes the memory tier's capacity to n bytes. Shrinking
+// it below the current usage evicts through the policy, moving entries to
+// the lower tiers as usual, until the tier fits.
+func (c *MultiTierCache) SetMemoryCapacity(n int) error {
+	return c.setCapacity(c.memoryStore, TierMemory, n)
+}
+
+// SetDiskCapacity is SetMemoryCapacity for the disk tier. Entries evicted
+// from disk move to the remote tier.
+func (c *MultiTierCache) SetDiskCapacity(n int) error {
+	return c.setCapacity(c.diskStore, TierDisk, n)
+}
+
+func (c *MultiTierCache) setCapacity(store Store, tier Tier, n int) error {
+	resizer, ok := store.(interface{ SetCapacity(int) })
+	if !ok {
+		return &CacheError{Op: "resize", Tier: tier, Err: errors.ErrUnsupported}
+	}
+	defer c.dispatchEvictions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resizer.SetCapacity(n)
+	ctx := context.Background()
+	for store.GetUsage() > n {
+		key := c.chooseVictim(ctx, store)
+		if key == "" || !c.evict(ctx, store, key) {
+			return &CacheError{Op: "resize", Tier: tier, Err: ErrInsufficientCapacity}
 		}
 	}
+	return nil
+}
+
+// fitTotalCapacity evicts until the memory and disk tiers together are
+// within totalCapacity, taking victims from disk first, since an entry
+// evicted from memory only moves to disk. Entries evicted from disk fall
+// through to the remote tier as usual. The entry for keep, just written,
+// is never chosen. Callers hold the write lock.
+func (c *MultiTierCache) fitTotalCapacity(ctx context.Context, keep string) {
+	if c.totalCapacity <= 0 {
+		return
+	}
+	for c.memoryStore.GetUsage()+c.diskStore.GetUsage() > c.totalCapacity {
+		store := c.diskStore
+		if store.GetUsage() == 0 {
+			store = c.memoryStore
+		}
+		key := c.chooseVictim(ctx, store)
+		if key == "" || key == keep || !c.evict(ctx, store, key) {
+			return
+		}
+	}
+}
+
+// assignVersion gives entry the version after the one currently stored,
+// failing with ErrVersionMismatch if that isn't expected. Callers hold the
+// write lock.
+func (c *MultiTierCache) assignVersion(ctx context.Context, entry *CacheEntry, expected *uint64) error {
+	current := c.currentVersion(ctx, entry.Key)
+	if expected != nil && *expected != current {
+		key, _ := c.userKey(entry.Key)
+		return &CacheError{Op: "set", Key: key, Err: ErrVersionMismatch}
+	}
+	entry.Version = current + 1
+	return nil
+}
+
+// currentVersion returns the version of the live entry for key in memory
+// or on disk, or 0 if there is none.
+func (c *MultiTierCache) currentVersion(ctx context.Context, key string) uint64 {
+	now := c.clock.Now()
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		if entry, err := peekEntry(ctx, store, key); err == nil && !entry.expired(now) {
+			return entry.Version
+		}
+	}
+	return 0
+}
+
+// checkKey rejects empty keys and keys longer than the configured maximum
+// with ErrInvalidKey. An empty key would map to an empty name on disk.
+func (c *MultiTierCache) checkKey(op, key string) error {
+	if key == "" || c.maxKeyLength > 0 && len(key) > c.maxKeyLength {
+		return &CacheError{Op: op, Key: key, Err: ErrInvalidKey}
+	}
+	return nil
+}
+
+// tooLarge reports whether an entry of size bytes exceeds the configured
+// maximum or, without one, can't fit in any tier.
+func (c *MultiTierCache) tooLarge(size int) bool {
+	if c.maxEntrySize > 0 {
+		return size > c.maxEntrySize
+	}
+	if c.remoteCapacity < 0 {
+		return false
+	}
+	limit := c.remoteCapacity
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		if capacity := store.GetCapacity(); capacity > limit {
+			limit = capacity
+		}
+	}
+	return size > limit
+}
+
+// setEntry places entry in the highest tier that can hold it.
+func (c *MultiTierCache) setEntry(ctx context.Context, entry *CacheEntry) error {
+	// Try to set in memory first, evicting if it is full
+	if err := c.setInStore(ctx, c.memoryStore, entry); err == nil {
+		c.dirty.add(entry.Key)
+		return nil
+	}
+	// Drop any older value so it can't shadow the new one lower down.
+	c.dirty.remove(entry.Key)
+	c.memoryStore.Delete(ctx, entry.Key)
+	c.recordMemoryRemoval(entry.Key)
 
 	// If still can't fit in memory, try disk
-	err = c.diskStore.Set(ctx, entry)
-	if err == nil {
+	if err := c.setInStore(ctx, c.diskStore, entry); err == nil {
 		return nil
 	}
+	c.diskStore.Delete(ctx, entry.Key)
+
+	// If still can't fit, use remote store
+	if err := c.remoteStore.Set(ctx, entry); err != nil {
+		return &CacheError{Op: "set", Tier: TierRemote, Key: entry.Key, Err: err}
+	}
+	c.noteRemoteKey(entry.Key)
+	return nil
+}
+
+// setWriteThrough writes entry to every tier so that it survives memory
+// eviction and restarts. The remote write is authoritative: its error is
+// returned, while memory and disk are best-effort.
+func (c *MultiTierCache) setWriteThrough(ctx context.Context, entry *CacheEntry) error {
+	c.setInStore(ctx, c.memoryStore, entry)
+	return c.persistLocked(ctx, entry)
+}
+
+// setInStore writes entry to store, evicting to make room if the store
+// reports insufficient capacity.
+func (c *MultiTierCache) setInStore(ctx context.Context, store Store, entry *CacheEntry) error {
+	err := store.Set(ctx, entry)
+	if errors.Is(err, ErrInsufficientCapacity) && c.admit(ctx, store, entry) {
+		return c.setEvicting(ctx, store, entry)
+	}
+	return err
+}
 
-	// If disk is full, try to evict
-	if errors.Is(err, ErrInsufficientCapacity) {
-		evicted := c.evict(ctx, c.diskStore, entry.Size)
-		if evicted {
-			err = c.diskStore.Set(ctx, entry)
-			if err == nil {
-				return nil
+// setEvicting evicts from store one entry at a time until entry fits. The
+// store decides what entry costs, so this works whatever it charges.
+func (c *MultiTierCache) setEvicting(ctx context.Context, store Store, entry *CacheEntry) error {
+	err := store.Set(ctx, entry)
+	if entry.Size > store.GetCapacity() {
+		// Emptying the store wouldn't help.
+		return err
+	}
+	for errors.Is(err, ErrInsufficientCapacity) {
+		victims := c.chooseVictims(ctx, store)
+		if len(victims) == 0 {
+			return err
+		}
+		for _, key := range victims {
+			if !c.evict(ctx, store, key) {
+				return err
+			}
+			if err = store.Set(ctx, entry); !errors.Is(err, ErrInsufficientCapacity) {
+				return err
 			}
 		}
 	}
+	return err
+}
 
-	// If still can't fit, use remote store
-	return c.remoteStore.Set(ctx, entry)
+// admit asks an AdmissionPolicy whether entry should displace the next
+// eviction victim in store.
+func (c *MultiTierCache) admit(ctx context.Context, store Store, entry *CacheEntry) bool {
+	admitter, ok := c.policy.(AdmissionPolicy)
+	if !ok {
+		return true
+	}
+	victim := c.chooseVictim(ctx, store)
+	if victim == "" || victim == entry.Key {
+		return true
+	}
+	return admitter.Admit(entry.Key, victim)
+}
+
+func (c *MultiTierCache) recordAccess(key string) {
+	if recorder, ok := c.policy.(AccessRecorder); ok {
+		recorder.RecordAccess(key)
+	}
+}
+
+func (c *MultiTierCache) recordMiss(key string) {
+	if recorder, ok := c.policy.(MissRecorder); ok {
+		recorder.RecordMiss(key)
+	}
 }
 
-func (c *MultiTierCache) Delete(ctx context.Context, key string) error {
+// recordMemoryRemoval tells the policy that key left the memory tier.
+func (c *MultiTierCache) recordMemoryRemoval(key string) {
+	if recorder, ok := c.policy.(EvictionRecorder); ok {
+		recorder.RecordEvict(key)
+	}
+}
+
+func (c *MultiTierCache) Delete(ctx context.Context, key string) (err error) {
+	if c.tracer != nil {
+		var span Span
+		ctx, span = c.startSpan(ctx, "Delete", key)
+		defer func() { endSpan(span, "Delete", TierNone, err) }()
+	}
+	if err := c.checkKey("delete", key); err != nil {
+		return err
+	}
+	sk := c.storeKey(key)
+	defer c.publishInvalidation(ctx, sk)
+	defer c.dispatchEvictions()
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.memoryStore.Delete(ctx, key)
-	c.diskStore.Delete(ctx, key)
-	return c.remoteStore.Delete(ctx, key)
+	if c.onEvict != nil {
+		for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+			if entry, err := store.Get(ctx, sk); err == nil {
+				c.recordEviction(entry, EvictReasonManual)
+				break
+			}
+		}
+	}
+
+	if c.writeBack != nil {
+		c.writeBack.forget(sk)
+	}
+	c.dirty.remove(sk)
+	c.tags.remove(key)
+	c.negatives.remove(sk)
+
+	c.memoryStore.Delete(ctx, sk)
+	c.recordMemoryRemoval(sk)
+	c.diskStore.Delete(ctx, sk)
+	if err := c.remoteStore.Delete(ctx, sk); err != nil {
+		return &CacheError{Op: "delete", Tier: TierRemote, Key: key, Err: err}
+	}
+	return nil
+}
+
+// DeleteExisting is Delete that also reports whether key was present in
+// any tier beforehand. The remote tier's answer is the count Redis's DEL
+// returns, so it costs no extra round trip.
+func (c *MultiTierCache) DeleteExisting(ctx context.Context, key string) (existed bool, err error) {
+	if c.tracer != nil {
+		var span Span
+		ctx, span = c.startSpan(ctx, "Delete", key)
+		defer func() { endSpan(span, "Delete", TierNone, err) }()
+	}
+	if err := c.checkKey("delete", key); err != nil {
+		return false, err
+	}
+	n, err := c.DeleteMulti(ctx, []string{key})
+	return n > 0, err
+}
+
+// DeleteMulti removes keys from every tier and returns how many of them
+// were present in at least one. The remote tier is cleared with one batched
+// delete rather than a round trip per key. Errors for individual keys are
+// joined into the returned error.
+func (c *MultiTierCache) DeleteMulti(ctx context.Context, keys []string) (int, error) {
+	var errs []error
+	// Keys held locally are counted here; the remote delete only counts
+	// the rest, so that a key in several tiers is counted once.
+	var local, remoteOnly []string
+	defer func() {
+		c.publishInvalidation(ctx, local...)
+		c.publishInvalidation(ctx, remoteOnly...)
+	}()
+	defer c.dispatchEvictions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		sk := c.storeKey(key)
+		memEntry, memErr := peekEntry(ctx, c.memoryStore, sk)
+		diskEntry, diskErr := c.diskStore.Get(ctx, sk)
+		inLocal := memErr == nil || diskErr == nil
+
+		if c.onEvict != nil {
+			switch {
+			case memErr == nil:
+				c.recordEviction(memEntry, EvictReasonManual)
+			case diskErr == nil:
+				c.recordEviction(diskEntry, EvictReasonManual)
+			default:
+				if entry, err := c.remoteStore.Get(ctx, sk); err == nil {
+					c.recordEviction(entry, EvictReasonManual)
+				}
+			}
+		}
+
+		if c.writeBack != nil {
+			c.writeBack.forget(sk)
+		}
+		c.dirty.remove(sk)
+		c.tags.remove(key)
+		c.negatives.remove(sk)
+
+		if memErr == nil {
+			c.memoryStore.Delete(ctx, sk)
+			c.recordMemoryRemoval(sk)
+		}
+		if diskErr == nil {
+			if err := c.diskStore.Delete(ctx, sk); err != nil {
+				errs = append(errs, &CacheError{Op: "delete", Tier: TierDisk, Key: key, Err: err})
+			}
+		}
+		if inLocal {
+			local = append(local, sk)
+		} else {
+			remoteOnly = append(remoteOnly, sk)
+		}
+	}
+
+	deleted := len(local)
+	n, err := deleteFromStore(ctx, c.remoteStore, remoteOnly)
+	deleted += n
+	if err != nil {
+		errs = append(errs, &CacheError{Op: "delete", Tier: TierRemote, Err: err})
+	}
+	if _, err := deleteFromStore(ctx, c.remoteStore, local); err != nil {
+		errs = append(errs, &CacheError{Op: "delete", Tier: TierRemote, Err: err})
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// deleteFromStore removes keys from store in one call if it supports
+// batch deletes, and returns how many of them it held.
+func deleteFromStore(ctx context.Context, store Store, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if deleter, ok := store.(interface {
+		DeleteMulti(context.Context, []string) (int, error)
+	}); ok {
+		return deleter.DeleteMulti(ctx, keys)
+	}
+
+	deleted := 0
+	var errs []error
+	for _, key := range keys {
+		if _, err := store.Get(ctx, key); err == nil {
+			deleted++
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return deleted, errors.Join(errs...)
 }
 
 func (c *MultiTierCache) Clear(ctx context.Context) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if c.writeBack != nil {
+		c.writeBack.forgetAll()
+	}
+	c.dirty.reset()
+	c.tags.reset()
+	c.negatives.reset()
+
+	if _, ok := c.policy.(EvictionRecorder); ok {
+		for _, entry := range c.memoryStore.GetAll(ctx) {
+			c.recordMemoryRemoval(entry.Key)
+		}
+	}
 	c.memoryStore.Clear(ctx)
 	c.diskStore.Clear(ctx)
+
+	// A namespaced cache may share its remote tier with other caches, so
+	// only remove its own keys there.
+	if c.namespace != "" {
+		if clearer, ok := c.remoteStore.(interface {
+			ClearPrefix(context.Context, string) error
+		}); ok {
+			return clearer.ClearPrefix(ctx, c.namespace+":")
+		}
+	}
 	return c.remoteStore.Clear(ctx)
 }
 
-func (c *MultiTierCache) promoteToMemory(ctx context.Context, entry *CacheEntry) {
-	if c.memoryStore.GetUsage()+entry.Size > c.memoryStore.GetCapacity() {
-		c.evict(ctx, c.memoryStore, entry.Size)
+func (c *MultiTierCache) addLatencyObserver(fn func(op string, d time.Duration)) {
+	c.observersMu.Lock()
+	defer c.observersMu.Unlock()
+	c.latencyObservers = append(c.latencyObservers, fn)
+}
+
+func (c *MultiTierCache) observeLatency(op string, start time.Time) {
+	c.observersMu.RLock()
+	defer c.observersMu.RUnlock()
+
+	if len(c.latencyObservers) == 0 {
+		return
+	}
+	d := time.Since(start)
+	for _, fn := range c.latencyObservers {
+		fn(op, d)
 	}
-	c.memoryStore.Set(ctx, entry)
 }
 
-func (c *MultiTierCache) evict(ctx context.Context, store Store, requiredSpace int) bool {
-	for store.GetCapacity()-store.GetUsage() < requiredSpace {
-		entries := store.GetAll(ctx)
-		if len(entries) == 0 {
-			return false
+// Close stops the background janitor and auto-tuner, if they are running,
+// and flushes any queued write-back entries to the lower tiers before
+// returning.
+func (c *MultiTierCache) Close() error {
+	c.closeOnce.Do(func() {
+		if c.stopJanitor != nil {
+			close(c.stopJanitor)
+			<-c.janitorDone
+		}
+		if c.tuner != nil {
+			close(c.tuner.stop)
+			<-c.tuner.done
+		}
+		if c.writeBack != nil {
+			c.writeBack.close()
+		}
+		if c.invalidator != nil {
+			c.invalidator.unsubscribe()
+		}
+	})
+	return nil
+}
+
+func (c *MultiTierCache) runJanitor(interval time.Duration) {
+	defer close(c.janitorDone)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.purgeExpired(context.Background())
+		case <-c.stopJanitor:
+			return
 		}
-		keyToEvict := c.policy.Choose(entries)
-		if keyToEvict == "" {
-			return false
+	}
+}
+
+func (c *MultiTierCache) purgeExpired(ctx context.Context) {
+	defer c.dispatchEvictions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		for _, entry := range store.GetAll(ctx) {
+			if entry.removable(now) {
+				store.Delete(ctx, entry.Key)
+				if store == c.memoryStore {
+					c.dirty.remove(entry.Key)
+					c.recordMemoryRemoval(entry.Key)
+				}
+				c.recordEviction(entry, EvictReasonExpired)
+			}
 		}
-		evictedEntry, _ := store.Get(ctx, keyToEvict)
-		store.Delete(ctx, keyToEvict)
-		if evictedEntry != nil {
-			c.promoteEvictedEntry(ctx, evictedEntry)
+	}
+}
+
+// promoteToMemory copies entry into memory, reporting whether it did. If
+// memory is full the entry must first win admitPromotion.
+func (c *MultiTierCache) promoteToMemory(ctx context.Context, entry *CacheEntry) bool {
+	if c.promotionHeld(entry.Key) {
+		return false
+	}
+	if !hasRoom(c.memoryStore, entry.Size) && !c.admitPromotion(ctx, entry) {
+		return false
+	}
+	if c.setEvicting(ctx, c.memoryStore, entry) != nil {
+		return false
+	}
+	atomic.AddInt64(&c.statsPromotions, 1)
+	c.notePromotion(entry.Key)
+	return true
+}
+
+// admitPromotion reports whether entry is hotter than the entry the policy
+// would evict from memory to make room for it, so that a cold hit in a
+// lower tier can't push out hot entries. An AdmissionPolicy decides instead
+// when the policy is one.
+func (c *MultiTierCache) admitPromotion(ctx context.Context, entry *CacheEntry) bool {
+	victimKey := c.chooseVictim(ctx, c.memoryStore)
+	if victimKey == "" || victimKey == entry.Key {
+		return true
+	}
+	if admitter, ok := c.policy.(AdmissionPolicy); ok {
+		return admitter.Admit(entry.Key, victimKey)
+	}
+
+	victim, err := peekEntry(ctx, c.memoryStore, victimKey)
+	if err != nil {
+		return true
+	}
+	if entry.Frequency != victim.Frequency {
+		return entry.Frequency > victim.Frequency
+	}
+	return compareLastAccess(entry, victim) > 0
+}
+
+// peekEntry reads key from store without updating its recency, if the
+// store supports that.
+func peekEntry(ctx context.Context, store Store, key string) (*CacheEntry, error) {
+	if peeker, ok := store.(interface {
+		Peek(context.Context, string) (*CacheEntry, error)
+	}); ok {
+		return peeker.Peek(ctx, key)
+	}
+	return store.Get(ctx, key)
+}
+
+// evict removes keyToEvict from store and, unless the cascade is disabled,
+// moves it down a tier. With the cascade disabled, copies of the key in the
+// tiers below that may be older are removed too, so they can't be served
+// in place of the evicted value. It reports false if the key isn't there or its
+// deletion freed nothing, so that callers looping until an entry fits
+// always make progress.
+func (c *MultiTierCache) evict(ctx context.Context, store Store, keyToEvict string) bool {
+	evictedEntry, err := store.Get(ctx, keyToEvict)
+	if err != nil {
+		return false
+	}
+	usage, count := store.GetUsage(), storeLen(store)
+	store.Delete(ctx, keyToEvict)
+	if store.GetUsage() >= usage && (count < 0 || storeLen(store) >= count) {
+		return false
+	}
+	// newer reports whether the evicted value may be newer than the
+	// copies below it: a dirty memory entry, or a disk entry when nothing
+	// writes disk and remote together.
+	var newer bool
+	switch store {
+	case c.memoryStore:
+		newer = c.dirty.has(keyToEvict)
+		c.dirty.remove(keyToEvict)
+		c.recordMemoryRemoval(keyToEvict)
+		atomic.AddInt64(&c.statsMemoryEvictions, 1)
+		c.noteMemoryEviction(keyToEvict)
+	case c.diskStore:
+		newer = !c.writeThrough && c.writeBack == nil
+		atomic.AddInt64(&c.statsDiskEvictions, 1)
+	}
+	c.recordEviction(evictedEntry, EvictReasonCapacity)
+	if c.evictionCascade {
+		c.promoteEvictedEntry(ctx, store, evictedEntry)
+	} else if newer {
+		for _, lower := range c.lowerTiers(store) {
+			lower.Delete(ctx, keyToEvict)
 		}
 	}
 	return true
 }
 
-func (c *MultiTierCache) promoteEvictedEntry(ctx context.Context, entry *CacheEntry) {
-	if store, ok := c.getNextTier(entry); ok {
-		store.Set(ctx, entry)
+// storeLen returns the number of entries in store, or -1 if it doesn't
+// report one.
+func storeLen(store Store) int {
+	if counter, ok := store.(interface{ Len() int }); ok {
+		return counter.Len()
+	}
+	return -1
+}
+
+// hasRoom reports whether store can take size more bytes and, if it limits
+// its entry count, another entry.
+func hasRoom(store Store, size int) bool {
+	if store.GetCapacity()-store.GetUsage() < size {
+		return false
+	}
+	if limited, ok := store.(interface {
+		Len() int
+		MaxEntries() int
+	}); ok && limited.MaxEntries() > 0 {
+		return limited.Len() < limited.MaxEntries()
+	}
+	return true
+}
+
+// chooseVictims returns the next keys to evict from store: a batch of up to
+// evictionBatchSize when the policy supports that and would otherwise scan
+// the store for every victim, or else the single victim from chooseVictim.
+func (c *MultiTierCache) chooseVictims(ctx context.Context, store Store) []string {
+	if chooser, ok := c.policy.(BatchChooser); ok && c.evictionBatchSize > 1 && !c.choosesFromStore(store) {
+		return chooser.ChooseN(entryMetadata(ctx, store), c.evictionBatchSize)
+	}
+	if key := c.chooseVictim(ctx, store); key != "" {
+		return []string{key}
+	}
+	return nil
+}
+
+// entryMetadata returns copies of store's entries without their values,
+// which is all a policy needs. Stores with ForEach are copied into a single
+// allocation.
+func entryMetadata(ctx context.Context, store Store) []*CacheEntry {
+	it, ok := store.(EntryIterator)
+	if !ok {
+		return store.GetAll(ctx)
+	}
+	copies := make([]CacheEntry, 0, max(storeLen(store), 0))
+	it.ForEach(ctx, func(entry *CacheEntry) bool {
+		copies = append(copies, *entry)
+		copies[len(copies)-1].Value = nil
+		return true
+	})
+	entries := make([]*CacheEntry, len(copies))
+	for i := range copies {
+		entries[i] = &copies[i]
+	}
+	return entries
+}
+
+// choosesFromStore reports whether the policy picks victims from store
+// without scanning it.
+func (c *MultiTierCache) choosesFromStore(store Store) bool {
+	_, ordered := store.(RecencyOrdered)
+	_, chooser := c.policy.(interface {
+		ChooseFromStore(Store) (string, bool)
+	})
+	return ordered && chooser
+}
+
+// chooseVictim asks the policy for the next key to evict from store,
+// letting it consult the store directly or stream its entries when both
+// support that instead of copying every entry.
+func (c *MultiTierCache) chooseVictim(ctx context.Context, store Store) string {
+	if chooser, ok := c.policy.(interface {
+		ChooseFromStore(Store) (string, bool)
+	}); ok {
+		if key, ok := chooser.ChooseFromStore(store); ok {
+			return key
+		}
+	}
+
+	if chooser, ok := c.policy.(SeqChooser); ok {
+		if it, ok := store.(EntryIterator); ok {
+			return chooser.ChooseSeq(func(yield func(*CacheEntry) bool) {
+				it.ForEach(ctx, yield)
+			})
+		}
 	}
+
+	entries := store.GetAll(ctx)
+	if len(entries) == 0 {
+		return ""
+	}
+	return c.policy.Choose(entries)
 }
 
-func (c *MultiTierCache) getNextTier(entry *CacheEntry) (Store, bool) {
-	switch {
-	case entry.Size <= c.memoryStore.GetCapacity():
-		return c.diskStore, true
-	case entry.Size <= c.diskStore.GetCapacity():
-		return c.remoteStore, true
-	default:
-		return nil, false
+// promoteEvictedEntry moves an entry evicted from store down to the nearest
+// lower tier that can hold it. Making room there may evict further entries,
+// which cascade the same way.
+func (c *MultiTierCache) promoteEvictedEntry(ctx context.Context, from Store, entry *CacheEntry) {
+	for _, store := range c.lowerTiers(from) {
+		if store == c.remoteStore {
+			if store.Set(ctx, entry) == nil {
+				c.noteRemoteKey(entry.Key)
+			}
+			return
+		}
+		if entry.Size > store.GetCapacity() {
+			continue
+		}
+		if c.setInStore(ctx, store, entry) == nil {
+			return
+		}
 	}
 }
 
+// lowerTiers returns the tiers below store, nearest first.
+func (c *MultiTierCache) lowerTiers(store Store) []Store {
+	tiers := []Store{c.memoryStore, c.diskStore, c.remoteStore}
+	for i, tier := range tiers {
+		if tier == store {
+			return tiers[i+1:]
+		}
+	}
+	return nil
+}
+
 func (c *MultiTierCache) getEntries(ctx context.Context, store Store) []*CacheEntry {
 	var entries []*CacheEntry
 	for _, key := range c.Keys(ctx) {
@@ -217,32 +1617,164 @@ func (c *MultiTierCache) getEntries(ctx context.Context, store Store) []*CacheEn
 	return entries
 }
 
+// Keys returns the keys in every tier, each once, in memory, disk, remote
+// order.
 func (c *MultiTierCache) Keys(ctx context.Context) []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
+	seen := make(map[string]struct{})
 	var keys []string
 	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
 		if keysGetter, ok := store.(interface {
 			Keys(context.Context) []string
 		}); ok {
-			keys = append(keys, keysGetter.Keys(ctx)...)
+			for _, k := range keysGetter.Keys(ctx) {
+				key, ok := c.userKey(k)
+				if !ok {
+					continue
+				}
+				if _, dup := seen[key]; dup {
+					continue
+				}
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
 		}
 	}
 	return keys
 }
 
-func (c *MultiTierCache) GetStats() (hits, misses int64) {
+// KeysMatching returns the keys in any tier that match the glob pattern,
+// each once. Patterns follow Redis rules: '*' matches any run of
+// characters, '?' a single one, and '[...]' a character class.
+func (c *MultiTierCache) KeysMatching(ctx context.Context, pattern string) []string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return c.statsHits, c.statsMisses
+
+	storePattern := pattern
+	if c.namespace != "" {
+		storePattern = escapeGlob(c.namespace+":") + pattern
+	}
+
+	seen := make(map[string]struct{})
+	keys := []string{}
+	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+		var storeKeys []string
+		if matcher, ok := store.(interface {
+			KeysMatching(context.Context, string) []string
+		}); ok {
+			storeKeys = matcher.KeysMatching(ctx, storePattern)
+		} else if keysGetter, ok := store.(interface {
+			Keys(context.Context) []string
+		}); ok {
+			for _, k := range keysGetter.Keys(ctx) {
+				if matchGlob(storePattern, k) {
+					storeKeys = append(storeKeys, k)
+				}
+			}
+		}
+
+		for _, k := range storeKeys {
+			key, ok := c.userKey(k)
+			if !ok {
+				continue
+			}
+			if _, dup := seen[key]; dup {
+				continue
+			}
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
-func (c *MultiTierCache) ResetStats() {
+// storeKey maps a caller's key to the key written to the stores.
+func (c *MultiTierCache) storeKey(key string) string {
+	if c.namespace == "" {
+		return key
+	}
+	return c.namespace + ":" + key
+}
+
+// userKey reverses storeKey, reporting false for keys outside the
+// cache's namespace.
+func (c *MultiTierCache) userKey(key string) (string, bool) {
+	if c.namespace == "" {
+		return key, true
+	}
+	return strings.CutPrefix(key, c.namespace+":")
+}
+
+func (c *MultiTierCache) GetStats() (hits, misses int64) {
+	s := c.GetTierStats()
+	return s.MemoryHits + s.DiskHits + s.RemoteHits, s.Misses
+}
+
+func (c *MultiTierCache) GetTierStats() TierStats {
+	return TierStats{
+		MemoryHits: atomic.LoadInt64(&c.statsMemoryHits),
+		DiskHits:   atomic.LoadInt64(&c.statsDiskHits),
+		RemoteHits: atomic.LoadInt64(&c.statsRemoteHits),
+		Misses:     atomic.LoadInt64(&c.statsMisses),
+	}
+}
+
+// GetCacheStats returns the hit counters together with eviction and
+// promotion counts. Steadily rising evictions suggest a tier is undersized.
+func (c *MultiTierCache) GetCacheStats() CacheStats {
+	return CacheStats{
+		TierStats:       c.GetTierStats(),
+		MemoryEvictions: atomic.LoadInt64(&c.statsMemoryEvictions),
+		DiskEvictions:   atomic.LoadInt64(&c.statsDiskEvictions),
+		Promotions:      atomic.LoadInt64(&c.statsPromotions),
+		Thrashes:        atomic.LoadInt64(&c.statsThrashes),
+	}
+}
+
+// HitRatio returns the fraction of lookups served by any tier, or 0 if
+// there have been none.
+func (c *MultiTierCache) HitRatio() float64 {
+	hits, misses := c.GetStats()
+	return hitRatio(hits, misses)
+}
+
+func hitRatio(hits, misses int64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// Stats returns the hit, eviction and promotion counters together with the
+// derived hit ratio and the memory and disk tiers' usage and capacity. It
+// holds the write lock while reading them, so no operation is counted
+// halfway through.
+func (c *MultiTierCache) Stats() Stats {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.statsHits = 0
-	c.statsMisses = 0
+
+	s := Stats{CacheStats: c.GetCacheStats()}
+	s.Hits = s.MemoryHits + s.DiskHits + s.RemoteHits
+	s.HitRatio = hitRatio(s.Hits, s.Misses)
+	s.Evictions = s.MemoryEvictions + s.DiskEvictions
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		s.Usage += store.GetUsage()
+		s.Capacity += store.GetCapacity()
+	}
+	return s
+}
+
+func (c *MultiTierCache) ResetStats() {
+	atomic.StoreInt64(&c.statsMemoryHits, 0)
+	atomic.StoreInt64(&c.statsDiskHits, 0)
+	atomic.StoreInt64(&c.statsRemoteHits, 0)
+	atomic.StoreInt64(&c.statsMisses, 0)
+	atomic.StoreInt64(&c.statsMemoryEvictions, 0)
+	atomic.StoreInt64(&c.statsDiskEvictions, 0)
+	atomic.StoreInt64(&c.statsPromotions, 0)
+	atomic.StoreInt64(&c.statsThrashes, 0)
 }
 
 func (c *MultiTierCache) MemoryStore() Store {
@@ -1,10 +1,27 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func TestMultiTierCache(t *testing.T) {
@@ -33,8 +50,8 @@ func TestMultiTierCache(t *testing.T) {
 
 	t.Run("Cache Miss", func(t *testing.T) {
 		_, err := cache.Get(ctx, "nonexistent")
-		if err == nil {
-			t.Error("Expected error for nonexistent key, got nil")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound for nonexistent key, got %v", err)
 		}
 	})
 
@@ -45,8 +62,8 @@ func TestMultiTierCache(t *testing.T) {
 		}
 
 		_, err = cache.Get(ctx, "key1")
-		if err == nil {
-			t.Error("Expected error after deleting key1, got nil")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound after deleting key1, got %v", err)
 		}
 	})
 
@@ -62,8 +79,8 @@ func TestMultiTierCache(t *testing.T) {
 		}
 
 		_, err = cache.Get(ctx, "key2")
-		if err == nil {
-			t.Error("Expected error after clearing cache, got nil")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound after clearing cache, got %v", err)
 		}
 	})
 
@@ -81,14 +98,21 @@ func TestMultiTierCache(t *testing.T) {
 	})
 
 	t.Run("Eviction", func(t *testing.T) {
-		smallCache, _ := NewMultiTierCache(20, 40, "localhost:6379", &LRUPolicy{})
+		clock := NewFakeClock(time.Now())
+		smallCache, _ := NewCache(
+			WithMemoryCapacity(20),
+			WithDiskCapacity(40),
+			WithRemote(RemoteStoreConfig{Addr: "localhost:6379"}),
+			WithPolicy(&LRUPolicy{}),
+			WithClock(clock),
+		)
 
 		ctx := context.Background()
 
 		smallCache.Set(ctx, "key1", []byte("value1")) // 6 bytes
-		time.Sleep(10 * time.Millisecond)
+		clock.Advance(10 * time.Millisecond)
 		smallCache.Set(ctx, "key2", []byte("value2")) // 6 bytes
-		time.Sleep(10 * time.Millisecond)
+		clock.Advance(10 * time.Millisecond)
 		smallCache.Set(ctx, "key3", []byte("value3")) // 6 bytes
 		// Total: 18 bytes
 
@@ -219,6 +243,40 @@ func TestSimulatedRemoteStore(t *testing.T) {
 		}
 	})
 
+	t.Run("SimulatedIterate", func(t *testing.T) {
+		for i := 0; i < 500; i++ {
+			remoteStore.Set(ctx, &CacheEntry{Key: fmt.Sprintf("iter%d", i), Value: []byte("value")})
+		}
+
+		visited := make(map[string]int)
+		err := remoteStore.Iterate(ctx, func(entry *CacheEntry) bool {
+			visited[entry.Key]++
+			return true
+		})
+		if err != nil {
+			t.Fatalf("Failed to iterate: %v", err)
+		}
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("iter%d", i)
+			if visited[key] != 1 {
+				t.Errorf("Expected %s to be visited exactly once, got %d", key, visited[key])
+			}
+		}
+
+		count := 0
+		remoteStore.Iterate(ctx, func(entry *CacheEntry) bool {
+			count++
+			return count < 10
+		})
+		if count != 10 {
+			t.Errorf("Expected iteration to stop after 10 entries, got %d", count)
+		}
+
+		if got := len(remoteStore.GetAll(ctx)); got != len(visited) {
+			t.Errorf("Expected GetAll to return %d entries, got %d", len(visited), got)
+		}
+	})
+
 	t.Run("SimulatedGetMetrics", func(t *testing.T) {
 		metrics, err := remoteStore.GetMetrics(ctx)
 		if err != nil {
@@ -234,6 +292,9 @@ func TestSimulatedRemoteStore(t *testing.T) {
 		if metrics.UsagePercent < 0 || metrics.UsagePercent > 100 {
 			t.Errorf("Expected usage percent between 0 and 100, got %f", metrics.UsagePercent)
 		}
+		if metrics.KeyCount != 501 {
+			t.Errorf("Expected a key count of 501, got %d", metrics.KeyCount)
+		}
 
 		capacity := remoteStore.GetCapacity()
 		if capacity != 100*1024*1024 { // 100MB
@@ -247,6 +308,21 @@ func TestSimulatedRemoteStore(t *testing.T) {
 	})
 }
 
+func TestParseKeyspaceStats(t *testing.T) {
+	info := "# Stats\r\ntotal_connections_received:3\r\nkeyspace_hits:42\r\nkeyspace_misses:7\r\nexpired_keys:0\r\n"
+	hits, misses, err := parseKeyspaceStats(info)
+	if err != nil {
+		t.Fatalf("Failed to parse stats: %v", err)
+	}
+	if hits != 42 || misses != 7 {
+		t.Errorf("Expected 42 hits and 7 misses, got %d and %d", hits, misses)
+	}
+
+	if _, _, err := parseKeyspaceStats("keyspace_hits:lots\r\n"); err == nil {
+		t.Error("Expected an error for a malformed keyspace_hits")
+	}
+}
+
 func TestNonSimulatedRemoteStore(t *testing.T) {
 	// Skip this test if Redis is not available
 	if os.Getenv("REDIS_AVAILABLE") != "true" {
@@ -275,6 +351,51 @@ func TestNonSimulatedRemoteStore(t *testing.T) {
 		}
 	})
 
+	t.Run("NonSimulatedClearLeavesForeignKeys", func(t *testing.T) {
+		prefixed, err := NewRemoteStoreWithConfig(RemoteStoreConfig{Addr: "localhost:6379", KeyPrefix: "go-cache-test:"})
+		if err != nil {
+			t.Fatalf("Failed to create prefixed remote store: %v", err)
+		}
+		defer prefixed.client.Close()
+		if err := remoteStore.client.Set(ctx, "unrelated", "keep", 0).Err(); err != nil {
+			t.Fatalf("Failed to set unrelated key: %v", err)
+		}
+		defer remoteStore.client.Del(ctx, "unrelated")
+
+		prefixed.Set(ctx, &CacheEntry{Key: "owned", Value: []byte("value")})
+		if err := prefixed.Clear(ctx); err != nil {
+			t.Fatalf("Failed to clear remote store: %v", err)
+		}
+
+		if _, err := prefixed.Get(ctx, "owned"); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected owned key to be cleared, got %v", err)
+		}
+		if val, err := remoteStore.client.Get(ctx, "unrelated").Result(); err != nil || val != "keep" {
+			t.Errorf("Expected unrelated key to survive Clear. Error: %v, Value: %s", err, val)
+		}
+	})
+
+	t.Run("NonSimulatedIterate", func(t *testing.T) {
+		remoteStore.Clear(ctx)
+		for i := 0; i < 1000; i++ {
+			remoteStore.Set(ctx, &CacheEntry{Key: fmt.Sprintf("iter%d", i), Value: []byte("value")})
+		}
+
+		visited := make(map[string]int)
+		if err := remoteStore.Iterate(ctx, func(entry *CacheEntry) bool {
+			visited[entry.Key]++
+			return true
+		}); err != nil {
+			t.Fatalf("Failed to iterate: %v", err)
+		}
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("iter%d", i)
+			if visited[key] != 1 {
+				t.Errorf("Expected %s to be visited exactly once, got %d", key, visited[key])
+			}
+		}
+	})
+
 	t.Run("NonSimulatedGetMetrics", func(t *testing.T) {
 		metrics, err := remoteStore.GetMetrics(ctx)
 		if err != nil {
@@ -284,6 +405,9 @@ func TestNonSimulatedRemoteStore(t *testing.T) {
 		if metrics.Capacity <= 0 {
 			t.Errorf("Expected positive capacity, got %d", metrics.Capacity)
 		}
+		if metrics.KeyCount <= 0 {
+			t.Errorf("Expected a positive key count, got %d", metrics.KeyCount)
+		}
 		if metrics.Usage <= 0 {
 			t.Errorf("Expected positive usage, got %d", metrics.Usage)
 		}
@@ -302,3 +426,3753 @@ func TestNonSimulatedRemoteStore(t *testing.T) {
 		}
 	})
 }
+
+func newSimulatedCache(t *testing.T, memCap, diskCap int) *MultiTierCache {
+	t.Helper()
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	c, err := NewMultiTierCache(memCap, diskCap, "localhost:6379", &LRUPolicy{})
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return c
+}
+
+func TestRemoteStoreTimesOutOnHungServer(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "false")
+
+	// A server that accepts connections but never replies.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err = NewRemoteStoreWithConfig(RemoteStoreConfig{
+		Addr:            ln.Addr().String(),
+		ReadTimeout:     50 * time.Millisecond,
+		WriteTimeout:    50 * time.Millisecond,
+		MaxRetries:      2,
+		MinRetryBackoff: time.Millisecond,
+		MaxRetryBackoff: 5 * time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("Expected connecting to a hung server to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the read timeout to bound the call, took %v", elapsed)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(WithRemoteStore(remote))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	want := map[string]error{"memory": nil, "disk": nil, "remote": nil}
+	if got := c.HealthCheck(ctx); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected every tier healthy with a reachable remote, got %v", got)
+	}
+
+	refused := errors.New("connection refused")
+	remote.simulateErr = refused
+	health := c.HealthCheck(ctx)
+	if !errors.Is(health["remote"], refused) {
+		t.Errorf("Expected the remote tier to report %v, got %v", refused, health["remote"])
+	}
+	if health["memory"] != nil || health["disk"] != nil {
+		t.Errorf("Expected the local tiers to stay healthy, got %v", health)
+	}
+}
+
+// testClusterStore sets, lists, reads and clears keys that spread over
+// many cluster slots.
+func testClusterStore(t *testing.T, store *RemoteStore) {
+	t.Helper()
+	ctx := context.Background()
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		if err := store.Set(ctx, &CacheEntry{Key: keys[i], Value: []byte(keys[i])}); err != nil {
+			t.Fatalf("Set(%s) failed: %v", keys[i], err)
+		}
+	}
+	if got := store.Keys(ctx); len(got) != len(keys) {
+		t.Errorf("Expected Keys to find %d keys across the cluster, got %d", len(keys), len(got))
+	}
+	if got := store.GetAll(ctx); len(got) != len(keys) {
+		t.Errorf("Expected GetAll to return %d entries, got %d", len(keys), len(got))
+	}
+	entries, err := store.GetMulti(ctx, append(keys[:10:10], "missing"))
+	if err != nil || len(entries) != 10 || string(entries["key3"].Value) != "key3" {
+		t.Errorf("Expected GetMulti to read 10 keys across slots, got %d entries, %v", len(entries), err)
+	}
+	if metrics, err := store.GetMetrics(ctx); err != nil || metrics.KeyCount < int64(len(keys)) {
+		t.Errorf("Expected metrics summed over the nodes, got %+v, %v", metrics, err)
+	}
+	if n, err := store.DeleteMulti(ctx, keys[:10]); err != nil || n != 10 {
+		t.Errorf("Expected DeleteMulti to delete 10 keys across slots, got %d, %v", n, err)
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if got := store.Keys(ctx); len(got) != 0 {
+		t.Errorf("Expected Clear to empty every node, got %v", got)
+	}
+}
+
+func TestRemoteStoreCluster(t *testing.T) {
+	addrs := os.Getenv("REDIS_CLUSTER_ADDRS")
+	if addrs == "" {
+		t.Skip("REDIS_CLUSTER_ADDRS is not set")
+	}
+	t.Setenv("SIMULATE_REMOTE_STORE", "false")
+	store, err := NewRemoteStoreCluster(strings.Split(addrs, ","), RemoteStoreConfig{KeyPrefix: "go-cache-test:"})
+	if err != nil {
+		t.Fatalf("Failed to connect to the cluster: %v", err)
+	}
+	testClusterStore(t, store)
+}
+
+func TestRemoteStoreClusterSimulated(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	store, err := NewRemoteStoreCluster([]string{"localhost:7000"}, RemoteStoreConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create simulated cluster store: %v", err)
+	}
+	testClusterStore(t, store)
+}
+
+// capturingLogger records every message it is given with its level.
+type capturingLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *capturingLogger) log(level, msg string, keysAndValues []any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	line := fmt.Sprintln(append([]any{level, msg}, keysAndValues...)...)
+	l.events = append(l.events, strings.TrimSuffix(line, "\n"))
+}
+
+func (l *capturingLogger) Debug(msg string, kv ...any) { l.log("debug", msg, kv) }
+func (l *capturingLogger) Info(msg string, kv ...any)  { l.log("info", msg, kv) }
+func (l *capturingLogger) Warn(msg string, kv ...any)  { l.log("warn", msg, kv) }
+
+func (l *capturingLogger) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := l.events
+	l.events = nil
+	return events
+}
+
+func TestLogger(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	logger := &capturingLogger{}
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithWriteThrough(),
+		WithRemote(RemoteStoreConfig{Addr: "localhost:6379"}), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	want := []string{"info simulating remote store connection"}
+	if got := logger.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q on creation, got %q", want, got)
+	}
+
+	c.Set(ctx, "key", []byte("value"))
+	c.remoteStore.Get(ctx, "key")
+	want = []string{
+		"debug simulated remote command op set key key",
+		"debug simulated remote command op get key key",
+	}
+	if got := logger.take(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// A store whose server is unreachable warns when its usage is read.
+	remote := &RemoteStore{
+		client:  redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1}),
+		logger:  logger,
+		breaker: newCircuitBreaker(0, 0),
+	}
+	defer remote.client.Close()
+	if remote.GetUsage() != 0 {
+		t.Error("Expected zero usage from an unreachable server")
+	}
+	events := logger.take()
+	if len(events) != 1 || !strings.HasPrefix(events[0], "warn reading remote usage failed op usage err ") {
+		t.Errorf("Expected one usage warning, got %q", events)
+	}
+}
+
+func TestTierStats(t *testing.T) {
+	c := newSimulatedCache(t, 100, 1000)
+	ctx := context.Background()
+
+	c.Set(ctx, "mem", []byte("value"))
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("value"), Size: 5})
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("value"), Size: 5})
+
+	c.Get(ctx, "mem")
+	c.Get(ctx, "disk")
+	c.Get(ctx, "remote")
+	c.Get(ctx, "nonexistent")
+
+	stats := c.GetTierStats()
+	want := TierStats{MemoryHits: 1, DiskHits: 1, RemoteHits: 1, Misses: 1}
+	if stats != want {
+		t.Errorf("Unexpected tier stats. Got %+v, want %+v", stats, want)
+	}
+
+	hits, misses := c.GetStats()
+	if hits != 3 || misses != 1 {
+		t.Errorf("Unexpected stats. Got hits=%d, misses=%d, want hits=3, misses=1", hits, misses)
+	}
+
+	c.ResetStats()
+	if stats := c.GetTierStats(); stats != (TierStats{}) {
+		t.Errorf("Expected zeroed tier stats after reset, got %+v", stats)
+	}
+}
+
+func TestCacheWithoutRemote(t *testing.T) {
+	c, err := NewMultiTierCache(10, 20, "", &LRUPolicy{})
+	if err != nil {
+		t.Fatalf("Failed to create cache without remote: %v", err)
+	}
+	if _, ok := c.RemoteStore().(*NullStore); !ok {
+		t.Fatalf("Expected NullStore remote tier, got %T", c.RemoteStore())
+	}
+
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "small", []byte("value")); err != nil {
+		t.Errorf("Failed to set small: %v", err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "small"); err != nil {
+		t.Errorf("Expected small to land in memory, got error: %v", err)
+	}
+
+	if err := c.Set(ctx, "medium", []byte("fifteen bytes!!")); err != nil {
+		t.Errorf("Failed to set medium: %v", err)
+	}
+	if _, err := c.diskStore.Get(ctx, "medium"); err != nil {
+		t.Errorf("Expected medium to land on disk, got error: %v", err)
+	}
+
+	if err := c.RemoteStore().Set(ctx, &CacheEntry{Key: "huge", Value: make([]byte, 100), Size: 100}); err != nil {
+		t.Errorf("Expected remote no-op for huge value, got error: %v", err)
+	}
+	if _, err := c.Get(ctx, "huge"); err == nil {
+		t.Error("Expected miss for value only written to the null remote, got nil")
+	}
+
+	if err := c.Delete(ctx, "small"); err != nil {
+		t.Errorf("Failed to delete small: %v", err)
+	}
+	if err := c.Clear(ctx); err != nil {
+		t.Errorf("Failed to clear cache: %v", err)
+	}
+}
+
+func TestNewCacheOptions(t *testing.T) {
+	policy := &LRUPolicy{}
+	c, err := NewCache(
+		WithMemoryCapacity(10),
+		WithDiskCapacity(20),
+		WithPolicy(policy),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	if got := c.MemoryStore().GetCapacity(); got != 10 {
+		t.Errorf("Unexpected memory capacity. Got %d, want 10", got)
+	}
+	if got := c.DiskStore().GetCapacity(); got != 20 {
+		t.Errorf("Unexpected disk capacity. Got %d, want 20", got)
+	}
+	if c.policy != policy {
+		t.Error("Expected configured policy to be used")
+	}
+	if _, ok := c.RemoteStore().(*NullStore); !ok {
+		t.Errorf("Expected NullStore remote tier without WithRemote, got %T", c.RemoteStore())
+	}
+}
+
+func TestSetWithTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	c.SetWithTTL(ctx, "short", []byte("value"), 20*time.Millisecond)
+	c.Set(ctx, "forever", []byte("value"))
+
+	if _, err := c.Get(ctx, "short"); err != nil {
+		t.Errorf("Expected short to be present before expiry, got error: %v", err)
+	}
+
+	clock.Advance(30 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "short"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound for expired key, got %v", err)
+	}
+	if _, err := c.Get(ctx, "forever"); err != nil {
+		t.Errorf("Expected forever to be present, got error: %v", err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c, err := NewCache(WithMemoryCapacity(10), WithDiskCapacity(1000), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	// "disk" is evicted to the disk tier by "memory".
+	c.SetWithTTL(ctx, "disk", []byte("value-01"), time.Second)
+	c.SetWithTTL(ctx, "memory", []byte("value-02"), time.Second)
+
+	clock.Advance(500 * time.Millisecond)
+	for _, key := range []string{"disk", "memory"} {
+		if err := c.Touch(ctx, key, time.Second); err != nil {
+			t.Fatalf("Touch(%q) failed: %v", key, err)
+		}
+	}
+	clock.Advance(800 * time.Millisecond)
+
+	for key, want := range map[string]string{"disk": "value-01", "memory": "value-02"} {
+		value, err := c.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Expected %s to outlive its original TTL, got error: %v", key, err)
+		}
+		if string(value) != want {
+			t.Errorf("Expected %s to keep value %q, got %q", key, want, value)
+		}
+	}
+
+	clock.Advance(300 * time.Millisecond)
+	if _, err := c.Get(ctx, "memory"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after the new TTL, got %v", err)
+	}
+	if err := c.Touch(ctx, "missing", time.Second); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound touching a missing key, got %v", err)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	c, err := NewCache(
+		WithMemoryCapacity(100),
+		WithDiskCapacity(1000),
+		WithJanitorInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	c.SetWithTTL(ctx, "short", []byte("value"), 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := c.memoryStore.Get(ctx, "short"); err == nil {
+		t.Error("Expected janitor to purge expired key from memory")
+	}
+	if usage := c.memoryStore.GetUsage(); usage != 0 {
+		t.Errorf("Expected memory usage 0 after purge, got %d", usage)
+	}
+}
+
+func TestPeekDoesNotAffectEviction(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(10), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "a", []byte("aaaa"))
+	c.Set(ctx, "b", []byte("bbbb"))
+	if value, err := c.Peek(ctx, "a"); err != nil || string(value) != "aaaa" {
+		t.Fatalf("Peek = %q, %v", value, err)
+	}
+
+	// "a" is still least recently used, so it makes room for "c".
+	c.Set(ctx, "c", []byte("cccc"))
+	if _, err := c.memoryStore.Get(ctx, "a"); err == nil {
+		t.Error("Expected Peek not to refresh a's recency")
+	}
+	if _, err := c.memoryStore.Get(ctx, "b"); err != nil {
+		t.Error("Expected b to stay in memory")
+	}
+
+	// a now lives on disk; peeking it neither promotes nor counts a hit.
+	before, _ := c.diskStore.Get(ctx, "a")
+	if value, err := c.Peek(ctx, "a"); err != nil || string(value) != "aaaa" {
+		t.Fatalf("Peek from disk = %q, %v", value, err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "a"); err == nil {
+		t.Error("Expected Peek not to promote")
+	}
+	if after, _ := c.diskStore.Get(ctx, "a"); after.Frequency != before.Frequency || !after.LastAccess.Equal(before.LastAccess) {
+		t.Errorf("Expected Peek not to update access metadata, got %+v, want %+v", after, before)
+	}
+	if stats := c.GetTierStats(); stats != (TierStats{}) {
+		t.Errorf("Expected Peek not to touch stats, got %+v", stats)
+	}
+
+	if _, err := c.Peek(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestHas(t *testing.T) {
+	c := newSimulatedCache(t, 100, 1000)
+	ctx := context.Background()
+
+	c.Set(ctx, "mem", []byte("value"))
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("value"), Size: 5})
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("value"), Size: 5})
+
+	for _, key := range []string{"mem", "disk", "remote"} {
+		if !c.Has(ctx, key) {
+			t.Errorf("Expected Has(%q) to be true", key)
+		}
+	}
+	if c.Has(ctx, "nonexistent") {
+		t.Error("Expected Has(nonexistent) to be false")
+	}
+
+	hits, misses := c.GetStats()
+	if hits != 0 || misses != 0 {
+		t.Errorf("Expected Has to leave stats unchanged. Got hits=%d, misses=%d", hits, misses)
+	}
+	if _, err := c.memoryStore.Get(ctx, "disk"); err == nil {
+		t.Error("Expected Has not to promote disk key to memory")
+	}
+	if _, err := c.diskStore.Get(ctx, "disk"); err != nil {
+		t.Errorf("Expected disk key to remain on disk, got error: %v", err)
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	c, err := NewCache(
+		WithMemoryCapacity(12),
+		WithDiskCapacity(100),
+		WithRemote(RemoteStoreConfig{Addr: "localhost:6379"}),
+		WithWriteThrough(),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	c.Set(ctx, "key1", []byte("value1"))
+	time.Sleep(time.Millisecond)
+	c.Set(ctx, "key2", []byte("value2"))
+	time.Sleep(time.Millisecond)
+	c.Set(ctx, "key3", []byte("value3")) // evicts key1 from memory
+
+	if _, err := c.memoryStore.Get(ctx, "key1"); err == nil {
+		t.Fatal("Expected key1 to be evicted from memory")
+	}
+	for _, key := range []string{"key1", "key2", "key3"} {
+		if _, err := c.remoteStore.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to be written through to remote, got error: %v", key, err)
+		}
+	}
+
+	c.memoryStore.Clear(ctx)
+	c.diskStore.Clear(ctx)
+	value, err := c.Get(ctx, "key1")
+	if err != nil || string(value) != "value1" {
+		t.Errorf("Expected key1 to be served from remote. Error: %v, Value: %s", err, string(value))
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	c, err := NewCache(
+		WithMemoryCapacity(1000),
+		WithDiskCapacity(1000),
+		WithRemote(RemoteStoreConfig{Addr: "localhost:6379"}),
+		WithWriteBack(4),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		if err := c.Set(ctx, fmt.Sprintf("key%d", i), []byte("value")); err != nil {
+			t.Fatalf("Failed to set key%d: %v", i, err)
+		}
+	}
+	c.Set(ctx, "deleted", []byte("value"))
+	c.Delete(ctx, "deleted")
+
+	if _, err := c.memoryStore.Get(ctx, "key0"); err != nil {
+		t.Errorf("Expected key0 to land in memory immediately, got error: %v", err)
+	}
+
+	c.Close()
+
+	for i := 0; i < 20; i++ {
+		if _, err := c.remoteStore.Get(ctx, fmt.Sprintf("key%d", i)); err != nil {
+			t.Errorf("Expected key%d to be flushed to remote on Close, got error: %v", i, err)
+		}
+	}
+	if _, err := c.remoteStore.Get(ctx, "deleted"); err == nil {
+		t.Error("Expected deleted key not to be persisted")
+	}
+
+	// Sets after Close are persisted synchronously.
+	c.Set(ctx, "late", []byte("value"))
+	if _, err := c.remoteStore.Get(ctx, "late"); err != nil {
+		t.Errorf("Expected late key to be persisted synchronously, got error: %v", err)
+	}
+}
+
+func TestWriteBackQueueBackpressure(t *testing.T) {
+	q := newWriteBackQueue(1, BackpressureSync)
+
+	if !q.enqueue(&CacheEntry{Key: "key1"}) {
+		t.Error("Expected first entry to be queued")
+	}
+	if q.enqueue(&CacheEntry{Key: "key2"}) {
+		t.Error("Expected full queue to fall back to a synchronous write")
+	}
+	if _, ok := q.pending["key2"]; ok {
+		t.Error("Expected rejected entry not to be left pending")
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	t.Run("ConcurrentCallersShareLoader", func(t *testing.T) {
+		var calls int32
+		loader := func(ctx context.Context) ([]byte, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(50 * time.Millisecond)
+			return []byte("loaded"), nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				value, err := c.GetOrLoad(ctx, "key1", loader)
+				if err != nil || string(value) != "loaded" {
+					t.Errorf("Unexpected GetOrLoad result. Error: %v, Value: %s", err, string(value))
+				}
+			}()
+		}
+		wg.Wait()
+
+		if calls != 1 {
+			t.Errorf("Expected loader to be invoked once, got %d", calls)
+		}
+		if value, err := c.Get(ctx, "key1"); err != nil || string(value) != "loaded" {
+			t.Errorf("Expected loaded value to be cached. Error: %v, Value: %s", err, string(value))
+		}
+	})
+
+	t.Run("LoaderErrorNotCached", func(t *testing.T) {
+		loadErr := errors.New("backend down")
+		_, err := c.GetOrLoad(ctx, "key2", func(ctx context.Context) ([]byte, error) {
+			return nil, loadErr
+		})
+		if !errors.Is(err, loadErr) {
+			t.Errorf("Expected loader error, got %v", err)
+		}
+
+		value, err := c.GetOrLoad(ctx, "key2", func(ctx context.Context) ([]byte, error) {
+			return []byte("recovered"), nil
+		})
+		if err != nil || string(value) != "recovered" {
+			t.Errorf("Expected loader to run again after error. Error: %v, Value: %s", err, string(value))
+		}
+	})
+}
+
+func TestWithLoader(t *testing.T) {
+	var calls int32
+	loadErr := errors.New("backend down")
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000),
+		WithLoader(func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			atomic.AddInt32(&calls, 1)
+			switch key {
+			case "missing":
+				return nil, time.Minute, ErrKeyNotFound
+			case "failing":
+				return nil, time.Minute, loadErr
+			}
+			time.Sleep(20 * time.Millisecond)
+			return []byte("loaded:" + key), time.Minute, nil
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := c.Get(ctx, "key"); err != nil || string(value) != "loaded:key" {
+				t.Errorf("Expected the loaded value, got %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("Expected concurrent misses to share one load, got %d", calls)
+	}
+
+	entry, err := c.GetWithMetadata(ctx, "key")
+	if err != nil || entry.Tier != TierMemory || entry.ExpiresAt.IsZero() {
+		t.Errorf("Expected the loaded value cached in memory with its TTL, got %+v, %v", entry, err)
+	}
+	c.Get(ctx, "key")
+	if calls != 1 {
+		t.Errorf("Expected hits not to call the loader, got %d calls", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(ctx, "failing"); !errors.Is(err, loadErr) {
+			t.Errorf("Expected the loader error, got %v", err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("Expected loader errors not to be cached, got %d calls", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		c.Get(ctx, "missing")
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNegativeCached) {
+		t.Errorf("Expected a not-found load to be negatively cached, got %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("Expected one load of a missing key, got %d calls", calls)
+	}
+
+	if _, err := c.GetOrLoad(ctx, "other", func(ctx context.Context) ([]byte, error) {
+		return []byte("explicit"), nil
+	}); err != nil || calls != 4 {
+		t.Errorf("Expected GetOrLoad to use its own loader, got %v after %d calls", err, calls)
+	}
+}
+
+func TestCacheError(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	_, err = c.Get(ctx, "missing")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	var cacheErr *CacheError
+	if !errors.As(err, &cacheErr) {
+		t.Fatalf("Expected *CacheError, got %T", err)
+	}
+	if cacheErr.Op != "get" || cacheErr.Key != "missing" {
+		t.Errorf("Unexpected error context: %+v", cacheErr)
+	}
+
+	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+		_, err := store.Get(ctx, "missing")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound from %T, got %v", store, err)
+		}
+	}
+	_, err = c.diskStore.Get(ctx, "missing")
+	if errors.As(err, &cacheErr) && cacheErr.Tier != TierDisk {
+		t.Errorf("Expected disk tier in error, got %s", cacheErr.Tier)
+	}
+}
+
+func TestMaxEntrySize(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ConfiguredLimit", func(t *testing.T) {
+		c, err := NewCache(
+			WithMemoryCapacity(10),
+			WithDiskCapacity(100),
+			WithMaxEntrySize(50),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		defer c.Close()
+
+		// Larger than memory, smaller than disk.
+		if err := c.Set(ctx, "medium", make([]byte, 20)); err != nil {
+			t.Errorf("Expected medium value to be accepted, got %v", err)
+		}
+		if _, err := c.diskStore.Get(ctx, "medium"); err != nil {
+			t.Errorf("Expected medium value on disk, got error: %v", err)
+		}
+
+		err = c.Set(ctx, "large", make([]byte, 60))
+		if !errors.Is(err, ErrEntryTooLarge) {
+			t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+		}
+		if c.Has(ctx, "large") {
+			t.Error("Expected rejected value not to be stored in any tier")
+		}
+	})
+
+	t.Run("DefaultsToLargestTier", func(t *testing.T) {
+		c, err := NewCache(WithMemoryCapacity(10), WithDiskCapacity(100))
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		defer c.Close()
+
+		if err := c.Set(ctx, "fits", make([]byte, 100)); err != nil {
+			t.Errorf("Expected value equal to disk capacity to be accepted, got %v", err)
+		}
+		if err := c.Set(ctx, "huge", make([]byte, 101)); !errors.Is(err, ErrEntryTooLarge) {
+			t.Errorf("Expected ErrEntryTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+
+	newNamespaced := func(ns string) *MultiTierCache {
+		c, err := NewCache(
+			WithMemoryCapacity(100),
+			WithDiskCapacity(100),
+			WithRemoteStore(remote),
+			WithNamespace(ns),
+			WithWriteThrough(),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	a := newNamespaced("svc-a")
+	b := newNamespaced("svc-b")
+
+	ctx := context.Background()
+	a.Set(ctx, "shared", []byte("from-a"))
+	b.Set(ctx, "shared", []byte("from-b"))
+
+	if _, err := remote.Get(ctx, "svc-a:shared"); err != nil {
+		t.Errorf("Expected prefixed key in remote store, got error: %v", err)
+	}
+
+	// Drop local tiers so reads come from the shared remote.
+	a.memoryStore.Clear(ctx)
+	a.diskStore.Clear(ctx)
+	value, err := a.Get(ctx, "shared")
+	if err != nil || string(value) != "from-a" {
+		t.Errorf("Expected a to read its own value. Error: %v, Value: %s", err, string(value))
+	}
+
+	keys := a.Keys(ctx)
+	for _, key := range keys {
+		if key != "shared" {
+			t.Errorf("Expected only unprefixed keys from a, got %q", key)
+		}
+	}
+
+	if err := a.Clear(ctx); err != nil {
+		t.Fatalf("Failed to clear a: %v", err)
+	}
+	if _, err := remote.Get(ctx, "svc-a:shared"); err == nil {
+		t.Error("Expected a's remote key to be cleared")
+	}
+	b.memoryStore.Clear(ctx)
+	b.diskStore.Clear(ctx)
+	value, err = b.Get(ctx, "shared")
+	if err != nil || string(value) != "from-b" {
+		t.Errorf("Expected b's value to survive a.Clear. Error: %v, Value: %s", err, string(value))
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	c := newSimulatedCache(t, 100, 1000)
+	ctx := context.Background()
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		c.remoteStore.Set(ctx, &CacheEntry{Key: key, Value: []byte("value")})
+	}
+	c.diskStore.Set(ctx, &CacheEntry{Key: "key4", Value: []byte("value"), Size: 5})
+
+	warmed, err := c.WarmUp(ctx, []string{"key1", "key2", "key3", "key4", "missing"})
+	if err != nil {
+		t.Fatalf("Failed to warm up: %v", err)
+	}
+	if warmed != 4 {
+		t.Errorf("Expected 4 keys warmed, got %d", warmed)
+	}
+
+	c.ResetStats()
+	for _, key := range []string{"key1", "key2", "key3", "key4"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Errorf("Failed to get %s: %v", key, err)
+		}
+	}
+	if stats := c.GetTierStats(); stats.MemoryHits != 4 {
+		t.Errorf("Expected 4 memory hits after warm-up, got %+v", stats)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	type event struct {
+		key    string
+		reason EvictReason
+	}
+	var (
+		mu     sync.Mutex
+		events []event
+	)
+	var c *MultiTierCache
+	clock := NewFakeClock(time.Now())
+	c, err := NewCache(
+		WithMemoryCapacity(20),
+		WithDiskCapacity(0),
+		WithClock(clock),
+		WithOnEvict(func(key string, entry *CacheEntry, reason EvictReason) {
+			mu.Lock()
+			events = append(events, event{key, reason})
+			mu.Unlock()
+			// Re-entering the cache must not deadlock.
+			c.Has(context.Background(), key)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	reasonFor := func(key string) (EvictReason, bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ev := range events {
+			if ev.key == key {
+				return ev.reason, true
+			}
+		}
+		return 0, false
+	}
+
+	c.Set(ctx, "key1", []byte("value1"))
+	c.Set(ctx, "key2", []byte("value2"))
+	c.Set(ctx, "key3", []byte("value3")) // evicts key1
+	if reason, ok := reasonFor("key1"); !ok || reason != EvictReasonCapacity {
+		t.Errorf("Expected capacity eviction for key1, got %v (fired=%v)", reason, ok)
+	}
+
+	c.Delete(ctx, "key2")
+	if reason, ok := reasonFor("key2"); !ok || reason != EvictReasonManual {
+		t.Errorf("Expected manual eviction for key2, got %v (fired=%v)", reason, ok)
+	}
+
+	c.SetWithTTL(ctx, "short", []byte("v"), time.Millisecond)
+	clock.Advance(5 * time.Millisecond)
+	c.purgeExpired(ctx)
+	if reason, ok := reasonFor("short"); !ok || reason != EvictReasonExpired {
+		t.Errorf("Expected expired eviction for short, got %v (fired=%v)", reason, ok)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if n, err := c.Increment(ctx, "counter", 5); err != nil || n != 5 {
+		t.Fatalf("Expected missing key to be created at 5, got %d, %v", n, err)
+	}
+	if n, err := c.Decrement(ctx, "counter", 2); err != nil || n != 3 {
+		t.Fatalf("Expected 3 after decrement, got %d, %v", n, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := c.Increment(ctx, "counter", 1); err != nil {
+					t.Errorf("Increment failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, err := c.Get(ctx, "counter")
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if string(value) != "1003" {
+		t.Errorf("Expected 1003 after concurrent increments, got %s", value)
+	}
+
+	c.Set(ctx, "text", []byte("abc"))
+	if _, err := c.Increment(ctx, "text", 1); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Expected ErrNotInteger, got %v", err)
+	}
+
+	// A counter evicted to disk is incremented there.
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("10"), Size: 2})
+	if n, err := c.Increment(ctx, "disk", 1); err != nil || n != 11 {
+		t.Errorf("Expected disk counter to be 11, got %d, %v", n, err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "disk"); err == nil {
+		t.Error("Expected disk counter to stay on disk")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if swapped, err := c.CompareAndSwap(ctx, "missing", nil, []byte("v")); err != nil || swapped {
+		t.Errorf("Expected swap on missing key to fail, got %v, %v", swapped, err)
+	}
+
+	c.Set(ctx, "key", []byte("v0"))
+	if swapped, _ := c.CompareAndSwap(ctx, "key", []byte("wrong"), []byte("v1")); swapped {
+		t.Error("Expected swap with stale old value to fail")
+	}
+
+	// Two conflicting updates from the same value: exactly one wins.
+	var wins int32
+	var wg sync.WaitGroup
+	for _, value := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(value string) {
+			defer wg.Done()
+			swapped, err := c.CompareAndSwap(ctx, "key", []byte("v0"), []byte(value))
+			if err != nil {
+				t.Errorf("CompareAndSwap failed: %v", err)
+			}
+			if swapped {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(value)
+	}
+	wg.Wait()
+
+	if wins != 1 {
+		t.Errorf("Expected exactly one swap to win, got %d", wins)
+	}
+	value, _ := c.Get(ctx, "key")
+	if string(value) != "a" && string(value) != "b" {
+		t.Errorf("Expected value to be the winner's, got %s", value)
+	}
+	if usage := c.memoryStore.GetUsage(); usage != len("key")+1 {
+		t.Errorf("Expected memory usage %d after swap, got %d", len("key")+1, usage)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "existing", []byte("old"))
+	if value, set, err := c.GetOrSet(ctx, "existing", []byte("new")); err != nil || set || string(value) != "old" {
+		t.Errorf("GetOrSet on a cached key = %q, %v, %v", value, set, err)
+	}
+
+	// Many callers race for one key: all see the same value and exactly
+	// one of them set it.
+	var setters int32
+	values := make([]string, 50)
+	var wg sync.WaitGroup
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, set, err := c.GetOrSet(ctx, "key", []byte(fmt.Sprint(i)))
+			if err != nil {
+				t.Errorf("GetOrSet failed: %v", err)
+			}
+			if set {
+				atomic.AddInt32(&setters, 1)
+			}
+			values[i] = string(value)
+		}(i)
+	}
+	wg.Wait()
+
+	if setters != 1 {
+		t.Errorf("Expected exactly one setter, got %d", setters)
+	}
+	for i, value := range values {
+		if value != values[0] {
+			t.Errorf("Caller %d saw %q, caller 0 saw %q", i, value, values[0])
+		}
+	}
+	if value, _ := c.Get(ctx, "key"); string(value) != values[0] {
+		t.Errorf("Expected the stored value %q, got %q", values[0], value)
+	}
+}
+
+func TestGetOrSetSharedRemote(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	ctx := context.Background()
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+
+	caches := make([]*MultiTierCache, 4)
+	for i := range caches {
+		caches[i], err = NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithRemoteStore(remote), WithWriteThrough())
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		defer caches[i].Close()
+	}
+
+	// Each cache has an empty local tier, so only the remote SET NX keeps
+	// them from all setting the key.
+	var setters int32
+	values := make([]string, 40)
+	var wg sync.WaitGroup
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			value, set, err := caches[i%len(caches)].GetOrSet(ctx, "key", []byte(fmt.Sprint(i)))
+			if err != nil {
+				t.Errorf("GetOrSet failed: %v", err)
+			}
+			if set {
+				atomic.AddInt32(&setters, 1)
+			}
+			values[i] = string(value)
+		}(i)
+	}
+	wg.Wait()
+
+	if setters != 1 {
+		t.Errorf("Expected exactly one setter across caches, got %d", setters)
+	}
+	for i, value := range values {
+		if value != values[0] {
+			t.Errorf("Caller %d saw %q, caller 0 saw %q", i, value, values[0])
+		}
+	}
+}
+
+func TestPromotionRespectsHotEntries(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(14), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Set(ctx, "warm", make([]byte, 10))
+	for i := 0; i < 5; i++ {
+		c.Get(ctx, "warm")
+	}
+	c.diskStore.Set(ctx, &CacheEntry{Key: "cold", Value: make([]byte, 10), Size: 10})
+
+	if _, err := c.Get(ctx, "cold"); err != nil {
+		t.Fatalf("Failed to get cold key: %v", err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "warm"); err != nil {
+		t.Error("Expected a single access to a cold disk key not to evict a warm memory key")
+	}
+	if _, err := c.memoryStore.Get(ctx, "cold"); err == nil {
+		t.Error("Expected cold key to stay on disk")
+	}
+
+	// Once the disk key has been accessed more often it is promoted.
+	for i := 0; i < 10; i++ {
+		c.Get(ctx, "cold")
+	}
+	if _, err := c.memoryStore.Get(ctx, "cold"); err != nil {
+		t.Error("Expected repeatedly accessed disk key to be promoted")
+	}
+}
+
+func TestConcurrentPromotion(t *testing.T) {
+	c := newSimulatedCache(t, 40, 10000)
+	defer c.Close()
+	ctx := context.Background()
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%02d", i)
+		if err := c.Set(ctx, keys[i], []byte("value-"+keys[i])); err != nil {
+			t.Fatalf("Set failed: %v", err)
+		}
+	}
+
+	// Each hit in the disk tier promotes the entry, evicting another from
+	// memory while other readers hold the read lock.
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				key := keys[(i*7+j)%len(keys)]
+				value, err := c.Get(ctx, key)
+				if err != nil {
+					t.Errorf("Get %s failed: %v", key, err)
+					return
+				}
+				if string(value) != "value-"+key {
+					t.Errorf("Get %s returned %q", key, value)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestEvictionCascade(t *testing.T) {
+	c := newSimulatedCache(t, 11, 20)
+	ctx := context.Background()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := c.Set(ctx, key, make([]byte, 10)); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for key, store := range map[string]Store{
+		"d": c.memoryStore,
+		"c": c.diskStore,
+		"b": c.diskStore,
+		"a": c.remoteStore,
+	} {
+		if _, err := store.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to have cascaded to its tier, got error: %v", key, err)
+		}
+	}
+	if _, err := c.diskStore.Get(ctx, "a"); err == nil {
+		t.Error("Expected a to have been evicted from disk")
+	}
+	if usage := c.diskStore.GetUsage(); usage != 20 {
+		t.Errorf("Expected disk usage 20, got %d", usage)
+	}
+
+	// An entry too large for disk skips straight to remote when evicted
+	// from memory.
+	c2 := newSimulatedCache(t, 30, 20)
+	c2.Set(ctx, "big", make([]byte, 25))
+	c2.Set(ctx, "small", make([]byte, 10))
+	if _, err := c2.remoteStore.Get(ctx, "big"); err != nil {
+		t.Errorf("Expected big entry to cascade to remote, got error: %v", err)
+	}
+	if usage := c2.diskStore.GetUsage(); usage != 0 {
+		t.Errorf("Expected disk to be untouched, got usage %d", usage)
+	}
+}
+
+func TestWithEvictionCascade(t *testing.T) {
+	for _, bc := range []struct {
+		name     string
+		cascade  bool
+		wantDisk bool
+	}{
+		{"On", true, true},
+		{"Off", false, false},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			c, err := NewCache(
+				WithMemoryCapacity(11),
+				WithDiskCapacity(100),
+				WithEvictionCascade(bc.cascade),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			ctx := context.Background()
+			c.Set(ctx, "a", make([]byte, 10))
+			c.Set(ctx, "b", make([]byte, 10)) // evicts a from memory
+
+			if _, err := c.memoryStore.Get(ctx, "a"); err == nil {
+				t.Fatal("Expected a to have been evicted from memory")
+			}
+			if _, err := c.diskStore.Get(ctx, "a"); (err == nil) != bc.wantDisk {
+				t.Errorf("Expected a on disk = %v, got error: %v", bc.wantDisk, err)
+			}
+			if _, err := c.Get(ctx, "a"); (err == nil) != bc.wantDisk {
+				t.Errorf("Expected Get(a) to succeed = %v, got error: %v", bc.wantDisk, err)
+			}
+		})
+	}
+}
+
+func TestEvictionCascadeOffDropsOlderCopies(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(
+		WithMemoryCapacity(11),
+		WithDiskCapacity(100),
+		WithRemoteStore(remote),
+		WithEvictionCascade(false),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Set(ctx, "k", []byte("v1"))
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if _, err := c.diskStore.Get(ctx, "k"); err != nil {
+		t.Fatalf("Expected v1 to have reached disk: %v", err)
+	}
+
+	c.Set(ctx, "k", []byte("v2"))
+	c.Set(ctx, "other", make([]byte, 10)) // evicts k from memory
+	if _, err := c.memoryStore.Get(ctx, "k"); err == nil {
+		t.Fatal("Expected k to have been evicted from memory")
+	}
+	if value, err := c.Get(ctx, "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the evicted v2 not to be replaced by v1, got %q, %v", value, err)
+	}
+
+	// A value already written below is left there.
+	c.Set(ctx, "k", []byte("v3"))
+	c.Flush(ctx)
+	c.Set(ctx, "other", make([]byte, 10))
+	if _, err := c.memoryStore.Get(ctx, "k"); err == nil {
+		t.Fatal("Expected k to have been evicted from memory again")
+	}
+	if value, err := c.Get(ctx, "k"); err != nil || string(value) != "v3" {
+		t.Errorf("Expected the persisted v3 to survive eviction, got %q, %v", value, err)
+	}
+}
+
+func TestThrashProtection(t *testing.T) {
+	for _, bc := range []struct {
+		name      string
+		threshold int
+	}{
+		{"Off", 0},
+		{"On", 2},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			clock := NewFakeClock(time.Now())
+			opts := []Option{WithMemoryCapacity(11), WithDiskCapacity(100), WithClock(clock)}
+			// Protection is off unless enabled.
+			if bc.threshold > 0 {
+				opts = append(opts, WithThrashProtection(time.Second, bc.threshold, time.Minute))
+			}
+			c, err := NewCache(opts...)
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			// Memory holds one of a and b, and they are read in turn, so
+			// each read promotes one and evicts the other.
+			ctx := context.Background()
+			c.Set(ctx, "a", make([]byte, 10))
+			c.Set(ctx, "b", make([]byte, 10))
+			for i := 0; i < 50; i++ {
+				for _, key := range []string{"a", "b"} {
+					if _, err := c.Get(ctx, key); err != nil {
+						t.Fatalf("Get(%s) failed: %v", key, err)
+					}
+				}
+			}
+
+			stats := c.GetCacheStats()
+			if bc.threshold == 0 {
+				if stats.Promotions < 90 || stats.Thrashes != 0 {
+					t.Errorf("Expected unchecked churn without protection, got %+v", stats)
+				}
+				return
+			}
+			if stats.Promotions > 6 {
+				t.Errorf("Expected thrash protection to bound promotions, got %d", stats.Promotions)
+			}
+			if stats.Thrashes == 0 {
+				t.Error("Expected the churn to be counted as thrash")
+			}
+
+			// Once the cooldown passes, the entries may be promoted again.
+			clock.Advance(2 * time.Minute)
+			c.Get(ctx, "a")
+			if _, err := c.memoryStore.Get(ctx, "a"); err != nil {
+				t.Errorf("Expected a to be promoted after the cooldown, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWithZeroCopyReads(t *testing.T) {
+	for _, zeroCopy := range []bool{false, true} {
+		c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithZeroCopyReads(zeroCopy))
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		defer c.Close()
+
+		ctx := context.Background()
+		c.Set(ctx, "key", []byte("value"))
+		first, _ := c.Get(ctx, "key")
+		second, _ := c.Get(ctx, "key")
+		entry, _ := c.GetWithMetadata(ctx, "key")
+		if string(first) != "value" || string(entry.Value) != "value" {
+			t.Fatalf("Expected value, got %q and %q", first, entry.Value)
+		}
+		if shared := &first[0] == &second[0] && &first[0] == &entry.Value[0]; shared != zeroCopy {
+			t.Errorf("Expected reads to share the cached value = %v, got %v", zeroCopy, shared)
+		}
+
+		// Setting the key again leaves earlier reads intact.
+		c.Set(ctx, "key", []byte("other"))
+		if string(first) != "value" {
+			t.Errorf("Expected an earlier read to keep its value, got %q", first)
+		}
+	}
+}
+
+func BenchmarkGetZeroCopy(b *testing.B) {
+	for _, zeroCopy := range []bool{false, true} {
+		b.Run(fmt.Sprintf("ZeroCopy=%v", zeroCopy), func(b *testing.B) {
+			c, err := NewCache(WithMemoryCapacity(1<<20), WithDiskCapacity(1<<20), WithZeroCopyReads(zeroCopy))
+			if err != nil {
+				b.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+			ctx := context.Background()
+			c.Set(ctx, "key", make([]byte, 4096))
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Get(ctx, "key")
+			}
+		})
+	}
+}
+
+func TestSetStream(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(WithMemoryCapacity(1<<10), WithDiskCapacity(8<<20), WithDiskDir(dir))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	const size = 4 << 20
+	payload := func() io.Reader {
+		return io.LimitReader(rand.New(rand.NewSource(1)), size)
+	}
+
+	if err := c.SetStream(ctx, "blob", payload(), size); err != nil {
+		t.Fatalf("Failed to stream blob: %v", err)
+	}
+	if usage := c.memoryStore.GetUsage(); usage != 0 {
+		t.Errorf("Expected the blob to bypass memory, got memory usage %d", usage)
+	}
+	if usage := c.diskStore.GetUsage(); usage != size {
+		t.Errorf("Expected disk usage %d, got %d", size, usage)
+	}
+
+	rc, err := c.GetStream(ctx, "blob")
+	if err != nil {
+		t.Fatalf("Failed to open blob stream: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("Failed to read blob stream: %v", err)
+	}
+	want, _ := io.ReadAll(payload())
+	if !bytes.Equal(got, want) {
+		t.Error("Expected the streamed blob to read back unchanged")
+	}
+	if value, err := c.Get(ctx, "blob"); err != nil || !bytes.Equal(value, want) {
+		t.Errorf("Expected Get to return the streamed blob, got error: %v", err)
+	}
+
+	// The disk tier recovers streamed entries on restart.
+	reopened, err := NewDiskStoreWithDir(dir, 8<<20)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk store: %v", err)
+	}
+	if usage := reopened.GetUsage(); usage != size {
+		t.Errorf("Expected recovered disk usage %d, got %d", size, usage)
+	}
+
+	// A short reader fails without leaving the key behind.
+	if err := c.SetStream(ctx, "short", strings.NewReader("abc"), 10); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a short stream, got %v", err)
+	}
+	if c.Has(ctx, "short") {
+		t.Error("Expected a failed stream not to be stored")
+	}
+
+	// Small values in memory are served from there.
+	c.Set(ctx, "small", []byte("value"))
+	rc, err = c.GetStream(ctx, "small")
+	if err != nil {
+		t.Fatalf("Failed to open stream for small: %v", err)
+	}
+	if got, _ := io.ReadAll(rc); string(got) != "value" {
+		t.Errorf("Expected value, got %q", got)
+	}
+	rc.Close()
+
+	c.Delete(ctx, "blob")
+	if usage := c.diskStore.GetUsage(); usage != 0 {
+		t.Errorf("Expected disk usage 0 after delete, got %d", usage)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*", "*"+streamSuffix)); len(files) != 0 {
+		t.Errorf("Expected delete to remove the value file, found %v", files)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer src.Close()
+
+	src.Set(ctx, "key1", []byte("value1"))
+	src.SetWithTTL(ctx, "key2", []byte("value2"), time.Hour)
+	src.SetWithTTL(ctx, "expiring", []byte("gone"), 10*time.Millisecond)
+	src.Get(ctx, "key1")
+
+	var buf bytes.Buffer
+	if err := src.Snapshot(&buf); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	dst, err := NewCache(WithMemoryCapacity(10), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer dst.Close()
+	if err := dst.Restore(&buf); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	// Only one entry fits; the most recently used one wins.
+	entry, err := dst.memoryStore.Get(ctx, "key1")
+	if err != nil {
+		t.Fatalf("Expected key1 to be restored to memory, got error: %v", err)
+	}
+	if string(entry.Value) != "value1" || entry.Frequency != 2 {
+		t.Errorf("Expected restored metadata, got value=%s frequency=%d", entry.Value, entry.Frequency)
+	}
+	if _, err := dst.memoryStore.Get(ctx, "key2"); err == nil {
+		t.Error("Expected key2 not to fit in memory")
+	}
+	if dst.Has(ctx, "expiring") {
+		t.Error("Expected expired entry to be skipped")
+	}
+
+	entry, err = dst.diskStore.Get(ctx, "key2")
+	if err != nil {
+		t.Fatalf("Expected key2 to be evicted to disk, got error: %v", err)
+	}
+	if entry.ExpiresAt.IsZero() {
+		t.Error("Expected key2 to keep its TTL")
+	}
+}
+
+func TestSetCapacity(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(NewMemoryStore(1000)))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 9; i++ {
+		c.Set(ctx, fmt.Sprintf("key%d", i), make([]byte, 6))
+	}
+	if usage := c.memoryStore.GetUsage(); usage != 90 {
+		t.Fatalf("Expected 90 bytes in memory, got %d", usage)
+	}
+
+	if err := c.SetMemoryCapacity(40); err != nil {
+		t.Fatalf("SetMemoryCapacity failed: %v", err)
+	}
+	if c.memoryStore.GetCapacity() != 40 || c.memoryStore.GetUsage() > 40 {
+		t.Errorf("Expected memory within its new 40 byte cap, got %d of %d", c.memoryStore.GetUsage(), c.memoryStore.GetCapacity())
+	}
+	if _, err := peekEntry(ctx, c.memoryStore, "key0"); err == nil {
+		t.Error("Expected the least recently used key to be evicted from memory")
+	}
+	for i := 0; i < 9; i++ {
+		if _, err := c.Get(ctx, fmt.Sprintf("key%d", i)); err != nil {
+			t.Errorf("Expected key%d to survive in a lower tier, got %v", i, err)
+		}
+	}
+
+	if err := c.SetDiskCapacity(12); err != nil {
+		t.Fatalf("SetDiskCapacity failed: %v", err)
+	}
+	if usage := c.diskStore.GetUsage(); usage > 12 {
+		t.Errorf("Expected disk within its new 12 byte cap, got %d", usage)
+	}
+	if stats := c.GetCacheStats(); stats.DiskEvictions == 0 {
+		t.Error("Expected shrinking the disk tier to evict")
+	}
+
+	// Growing makes room for new entries without evicting.
+	if err := c.SetMemoryCapacity(200); err != nil {
+		t.Fatalf("SetMemoryCapacity failed: %v", err)
+	}
+	before := c.GetCacheStats().MemoryEvictions
+	c.Set(ctx, "big", make([]byte, 100))
+	if _, tier, _ := c.GetFromTier(ctx, "big"); tier != TierMemory || c.GetCacheStats().MemoryEvictions != before {
+		t.Errorf("Expected a grown memory tier to take a new entry without evicting, got %v", tier)
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		stop := make(chan struct{})
+		for w := 0; w < 4; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; ; i++ {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					key := fmt.Sprintf("w%d-%d", w, i%20)
+					c.Set(ctx, key, make([]byte, 5))
+					c.Get(ctx, key)
+				}
+			}(w)
+		}
+		for _, n := range []int{150, 60, 120, 30, 200} {
+			if err := c.SetMemoryCapacity(n); err != nil {
+				t.Errorf("SetMemoryCapacity(%d) failed: %v", n, err)
+			}
+		}
+		close(stop)
+		wg.Wait()
+		if usage, capacity := c.memoryStore.GetUsage(), c.memoryStore.GetCapacity(); usage > capacity {
+			t.Errorf("Expected usage %d within capacity %d", usage, capacity)
+		}
+	})
+
+	if err := NewMemoryCache(100, nil).SetDiskCapacity(10); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected resizing a missing disk tier to be unsupported, got %v", err)
+	}
+}
+
+func TestPlanSet(t *testing.T) {
+	var evicted []string
+	remote := NewMemoryStore(1000)
+	c, err := NewCache(WithMemoryCapacity(30), WithDiskCapacity(40), WithRemoteStore(remote),
+		WithOnEvict(func(key string, _ *CacheEntry, reason EvictReason) {
+			if reason == EvictReasonCapacity && !slices.Contains(evicted, key) {
+				evicted = append(evicted, key)
+			}
+		}))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	for _, key := range []string{"old1", "old2", "old3"} {
+		c.Set(ctx, key, []byte("value"))
+	}
+	c.Get(ctx, "old1")
+
+	items := map[string][]byte{
+		"a":    bytes.Repeat([]byte("a"), 10),
+		"b":    bytes.Repeat([]byte("b"), 20),
+		"c":    bytes.Repeat([]byte("c"), 50),
+		"huge": bytes.Repeat([]byte("h"), 2000),
+	}
+	before := c.memoryStore.GetAll(ctx)
+	plan, err := c.PlanSet(ctx, items)
+	if err != nil {
+		t.Fatalf("PlanSet failed: %v", err)
+	}
+	if after := c.memoryStore.GetAll(ctx); len(after) != len(before) || c.diskStore.GetUsage() != 0 || len(evicted) != 0 {
+		t.Fatalf("Expected PlanSet not to change the cache, memory went from %d to %d entries", len(before), len(after))
+	}
+	if plan.Tiers["huge"] != TierNone || plan.Tiers["c"] != TierRemote {
+		t.Errorf("Expected the oversized item rejected and the large one in remote, got %v", plan.Tiers)
+	}
+
+	tierOf := func(key string) Tier {
+		switch {
+		case hasEntry(ctx, c.memoryStore, key):
+			return TierMemory
+		case hasEntry(ctx, c.diskStore, key):
+			return TierDisk
+		case hasEntry(ctx, c.remoteStore, key):
+			return TierRemote
+		}
+		return TierNone
+	}
+	for _, key := range slices.Sorted(maps.Keys(items)) {
+		c.Set(ctx, key, items[key])
+	}
+	for key, want := range plan.Tiers {
+		if got := tierOf(key); got != want {
+			t.Errorf("Expected %s in %v as planned, got %v", key, want, got)
+		}
+	}
+	var planned []string
+	for _, eviction := range plan.Evicted {
+		planned = append(planned, eviction.Key)
+		if got := tierOf(eviction.Key); got != eviction.Tier {
+			t.Errorf("Expected evicted %s in %v as planned, got %v", eviction.Key, eviction.Tier, got)
+		}
+	}
+	if len(planned) == 0 || !reflect.DeepEqual(planned, evicted) {
+		t.Errorf("Expected planned evictions %v to match actual %v", planned, evicted)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	src, err := NewCache(WithMemoryCapacity(20), WithDiskCapacity(100), WithRemoteStore(remote), WithNamespace("src"))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer src.Close()
+
+	src.Set(ctx, "disk", []byte("value1"))
+	src.SetWithTTL(ctx, "memory", []byte("value2"), time.Hour)
+	src.SetWithTTL(ctx, "expired", []byte("gone"), time.Millisecond)
+	remote.Set(ctx, &CacheEntry{Key: "src:remote", Value: []byte("value3")})
+	remote.Set(ctx, &CacheEntry{Key: "src:memory", Value: []byte("stale")})
+	remote.Set(ctx, &CacheEntry{Key: "other:key", Value: []byte("value4")})
+	time.Sleep(5 * time.Millisecond)
+	if _, err := peekEntry(ctx, src.memoryStore, "src:disk"); err == nil {
+		t.Fatal("Expected disk to have been evicted from memory")
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(ctx, &buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	dst, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithNamespace("dst"))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer dst.Close()
+	if err := dst.Import(ctx, bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	want := map[string]string{"disk": "value1", "memory": "value2", "remote": "value3"}
+	if keys := dst.Keys(ctx); len(keys) != len(want) {
+		t.Errorf("Expected %d imported keys, got %v", len(want), keys)
+	}
+	for key, value := range want {
+		if got, err := dst.Get(ctx, key); err != nil || string(got) != value {
+			t.Errorf("Expected %s=%s after import, got %q, %v", key, value, got, err)
+		}
+	}
+	if entry, err := dst.memoryStore.Get(ctx, "dst:memory"); err != nil || entry.ExpiresAt.IsZero() {
+		t.Errorf("Expected memory to keep its TTL, got %v, %v", entry, err)
+	}
+
+	// Dumps from before objects were exported still load.
+	old := bytes.NewBuffer(nil)
+	enc := gob.NewEncoder(old)
+	enc.Encode(exportHeader{Magic: exportMagic, Version: 1})
+	enc.Encode(exportRecord{Key: "old", Value: []byte("value5")})
+	if err := dst.Import(ctx, old); err != nil {
+		t.Fatalf("Import of a version 1 dump failed: %v", err)
+	}
+	if got, err := dst.Get(ctx, "old"); err != nil || string(got) != "value5" {
+		t.Errorf("Expected old=value5 after import, got %q, %v", got, err)
+	}
+
+	bad := bytes.NewBuffer(nil)
+	gob.NewEncoder(bad).Encode(exportHeader{Magic: exportMagic, Version: ExportFormatVersion + 1})
+	if err := dst.Import(ctx, bad); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat for a newer dump, got %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c := newSimulatedCache(t, 1000, 1000)
+	ctx := context.Background()
+
+	keys := []string{"key1", "key2", "key3"}
+	for _, key := range keys {
+		c.Set(ctx, key, []byte("value-"+key))
+	}
+	if c.dirty.len() != len(keys) {
+		t.Errorf("Expected %d dirty entries, got %d", len(keys), c.dirty.len())
+	}
+
+	if err := c.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if c.dirty.len() != 0 {
+		t.Errorf("Expected no dirty entries after Flush, got %d", c.dirty.len())
+	}
+	if _, err := c.memoryStore.Get(ctx, "key1"); err != nil {
+		t.Error("Expected Flush to leave entries in memory")
+	}
+
+	c.memoryStore.Clear(ctx)
+	for _, key := range keys {
+		value, err := c.Get(ctx, key)
+		if err != nil {
+			t.Errorf("Expected %s to survive clearing memory, got error: %v", key, err)
+			continue
+		}
+		if string(value) != "value-"+key {
+			t.Errorf("Expected value-%s, got %s", key, value)
+		}
+		if _, err := c.remoteStore.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to have been flushed to remote, got error: %v", key, err)
+		}
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	ctx := context.Background()
+	newJitteredCache := func() *MultiTierCache {
+		c, err := NewCache(
+			WithMemoryCapacity(10000),
+			WithTTLJitter(10*time.Second),
+			WithRandSource(rand.NewSource(1)),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	expiries := func(c *MultiTierCache) []time.Duration {
+		var ttls []time.Duration
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key%d", i)
+			c.SetWithTTL(ctx, key, []byte("v"), time.Minute)
+			entry, err := c.memoryStore.Get(ctx, key)
+			if err != nil {
+				t.Fatalf("Failed to get %s: %v", key, err)
+			}
+			ttls = append(ttls, entry.ExpiresAt.Sub(entry.LastAccess))
+		}
+		return ttls
+	}
+
+	ttls := expiries(newJitteredCache())
+	distinct := make(map[time.Duration]bool)
+	minTTL, maxTTL := ttls[0], ttls[0]
+	for _, ttl := range ttls {
+		distinct[ttl] = true
+		if ttl < 50*time.Second || ttl > 70*time.Second {
+			t.Errorf("Expected TTL within 1m±10s, got %v", ttl)
+		}
+		minTTL = min(minTTL, ttl)
+		maxTTL = max(maxTTL, ttl)
+	}
+	if len(distinct) < 90 {
+		t.Errorf("Expected expiry times to be spread out, got %d distinct", len(distinct))
+	}
+	if maxTTL-minTTL < 10*time.Second {
+		t.Errorf("Expected expiries to span most of the jitter window, got %v", maxTTL-minTTL)
+	}
+
+	// The same seed gives the same jitter.
+	again := expiries(newJitteredCache())
+	for i := range ttls {
+		if ttls[i] != again[i] {
+			t.Fatalf("Expected identical jitter with the same seed at key%d: %v != %v", i, ttls[i], again[i])
+		}
+	}
+}
+
+func TestTTLBounds(t *testing.T) {
+	ctx := context.Background()
+	newBoundedCache := func(opts ...Option) *MultiTierCache {
+		c, err := NewCache(append([]Option{WithMemoryCapacity(1000),
+			WithMinTTL(time.Minute), WithMaxTTL(time.Hour)}, opts...)...)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	ttlOf := func(c *MultiTierCache, key string) time.Duration {
+		entry, err := c.memoryStore.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Failed to get %s: %v", key, err)
+		}
+		if entry.ExpiresAt.IsZero() {
+			return 0
+		}
+		return entry.ExpiresAt.Sub(entry.LastAccess)
+	}
+
+	c := newBoundedCache()
+	for _, tc := range []struct {
+		name     string
+		ttl, got time.Duration
+	}{
+		{"BelowMinIsRaised", time.Second, time.Minute},
+		{"InRangeIsKept", 10 * time.Minute, 10 * time.Minute},
+		{"AboveMaxIsClamped", 24 * time.Hour, time.Hour},
+		{"NoExpiryIsClamped", 0, time.Hour},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := c.SetWithTTL(ctx, tc.name, []byte("v"), tc.ttl); err != nil {
+				t.Fatalf("SetWithTTL failed: %v", err)
+			}
+			if got := ttlOf(c, tc.name); got != tc.got {
+				t.Errorf("Expected a TTL of %v for %v, got %v", tc.got, tc.ttl, got)
+			}
+		})
+	}
+
+	strict := newBoundedCache(WithRejectNoExpiry())
+	if err := strict.Set(ctx, "key", []byte("v")); !errors.Is(err, ErrTTLRequired) {
+		t.Errorf("Expected ErrTTLRequired for a write without a TTL, got %v", err)
+	}
+	if err := strict.SetWithTTL(ctx, "key", []byte("v"), time.Second); err != nil || ttlOf(strict, "key") != time.Minute {
+		t.Errorf("Expected a write with a TTL to be accepted and raised, got %v", err)
+	}
+}
+
+func TestGetStaleWhileRevalidate(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(1000), WithStaleWindow(time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	c.SetWithTTL(ctx, "key", []byte("stale"), 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := c.Get(ctx, "key"); err == nil {
+		t.Error("Expected Get not to serve a stale value")
+	}
+
+	release := make(chan struct{})
+	loaded := make(chan struct{})
+	loader := func(ctx context.Context) ([]byte, error) {
+		<-release
+		defer close(loaded)
+		return []byte("fresh"), nil
+	}
+
+	// The loader is blocked, so this only returns if it doesn't wait.
+	value, err := c.GetStaleWhileRevalidate(ctx, "key", time.Minute, loader)
+	if err != nil {
+		t.Fatalf("GetStaleWhileRevalidate failed: %v", err)
+	}
+	if string(value) != "stale" {
+		t.Errorf("Expected stale value to be served, got %s", value)
+	}
+
+	close(release)
+	<-loaded
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, err := c.Get(ctx, "key"); err == nil {
+			if string(value) != "fresh" {
+				t.Errorf("Expected refreshed value, got %s", value)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected background refresh to update the entry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Past the stale window the caller waits for the loader.
+	c2, err := NewCache(WithMemoryCapacity(1000), WithStaleWindow(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c2.Close()
+	c2.SetWithTTL(ctx, "key", []byte("old"), 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	value, err = c2.GetStaleWhileRevalidate(ctx, "key", time.Minute, func(ctx context.Context) ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expected blocking load past the stale window, got %s, %v", value, err)
+	}
+}
+
+func TestCacheStatsEvictionsAndPromotions(t *testing.T) {
+	c := newSimulatedCache(t, 11, 20)
+	ctx := context.Background()
+
+	// a is evicted from memory to disk by b, then from disk to remote once
+	// c and d have also gone through memory.
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.Set(ctx, key, make([]byte, 10))
+		time.Sleep(time.Millisecond)
+	}
+	stats := c.GetCacheStats()
+	if stats.MemoryEvictions != 3 || stats.DiskEvictions != 1 {
+		t.Errorf("Expected 3 memory and 1 disk evictions, got %d and %d", stats.MemoryEvictions, stats.DiskEvictions)
+	}
+
+	// Promoting c from disk evicts d from memory.
+	for i := 0; i < 3; i++ {
+		c.Get(ctx, "c")
+	}
+	stats = c.GetCacheStats()
+	if stats.Promotions != 1 {
+		t.Errorf("Expected 1 promotion, got %d", stats.Promotions)
+	}
+	if stats.DiskHits == 0 {
+		t.Error("Expected GetCacheStats to include tier hit counts")
+	}
+
+	c.ResetStats()
+	if stats := c.GetCacheStats(); stats != (CacheStats{}) {
+		t.Errorf("Expected zeroed stats after ResetStats, got %+v", stats)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(11), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	if ratio := c.HitRatio(); ratio != 0 {
+		t.Errorf("Expected hit ratio 0 before any lookup, got %v", ratio)
+	}
+
+	ctx := context.Background()
+	c.Set(ctx, "a", make([]byte, 10))
+	c.Set(ctx, "b", make([]byte, 10)) // evicts a to disk
+	c.Get(ctx, "b")
+	c.Get(ctx, "b")
+	c.Get(ctx, "missing")
+	c.Get(ctx, "missing")
+
+	if ratio := c.HitRatio(); ratio != 0.5 {
+		t.Errorf("Expected hit ratio 0.5, got %v", ratio)
+	}
+	want := Stats{
+		CacheStats: CacheStats{
+			TierStats:       TierStats{MemoryHits: 2, Misses: 2},
+			MemoryEvictions: 1,
+		},
+		Hits:      2,
+		HitRatio:  0.5,
+		Evictions: 1,
+		Usage:     21,
+		Capacity:  111,
+	}
+	if got := c.Stats(); got != want {
+		t.Errorf("Unexpected stats. Got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetWithMetadata(t *testing.T) {
+	c := newSimulatedCache(t, 100, 1000)
+	ctx := context.Background()
+
+	before := time.Now()
+	c.SetWithTTL(ctx, "key", []byte("value"), time.Hour)
+	c.Get(ctx, "key")
+	c.Get(ctx, "key")
+
+	entry, err := c.GetWithMetadata(ctx, "key")
+	if err != nil {
+		t.Fatalf("GetWithMetadata failed: %v", err)
+	}
+	if entry.Key != "key" || string(entry.Value) != "value" || entry.Size != len("key")+len("value") {
+		t.Errorf("Unexpected entry: %+v", entry)
+	}
+	if entry.Frequency != 4 {
+		t.Errorf("Expected frequency 4 after Set and three reads, got %d", entry.Frequency)
+	}
+	if entry.LastAccess.Before(before) || entry.ExpiresAt.IsZero() {
+		t.Errorf("Expected access and expiry metadata, got %+v", entry)
+	}
+	if entry.Tier != TierMemory {
+		t.Errorf("Expected entry to be found in memory, got %v", entry.Tier)
+	}
+
+	// The result is a copy.
+	entry.Value[0] = 'X'
+	entry.Frequency = 100
+	if value, _ := c.Get(ctx, "key"); string(value) != "value" {
+		t.Errorf("Expected modifying the copy not to affect the cache, got %s", value)
+	}
+
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("v"), Size: 1})
+	entry, err = c.GetWithMetadata(ctx, "remote")
+	if err != nil || entry.Tier != TierRemote {
+		t.Errorf("Expected remote entry from TierRemote, got %+v, %v", entry, err)
+	}
+	if stats := c.GetTierStats(); stats.RemoteHits != 1 {
+		t.Errorf("Expected GetWithMetadata to count hits, got %+v", stats)
+	}
+	if _, err := c.memoryStore.Get(ctx, "remote"); err != nil {
+		t.Error("Expected GetWithMetadata to promote like Get")
+	}
+
+	if _, err := c.GetWithMetadata(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestSetWithMetadata(t *testing.T) {
+	c := newSimulatedCache(t, 20, 20)
+	ctx := context.Background()
+	metadata := map[string]string{"content-type": "application/json", "owner": "billing"}
+
+	c.SetWithMetadata(ctx, "doc", []byte(`{"a":1}`), metadata)
+	// Push doc down to disk, then on to the remote tier.
+	c.Set(ctx, "k1", []byte("0123456789"))
+	c.Set(ctx, "k2", []byte("0123456789"))
+	c.Set(ctx, "k3", []byte("0123456789"))
+	if _, err := c.diskStore.Get(ctx, "doc"); err == nil {
+		t.Fatal("Expected doc to have been evicted from disk")
+	}
+	if entry, err := c.remoteStore.Get(ctx, "doc"); err != nil || !reflect.DeepEqual(entry.Metadata, metadata) {
+		t.Fatalf("Expected the remote tier to keep doc's metadata, got %+v, %v", entry, err)
+	}
+
+	for _, tier := range []Tier{TierRemote, TierMemory} {
+		entry, err := c.GetWithMetadata(ctx, "doc")
+		if err != nil {
+			t.Fatalf("GetWithMetadata failed: %v", err)
+		}
+		if entry.Tier != tier || string(entry.Value) != `{"a":1}` || !reflect.DeepEqual(entry.Metadata, metadata) {
+			t.Errorf("Expected doc with its metadata from %v, got %+v", tier, entry)
+		}
+		entry.Metadata["owner"] = "changed"
+	}
+
+	// A plain value that happens to start like an encoded one is kept as is.
+	odd := []byte(metadataMagic + "not metadata")
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "odd", Value: odd})
+	if entry, err := c.remoteStore.Get(ctx, "odd"); err != nil || !bytes.Equal(entry.Value, odd) || entry.Metadata != nil {
+		t.Errorf("Expected the raw value back, got %+v, %v", entry, err)
+	}
+}
+
+func TestGetFromTier(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Set(ctx, "key", []byte("value"))
+	if value, tier, err := c.GetFromTier(ctx, "key"); err != nil || string(value) != "value" || tier != TierMemory {
+		t.Errorf("Expected a fresh key from memory, got %q, %v, %v", value, tier, err)
+	}
+
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("value"), Frequency: 1})
+	if _, tier, err := c.GetFromTier(ctx, "disk"); err != nil || tier != TierDisk {
+		t.Errorf("Expected the first read of a disk key from disk, got %v, %v", tier, err)
+	}
+	if _, tier, _ := c.GetFromTier(ctx, "disk"); tier != TierMemory {
+		t.Errorf("Expected the promoted key from memory, got %v", tier)
+	}
+	if stats := c.GetTierStats(); stats.MemoryHits != 2 || stats.DiskHits != 1 {
+		t.Errorf("Expected GetFromTier to count hits, got %+v", stats)
+	}
+
+	if _, tier, err := c.GetFromTier(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) || tier != TierNone {
+		t.Errorf("Expected ErrKeyNotFound from TierNone, got %v, %v", tier, err)
+	}
+}
+
+func TestKeysMatching(t *testing.T) {
+	ctx := context.Background()
+	c := newSimulatedCache(t, 100, 100)
+	defer c.Close()
+
+	set := func(store Store, key string) {
+		store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1})
+	}
+	set(c.memoryStore, "user:1")
+	set(c.diskStore, "user:1")
+	set(c.diskStore, "user:2")
+	set(c.remoteStore, "user:2")
+	set(c.remoteStore, "user:3")
+	set(c.memoryStore, "session:1")
+	set(c.remoteStore, "session:2")
+
+	for _, tc := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"user:*", []string{"user:1", "user:2", "user:3"}},
+		{"*:1", []string{"session:1", "user:1"}},
+		{"user:[12]", []string{"user:1", "user:2"}},
+		{"session:?", []string{"session:1", "session:2"}},
+		{"order:*", []string{}},
+	} {
+		got := c.KeysMatching(ctx, tc.pattern)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("KeysMatching(%q) = %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+}
+
+func TestKeysMatchingNamespace(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(100), WithNamespace("svc[1]"))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "user:1", []byte("v"))
+	c.memoryStore.Set(ctx, &CacheEntry{Key: "other:user:2", Value: []byte("v"), Size: 1})
+
+	if got := c.KeysMatching(ctx, "user:*"); !reflect.DeepEqual(got, []string{"user:1"}) {
+		t.Errorf("KeysMatching = %v, want [user:1]", got)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"user:*", "user:1/2", true},
+		{"user:*", "users:1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+	} {
+		if got := matchGlob(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestKeysDeduplicatesPromotedKeys(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.diskStore.Set(ctx, &CacheEntry{Key: "key", Value: []byte("value"), Size: 5})
+	if _, err := c.Get(ctx, "key"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "key"); err != nil {
+		t.Fatal("Expected key to be promoted to memory")
+	}
+	if _, err := c.diskStore.Get(ctx, "key"); err != nil {
+		t.Fatal("Expected promotion to leave the disk copy")
+	}
+
+	if keys := c.Keys(ctx); !reflect.DeepEqual(keys, []string{"key"}) {
+		t.Errorf("Keys = %v, want [key]", keys)
+	}
+}
+
+func TestPromotionThreshold(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	ctx := context.Background()
+	c, err := NewCache(
+		WithMemoryCapacity(100),
+		WithDiskCapacity(100),
+		WithRemote(RemoteStoreConfig{Addr: "localhost:6379"}),
+		WithPromotionThreshold(2),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("value"), Size: 5})
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("value"), Size: 5})
+
+	for _, key := range []string{"disk", "remote"} {
+		if value, err := c.Get(ctx, key); err != nil || string(value) != "value" {
+			t.Fatalf("Get(%s) = %q, %v", key, value, err)
+		}
+		if _, err := c.memoryStore.Get(ctx, key); err == nil {
+			t.Errorf("Expected first access not to promote %s", key)
+		}
+
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Fatalf("Get(%s) failed: %v", key, err)
+		}
+		if _, err := c.memoryStore.Get(ctx, key); err != nil {
+			t.Errorf("Expected second access to promote %s", key)
+		}
+	}
+}
+
+func TestDeleteMulti(t *testing.T) {
+	ctx := context.Background()
+	c := newSimulatedCache(t, 100, 100)
+	defer c.Close()
+
+	set := func(store Store, key string) {
+		store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1})
+	}
+	set(c.memoryStore, "mem")
+	set(c.diskStore, "disk")
+	set(c.remoteStore, "remote")
+	set(c.memoryStore, "everywhere")
+	set(c.diskStore, "everywhere")
+	set(c.remoteStore, "everywhere")
+	set(c.memoryStore, "kept")
+
+	n, err := c.DeleteMulti(ctx, []string{"mem", "disk", "remote", "everywhere", "missing"})
+	if err != nil {
+		t.Fatalf("DeleteMulti failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("DeleteMulti deleted %d keys, want 4", n)
+	}
+	for _, key := range []string{"mem", "disk", "remote", "everywhere"} {
+		if c.Has(ctx, key) {
+			t.Errorf("Expected %s to be deleted from every tier", key)
+		}
+	}
+	if !c.Has(ctx, "kept") {
+		t.Error("Expected keys not listed to remain")
+	}
+
+	if n, err := c.DeleteMulti(ctx, nil); n != 0 || err != nil {
+		t.Errorf("DeleteMulti(nil) = %d, %v", n, err)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	c := newSimulatedCache(t, 100, 100)
+	defer c.Close()
+
+	set := func(store Store, key string) {
+		store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1})
+	}
+	set(c.memoryStore, "tenant:123:a")
+	set(c.diskStore, "tenant:123:a")
+	set(c.diskStore, "tenant:123:b")
+	set(c.remoteStore, "tenant:123:c")
+	set(c.memoryStore, "tenant:1234:a")
+	set(c.remoteStore, "tenant:456:a")
+
+	n, err := c.DeletePrefix(ctx, "tenant:123:")
+	if err != nil {
+		t.Fatalf("DeletePrefix failed: %v", err)
+	}
+	if n != 3 {
+		t.Errorf("DeletePrefix removed %d keys, want 3", n)
+	}
+	keys := c.Keys(ctx)
+	sort.Strings(keys)
+	if want := []string{"tenant:1234:a", "tenant:456:a"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys after DeletePrefix = %v, want %v", keys, want)
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	ctx := context.Background()
+	c := newSimulatedCache(t, 100, 100)
+	defer c.Close()
+
+	c.SetWithTags(ctx, "user:1", []byte("a"), "users", "team:x")
+	c.SetWithTags(ctx, "user:2", []byte("b"), "users")
+	c.SetWithTags(ctx, "team:x", []byte("c"), "team:x")
+	c.Set(ctx, "untagged", []byte("d"))
+
+	n, err := c.InvalidateTag(ctx, "users")
+	if err != nil {
+		t.Fatalf("InvalidateTag failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("InvalidateTag removed %d keys, want 2", n)
+	}
+	for _, key := range []string{"user:1", "user:2"} {
+		if c.Has(ctx, key) {
+			t.Errorf("Expected %s to be invalidated", key)
+		}
+	}
+	if !c.Has(ctx, "team:x") || !c.Has(ctx, "untagged") {
+		t.Error("Expected keys without the tag to remain")
+	}
+
+	// user:1 was removed from its other tags too.
+	if keys := c.tags.keysFor("team:x"); !reflect.DeepEqual(keys, []string{"team:x"}) {
+		t.Errorf("team:x tag holds %v, want [team:x]", keys)
+	}
+
+	c.Delete(ctx, "team:x")
+	if n, _ := c.InvalidateTag(ctx, "team:x"); n != 0 {
+		t.Errorf("Expected Delete to drop the key's tags, InvalidateTag removed %d", n)
+	}
+}
+
+// ghostPolicy always picks a key that isn't stored.
+type ghostPolicy struct{}
+
+func (p *ghostPolicy) Choose(entries []*CacheEntry) string {
+	return "ghost"
+}
+
+// undeletableStore ignores deletes, as a store with broken accounting might.
+type undeletableStore struct {
+	*MemoryStore
+}
+
+func (s *undeletableStore) Delete(ctx context.Context, key string) error {
+	return nil
+}
+
+func TestEvictionTerminatesWithMisbehavingPolicy(t *testing.T) {
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		c, err := NewCache(WithMemoryCapacity(10), WithDiskCapacity(0), WithPolicy(&ghostPolicy{}))
+		if err != nil {
+			t.Errorf("Failed to create cache: %v", err)
+			return
+		}
+		defer c.Close()
+		c.Set(ctx, "a", []byte("aaaa"))
+		c.Set(ctx, "b", []byte("bbbb"))
+		c.Set(ctx, "c", []byte("cccc"))
+		if _, err := c.memoryStore.Get(ctx, "c"); err == nil {
+			t.Error("Expected c not to fit when nothing can be evicted")
+		}
+
+		c.policy = &LRUPolicy{}
+		store := &undeletableStore{NewMemoryStore(10)}
+		store.Set(ctx, &CacheEntry{Key: "a", Value: []byte("aaaa")})
+		if c.evict(ctx, store, "a") {
+			t.Error("Expected evict to report no progress when deletion frees nothing")
+		}
+		if err := c.setEvicting(ctx, store, &CacheEntry{Key: "b", Value: make([]byte, 8)}); !errors.Is(err, ErrInsufficientCapacity) {
+			t.Errorf("Expected ErrInsufficientCapacity, got %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Eviction did not terminate")
+	}
+}
+
+func TestRemoteStoreGetMulti(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	ctx := context.Background()
+	remote.Set(ctx, &CacheEntry{Key: "a", Value: []byte("1")})
+	remote.Set(ctx, &CacheEntry{Key: "b", Value: []byte("2")})
+
+	for _, tc := range []struct {
+		name string
+		keys []string
+		want map[string]string
+	}{
+		{"AllHit", []string{"a", "b"}, map[string]string{"a": "1", "b": "2"}},
+		{"AllMiss", []string{"x", "y"}, map[string]string{}},
+		{"Mixed", []string{"a", "x", "b"}, map[string]string{"a": "1", "b": "2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries, err := remote.GetMulti(ctx, tc.keys)
+			if err != nil {
+				t.Fatalf("GetMulti failed: %v", err)
+			}
+			got := make(map[string]string, len(entries))
+			for key, entry := range entries {
+				if entry.Key != key {
+					t.Errorf("Entry for %s has key %s", key, entry.Key)
+				}
+				got[key] = string(entry.Value)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("GetMulti(%v) = %v, want %v", tc.keys, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	ctx := context.Background()
+	c := newSimulatedCache(t, 100, 100)
+	defer c.Close()
+
+	c.Set(ctx, "mem", []byte("m"))
+	c.diskStore.Set(ctx, &CacheEntry{Key: "disk", Value: []byte("d"), Size: 1})
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("r"), Size: 1})
+
+	values, err := c.GetMulti(ctx, []string{"mem", "disk", "remote", "missing"})
+	if err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	want := map[string][]byte{"mem": []byte("m"), "disk": []byte("d"), "remote": []byte("r")}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("GetMulti = %v, want %v", values, want)
+	}
+
+	stats := c.GetTierStats()
+	if stats != (TierStats{MemoryHits: 1, DiskHits: 1, RemoteHits: 1, Misses: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if _, err := c.memoryStore.Get(ctx, "remote"); err != nil {
+		t.Error("Expected remote hit to be promoted to memory")
+	}
+}
+
+func TestRemoteStoreLatency(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	ctx := context.Background()
+
+	metrics, err := remote.GetRemoteMetrics(ctx)
+	if err != nil {
+		t.Fatalf("GetRemoteMetrics failed: %v", err)
+	}
+	if metrics.GetLatency != 0 || metrics.SetLatency != 0 || metrics.DeleteLatency != 0 {
+		t.Errorf("Expected no latency before any calls, got %+v", metrics)
+	}
+
+	remote.simulateDelay = 5 * time.Millisecond
+	remote.Set(ctx, &CacheEntry{Key: "key", Value: []byte("value")})
+	remote.Get(ctx, "key")
+	remote.Delete(ctx, "key")
+
+	metrics, err = remote.GetRemoteMetrics(ctx)
+	if err != nil {
+		t.Fatalf("GetRemoteMetrics failed: %v", err)
+	}
+	for name, latency := range map[string]time.Duration{
+		"Get":    metrics.GetLatency,
+		"Set":    metrics.SetLatency,
+		"Delete": metrics.DeleteLatency,
+	} {
+		if latency < remote.simulateDelay {
+			t.Errorf("Expected %s latency of at least %v, got %v", name, remote.simulateDelay, latency)
+		}
+	}
+}
+
+func TestRemoteCircuitBreaker(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStoreWithConfig(RemoteStoreConfig{
+		Addr:             "localhost:6379",
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(WithRemoteStore(remote), WithWriteThrough())
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	remote.simulateErr = errors.New("connection refused")
+	for _, key := range []string{"a", "b"} {
+		if err := c.Set(ctx, key, []byte("v")); err == nil {
+			t.Errorf("Expected Set(%s) to fail while Redis is down", key)
+		}
+	}
+	if !remote.CircuitOpen() {
+		t.Fatal("Expected breaker to open after consecutive failures")
+	}
+
+	// While open, the remote tier is skipped and local tiers keep serving.
+	if err := c.Set(ctx, "c", []byte("v")); err != nil {
+		t.Errorf("Expected Set to succeed with the breaker open, got %v", err)
+	}
+	if value, err := c.Get(ctx, "c"); err != nil || string(value) != "v" {
+		t.Errorf("Expected Get to be served locally, got %q, %v", value, err)
+	}
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected remote Get to be a miss, got %v", err)
+	}
+	if err := remote.Delete(ctx, "c"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen from Delete, got %v", err)
+	}
+	if _, err := remote.DeleteMulti(ctx, []string{"c"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen from DeleteMulti, got %v", err)
+	}
+	if _, err := remote.Increment(ctx, "n", 1); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen from Increment, got %v", err)
+	}
+	if _, err := remote.CompareAndSwap(ctx, "c", []byte("v"), []byte("w")); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen from CompareAndSwap, got %v", err)
+	}
+	if metrics, _ := remote.GetRemoteMetrics(ctx); !metrics.CircuitOpen {
+		t.Error("Expected metrics to report the open breaker")
+	}
+
+	// Once Redis is back, the first call after the cooldown closes it.
+	remote.simulateErr = nil
+	time.Sleep(60 * time.Millisecond)
+	if err := c.Set(ctx, "d", []byte("v")); err != nil {
+		t.Fatalf("Set after recovery failed: %v", err)
+	}
+	if remote.CircuitOpen() {
+		t.Error("Expected breaker to close after a successful call")
+	}
+	if _, err := remote.Get(ctx, "d"); err != nil {
+		t.Errorf("Expected d to reach Redis after recovery, got %v", err)
+	}
+
+	// Failed increments, compare-and-swaps and batch deletes count
+	// towards opening it too, but a value that isn't an integer doesn't.
+	remote.Set(ctx, &CacheEntry{Key: "text", Value: []byte("abc")})
+	for i := 0; i < 3; i++ {
+		if _, err := remote.Increment(ctx, "text", 1); !errors.Is(err, ErrNotInteger) {
+			t.Fatalf("Expected ErrNotInteger, got %v", err)
+		}
+	}
+	if remote.CircuitOpen() {
+		t.Error("Expected non-integer values not to open the breaker")
+	}
+	remote.simulateErr = errors.New("connection refused")
+	remote.Increment(ctx, "n", 1)
+	remote.CompareAndSwap(ctx, "d", []byte("v"), []byte("w"))
+	if !remote.CircuitOpen() {
+		t.Error("Expected failed Increment and CompareAndSwap to open the breaker")
+	}
+	// After the cooldown, one more failure reopens it.
+	time.Sleep(60 * time.Millisecond)
+	remote.DeleteMulti(ctx, []string{"d"})
+	if !remote.CircuitOpen() {
+		t.Error("Expected a failed DeleteMulti to reopen the breaker")
+	}
+}
+
+func TestRemoteRateLimit(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	ctx := context.Background()
+
+	// Commands over the limit are shed by default.
+	shed, err := NewRemoteStoreWithConfig(RemoteStoreConfig{
+		RateLimit:     10,
+		RateBurst:     2,
+		RateLimitGets: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	for i, want := range []error{nil, nil, ErrRateLimited} {
+		err := shed.Set(ctx, &CacheEntry{Key: fmt.Sprintf("k%d", i), Value: []byte("v")})
+		if !errors.Is(err, want) {
+			t.Errorf("Set %d: expected %v, got %v", i, want, err)
+		}
+	}
+	if _, err := shed.Get(ctx, "k0"); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected Get to be shed too, got %v", err)
+	}
+	if shed.CircuitOpen() {
+		t.Error("Expected shed commands not to count as failures")
+	}
+	time.Sleep(110 * time.Millisecond)
+	if _, err := shed.Get(ctx, "k0"); err != nil {
+		t.Errorf("Expected Get to go through once a token is back, got %v", err)
+	}
+
+	// Under RateLimitWait commands over the limit are delayed instead.
+	wait, err := NewRemoteStoreWithConfig(RemoteStoreConfig{
+		RateLimit:       50,
+		RateBurst:       1,
+		RateLimitPolicy: RateLimitWait,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := wait.Set(ctx, &CacheEntry{Key: fmt.Sprintf("k%d", i), Value: []byte("v")}); err != nil {
+			t.Fatalf("Set %d failed: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 55*time.Millisecond {
+		t.Errorf("Expected 3 Sets over the burst to take at least 60ms at 50/s, took %v", elapsed)
+	}
+	if _, err := wait.Get(ctx, "k0"); err != nil {
+		t.Errorf("Expected Gets not to be limited without RateLimitGets, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := wait.Set(cancelled, &CacheEntry{Key: "late", Value: []byte("v")}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a waiting Set to give up with its context, got %v", err)
+	}
+}
+
+// commandRecorder is a go-redis hook that records each command's arguments
+// instead of sending it, so a RemoteStore can be tested without Redis.
+type commandRecorder struct {
+	mu   sync.Mutex
+	args [][]any
+}
+
+func (r *commandRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (r *commandRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.args = append(r.args, cmd.Args())
+		return nil
+	}
+}
+
+func (r *commandRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, cmd := range cmds {
+			r.args = append(r.args, cmd.Args())
+		}
+		return nil
+	}
+}
+
+func TestRemoteKeyPrefix(t *testing.T) {
+	ctx := context.Background()
+	for _, prefix := range []string{"", "app:"} {
+		client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+		defer client.Close()
+		recorder := &commandRecorder{}
+		client.AddHook(recorder)
+		store := newRemoteStore(client, RemoteStoreConfig{KeyPrefix: prefix}, nopLogger{})
+
+		store.Set(ctx, &CacheEntry{Key: "key", Value: []byte("value")})
+		store.Get(ctx, "key")
+		store.Delete(ctx, "key")
+
+		want := []string{"set", "get", "pttl", "del"}
+		if len(recorder.args) != len(want) {
+			t.Fatalf("Expected commands %v with prefix %q, got %v", want, prefix, recorder.args)
+		}
+		for i, args := range recorder.args {
+			if args[0] != want[i] || args[1] != prefix+"key" {
+				t.Errorf("Expected %s %q with prefix %q, got %v", want[i], prefix+"key", prefix, args)
+			}
+		}
+	}
+}
+
+func TestRemoteTTLSurvivesPromotion(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	clock := NewFakeClock(time.Now())
+	remote, err := NewRemoteStoreWithConfig(RemoteStoreConfig{Clock: clock})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(remote), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	expiresAt := clock.Now().Add(time.Minute)
+	remote.Set(ctx, &CacheEntry{Key: "a", Value: []byte("v"), ExpiresAt: expiresAt})
+	remote.Set(ctx, &CacheEntry{Key: "b", Value: []byte("v"), ExpiresAt: expiresAt})
+	if _, err := c.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := c.GetMulti(ctx, []string{"b"}); err != nil {
+		t.Fatalf("GetMulti failed: %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		entry, err := c.memoryStore.Get(ctx, key)
+		if err != nil || !entry.ExpiresAt.Equal(expiresAt) {
+			t.Errorf("Expected %s to be promoted expiring at %v, got %+v, %v", key, expiresAt, entry, err)
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, err := remote.Get(ctx, "a"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected the remote copy to have expired, got %v", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if value, err := c.Get(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected the promoted %s to expire with its TTL, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestWriteThroughIncrementKeepsTTL(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	clock := NewFakeClock(time.Now())
+	remote, err := NewRemoteStoreWithConfig(RemoteStoreConfig{Clock: clock})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(remote),
+		WithWriteThrough(), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	expiresAt := clock.Now().Add(time.Minute)
+	for _, key := range []string{"local", "remote"} {
+		if err := c.SetWithTTL(ctx, key, []byte("1"), time.Minute); err != nil {
+			t.Fatalf("SetWithTTL failed: %v", err)
+		}
+	}
+	c.memoryStore.Delete(ctx, "remote")
+	c.diskStore.Delete(ctx, "remote")
+	for _, key := range []string{"local", "remote"} {
+		if n, err := c.Increment(ctx, key, 1); err != nil || n != 2 {
+			t.Fatalf("Increment(%s) = %d, %v", key, n, err)
+		}
+		for _, store := range []Store{c.memoryStore, c.diskStore} {
+			entry, err := store.Get(ctx, key)
+			if err != nil || !entry.ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Expected the refreshed %s to expire at %v, got %+v, %v", key, expiresAt, entry, err)
+			}
+		}
+	}
+
+	clock.Advance(2 * time.Minute)
+	for _, key := range []string{"local", "remote"} {
+		if value, err := c.Get(ctx, key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected the incremented %s to expire with its TTL, got %q, %v", key, value, err)
+		}
+	}
+}
+
+func TestNewMemoryCache(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	c := NewMemoryCache(20, nil)
+	defer c.Close()
+
+	if entries, _ := os.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("Expected no temp directory to be created, found %v", entries)
+	}
+	if _, ok := c.DiskStore().(*NullStore); !ok {
+		t.Errorf("Expected a NullStore disk tier, got %T", c.DiskStore())
+	}
+
+	ctx := context.Background()
+	c.Set(ctx, "a", []byte("aaaa"))
+	c.Set(ctx, "b", []byte("bbbb"))
+	if value, err := c.Get(ctx, "a"); err != nil || string(value) != "aaaa" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	c.Set(ctx, "c", make([]byte, 10))
+
+	// b was least recently used and had nowhere to go.
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected b to be evicted, got %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, err := c.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to remain, got %v", key, err)
+		}
+	}
+}
+
+func TestSetWithVersion(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithWriteThrough())
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetWithVersion(ctx, "key", []byte("v1"), 0); err != nil {
+		t.Fatalf("Creating with version 0 failed: %v", err)
+	}
+	value, version, err := c.GetWithVersion(ctx, "key")
+	if err != nil || string(value) != "v1" || version != 1 {
+		t.Fatalf("GetWithVersion = %q, %d, %v", value, version, err)
+	}
+
+	// A concurrent writer updates the key after our read.
+	c.Set(ctx, "key", []byte("other"))
+
+	err = c.SetWithVersion(ctx, "key", []byte("v2"), version)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Fatalf("Expected ErrVersionMismatch for a stale version, got %v", err)
+	}
+	if value, _ := c.Get(ctx, "key"); string(value) != "other" {
+		t.Errorf("Expected the rejected write to leave the value alone, got %q", value)
+	}
+
+	_, version, _ = c.GetWithVersion(ctx, "key")
+	if version != 2 {
+		t.Errorf("Expected version 2 after two Sets, got %d", version)
+	}
+	if err := c.SetWithVersion(ctx, "key", []byte("v3"), version); err != nil {
+		t.Errorf("SetWithVersion with the current version failed: %v", err)
+	}
+
+	// The version is kept on disk too.
+	c.memoryStore.Delete(ctx, "key")
+	if _, version, _ := c.GetWithVersion(ctx, "key"); version != 3 {
+		t.Errorf("Expected version 3 from disk, got %d", version)
+	}
+}
+
+func TestRemoteCompareAndSwapWithMetadata(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStoreWithConfig(RemoteStoreConfig{})
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(remote), WithWriteThrough())
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	metadata := map[string]string{"type": "text/plain"}
+	if err := c.SetWithMetadata(ctx, "key", []byte("v1"), metadata); err != nil {
+		t.Fatalf("SetWithMetadata failed: %v", err)
+	}
+	if swapped, err := c.CompareAndSwap(ctx, "key", []byte("v1"), []byte("v2")); err != nil || !swapped {
+		t.Fatalf("Expected the swap to compare the value without its metadata, got %v, %v", swapped, err)
+	}
+	if swapped, _ := remote.CompareAndSwap(ctx, "key", []byte("v1"), []byte("v3")); swapped {
+		t.Error("Expected a swap from the old value to fail")
+	}
+
+	entry, err := remote.Get(ctx, "key")
+	if err != nil || string(entry.Value) != "v2" || !reflect.DeepEqual(entry.Metadata, metadata) {
+		t.Errorf("Expected the remote copy to hold v2 with its metadata, got %+v, %v", entry, err)
+	}
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		entry, err := store.Get(ctx, "key")
+		if err != nil || string(entry.Value) != "v2" || !reflect.DeepEqual(entry.Metadata, metadata) {
+			t.Errorf("Expected the local copies to hold v2 with its metadata, got %+v, %v", entry, err)
+		}
+	}
+}
+
+func TestSwapAndIncrementBumpVersion(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		opts     []Option
+		diskOnly bool
+	}{
+		{name: "memory"},
+		{name: "sharded", opts: []Option{WithMemoryShards(4)}},
+		{name: "disk", diskOnly: true},
+		{name: "write-through", opts: []Option{WithWriteThrough()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SIMULATE_REMOTE_STORE", "true")
+			c, err := NewCache(append([]Option{WithMemoryCapacity(100), WithDiskCapacity(100)}, tt.opts...)...)
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			update := map[string]func(key string) error{
+				"swapped": func(key string) error {
+					if swapped, err := c.CompareAndSwap(ctx, key, []byte("1"), []byte("2")); !swapped {
+						return fmt.Errorf("CompareAndSwap = false, %v", err)
+					}
+					return nil
+				},
+				"incremented": func(key string) error {
+					_, err := c.Increment(ctx, key, 1)
+					return err
+				},
+			}
+			for key, fn := range update {
+				c.Set(ctx, key, []byte("1"))
+				_, version, err := c.GetWithVersion(ctx, key)
+				if err != nil {
+					t.Fatalf("GetWithVersion(%s) failed: %v", key, err)
+				}
+				if tt.diskOnly {
+					entry, _ := c.memoryStore.Get(ctx, key)
+					c.diskStore.Set(ctx, entry)
+					c.memoryStore.Delete(ctx, key)
+				}
+				if err := fn(key); err != nil {
+					t.Fatalf("Updating %s failed: %v", key, err)
+				}
+				if err := c.SetWithVersion(ctx, key, []byte("3"), version); !errors.Is(err, ErrVersionMismatch) {
+					t.Errorf("Expected ErrVersionMismatch after the key was %s, got %v", key, err)
+				}
+			}
+
+			// Creating a counter makes it exist for SetWithVersion too.
+			c.Increment(ctx, "counter", 1)
+			if err := c.SetWithVersion(ctx, "counter", []byte("3"), 0); !errors.Is(err, ErrVersionMismatch) {
+				t.Errorf("Expected ErrVersionMismatch for a new counter, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryEntryFraction(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(
+		WithMemoryCapacity(100),
+		WithDiskCapacity(1000),
+		WithMemoryEntryFraction(0.25),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "small", []byte("value"))
+	c.Set(ctx, "big", make([]byte, 30))
+
+	if _, err := c.memoryStore.Get(ctx, "big"); err == nil {
+		t.Error("Expected the oversized value to bypass memory")
+	}
+	if _, err := c.diskStore.Get(ctx, "big"); err != nil {
+		t.Errorf("Expected the oversized value on disk, got %v", err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "small"); err != nil {
+		t.Error("Expected the small value to stay in memory")
+	}
+	if value, err := c.Get(ctx, "big"); err != nil || len(value) != 30 {
+		t.Errorf("Get(big) = %d bytes, %v", len(value), err)
+	}
+	if _, err := c.memoryStore.Get(ctx, "big"); err == nil {
+		t.Error("Expected a disk hit not to promote the oversized value")
+	}
+
+	// Growing a key past the limit moves it out of memory.
+	c.Set(ctx, "small", make([]byte, 30))
+	if _, err := c.memoryStore.Get(ctx, "small"); err == nil {
+		t.Error("Expected the old memory copy to be dropped")
+	}
+	if value, _ := c.Get(ctx, "small"); len(value) != 30 {
+		t.Errorf("Expected the new value, got %q", value)
+	}
+}
+
+// countingStore counts the Gets that reach a store.
+type countingStore struct {
+	Store
+	gets atomic.Int64
+}
+
+func (s *countingStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	s.gets.Add(1)
+	return s.Store.Get(ctx, key)
+}
+
+func TestRemoteBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	remote := &countingStore{Store: NewMemoryStore(1000)}
+	remote.Set(ctx, &CacheEntry{Key: "seeded", Value: []byte("value")})
+	c, err := NewCache(
+		WithMemoryCapacity(10),
+		WithDiskCapacity(0),
+		WithRemoteStore(remote),
+		WithRemoteBloomFilter(1000, 0.001),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	if _, err := c.Get(ctx, "never"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound, got %v", err)
+	}
+	c.GetMulti(ctx, []string{"never1", "never2"})
+	if got := remote.gets.Load(); got != 0 {
+		t.Errorf("Expected keys never set not to reach the remote tier, got %d gets", got)
+	}
+	if _, misses := c.GetStats(); misses != 3 {
+		t.Errorf("Expected 3 misses, got %d", misses)
+	}
+
+	if _, err := c.Get(ctx, "seeded"); err != nil {
+		t.Errorf("Expected a key already in the remote tier to be found, got error: %v", err)
+	}
+	c.Set(ctx, "big", make([]byte, 20))
+	if _, err := c.Get(ctx, "big"); err != nil {
+		t.Errorf("Expected a key written to the remote tier to be found, got error: %v", err)
+	}
+	if got := remote.gets.Load(); got != 2 {
+		t.Errorf("Expected 2 remote gets, got %d", got)
+	}
+
+	// Deleted keys can't be removed from the filter and fall through.
+	c.Delete(ctx, "big")
+	if _, err := c.Get(ctx, "big"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound after delete, got %v", err)
+	}
+}
+
+func TestBloomFilterFalsePositiveRate(t *testing.T) {
+	f := newBloomFilter(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.add(fmt.Sprintf("key%d", i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.mayContain(fmt.Sprintf("key%d", i)) {
+			t.Fatalf("Expected added key%d to be reported present", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.mayContain(fmt.Sprintf("absent%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.03 {
+		t.Errorf("Expected a false positive rate near 0.01, got %v", rate)
+	}
+}
+
+func TestSetMissing(t *testing.T) {
+	ctx := context.Background()
+	remote := &countingStore{Store: NewMemoryStore(1000)}
+	c, err := NewCache(WithRemoteStore(remote))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetMissing(ctx, "absent", 50*time.Millisecond); err != nil {
+		t.Fatalf("SetMissing failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(ctx, "absent"); !errors.Is(err, ErrNegativeCached) {
+			t.Errorf("Expected ErrNegativeCached, got %v", err)
+		}
+	}
+	if got := remote.gets.Load(); got != 0 {
+		t.Errorf("Expected negatively cached gets not to reach the remote tier, got %d", got)
+	}
+	loads := 0
+	_, err = c.GetOrLoad(ctx, "absent", func(ctx context.Context) ([]byte, error) {
+		loads++
+		return []byte("value"), nil
+	})
+	if !errors.Is(err, ErrNegativeCached) || loads != 0 {
+		t.Errorf("Expected GetOrLoad to fail fast without loading, got %v after %d loads", err, loads)
+	}
+	if c.Has(ctx, "absent") {
+		t.Error("Expected Has to report a negatively cached key as absent")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.Get(ctx, "absent"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound once the tombstone expires, got %v", err)
+	}
+	if got := remote.gets.Load(); got != 1 {
+		t.Errorf("Expected the remote tier to be probed after expiry, got %d gets", got)
+	}
+
+	c.SetMissing(ctx, "key", time.Hour)
+	if err := c.Set(ctx, "key", []byte("value")); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if value, err := c.Get(ctx, "key"); err != nil || string(value) != "value" {
+		t.Errorf("Expected Set to overwrite the tombstone, got %q, %v", value, err)
+	}
+}
+
+func TestTotalCapacity(t *testing.T) {
+	ctx := context.Background()
+	remote := NewMemoryStore(1000)
+	c, err := NewCache(
+		WithMemoryCapacity(100),
+		WithDiskCapacity(100),
+		WithTotalCapacity(120),
+		WithRemoteStore(remote),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	value := make([]byte, 16)
+	for i := 0; i < 10; i++ {
+		if err := c.Set(ctx, fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatalf("Failed to set key%d: %v", i, err)
+		}
+		if total := c.memoryStore.GetUsage() + c.diskStore.GetUsage(); total > 120 {
+			t.Fatalf("Expected memory and disk within 120 bytes after key%d, got %d", i, total)
+		}
+	}
+
+	// Memory holds the last five keys at 20 bytes each, so the budget
+	// leaves room for one entry on disk, which charges its 16-byte value,
+	// even though the disk tier alone could hold six.
+	if got := c.diskStore.GetUsage(); got != 16 {
+		t.Errorf("Expected one entry on disk, got %d bytes", got)
+	}
+	if stats := c.GetCacheStats(); stats.DiskEvictions != 4 {
+		t.Errorf("Expected 4 global evictions from disk, got %d", stats.DiskEvictions)
+	}
+	for i := 0; i < 4; i++ {
+		if _, tier, err := c.GetFromTier(ctx, fmt.Sprintf("key%d", i)); err != nil || tier != TierRemote {
+			t.Errorf("Expected key%d to have moved to the remote tier, got %v, %v", i, tier, err)
+		}
+	}
+}
+
+func TestShardedRemoteStore(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	configs := func(addrs ...string) []RemoteStoreConfig {
+		var cfgs []RemoteStoreConfig
+		for _, addr := range addrs {
+			cfgs = append(cfgs, RemoteStoreConfig{Addr: addr})
+		}
+		return cfgs
+	}
+	s, err := NewShardedRemoteStore(configs("redis-a:6379", "redis-b:6379", "redis-c:6379"))
+	if err != nil {
+		t.Fatalf("Failed to create sharded store: %v", err)
+	}
+	ctx := context.Background()
+
+	const n = 300
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := s.Set(ctx, &CacheEntry{Key: key, Value: []byte(key)}); err != nil {
+			t.Fatalf("Set %s failed: %v", key, err)
+		}
+	}
+	for i, node := range s.nodes {
+		if count := len(node.Keys(ctx)); count < n/6 {
+			t.Errorf("Expected %s to hold a fair share of %d keys, got %d", s.addrs[i], n, count)
+		}
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if entry, err := s.Get(ctx, key); err != nil || string(entry.Value) != key {
+			t.Errorf("Expected %s to be retrievable, got %v, %v", key, entry, err)
+		}
+	}
+	if keys := s.Keys(ctx); len(keys) != n {
+		t.Errorf("Expected Keys to return all %d keys, got %d", n, len(keys))
+	}
+	if entries := s.GetAll(ctx); len(entries) != n {
+		t.Errorf("Expected GetAll to return all %d entries, got %d", n, len(entries))
+	}
+	if metrics, err := s.GetMetrics(ctx); err != nil || metrics.KeyCount != n {
+		t.Errorf("Expected metrics summed over the nodes, got %+v, %v", metrics, err)
+	}
+	found, err := s.GetMulti(ctx, []string{"key1", "key2", "key3", "missing"})
+	if err != nil || len(found) != 3 {
+		t.Errorf("Expected GetMulti to gather keys from every node, got %d, %v", len(found), err)
+	}
+
+	// Adding a node should only move the keys on its share of the ring.
+	grown, err := NewShardedRemoteStore(configs("redis-a:6379", "redis-b:6379", "redis-c:6379", "redis-d:6379"))
+	if err != nil {
+		t.Fatalf("Failed to create sharded store: %v", err)
+	}
+	moved := 0
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if s.addrs[s.nodeIndex(key)] != grown.addrs[grown.nodeIndex(key)] {
+			moved++
+		}
+	}
+	if moved == 0 || moved > n/2 {
+		t.Errorf("Expected about a quarter of the keys to move to the new node, %d of %d moved", moved, n)
+	}
+
+	if err := s.Clear(ctx); err != nil || len(s.Keys(ctx)) != 0 {
+		t.Errorf("Expected Clear to empty every node, got %v", err)
+	}
+	if _, err := NewShardedRemoteStore(configs("redis-a:6379", "redis-a:6379")); err == nil {
+		t.Error("Expected duplicate addresses to be rejected")
+	}
+}
+
+func TestInvalidationChannel(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	newNode := func() *MultiTierCache {
+		c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(remote),
+			WithWriteThrough(), WithInvalidationChannel("invalidations"))
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	a, b := newNode(), newNode()
+	ctx := context.Background()
+
+	a.Set(ctx, "key", []byte("v1"))
+	if value, err := b.Get(ctx, "key"); err != nil || string(value) != "v1" {
+		t.Fatalf("Expected v1 from the shared remote tier, got %q, %v", value, err)
+	}
+	if _, tier, _ := b.GetFromTier(ctx, "key"); tier != TierMemory {
+		t.Fatalf("Expected b to have cached the key in memory, got %v", tier)
+	}
+
+	a.Set(ctx, "key", []byte("v2"))
+	if value, err := a.Get(ctx, "key"); err != nil || string(value) != "v2" {
+		t.Errorf("Expected a to keep its own write, got %q, %v", value, err)
+	}
+	if value, err := b.Get(ctx, "key"); err != nil || string(value) != "v2" {
+		t.Errorf("Expected b to read the new value after invalidation, got %q, %v", value, err)
+	}
+
+	a.Delete(ctx, "key")
+	if _, err := peekEntry(ctx, b.memoryStore, "key"); err == nil {
+		t.Error("Expected a delete on a to evict the key from b's memory tier")
+	}
+	if _, err := b.diskStore.Get(ctx, "key"); err == nil {
+		t.Error("Expected a delete on a to evict the key from b's disk tier")
+	}
+	if _, err := b.Get(ctx, "key"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound on b after the delete, got %v", err)
+	}
+
+	if _, err := NewCache(WithInvalidationChannel("invalidations")); !errors.Is(err, ErrPubSubUnsupported) {
+		t.Errorf("Expected ErrPubSubUnsupported without a pub/sub remote tier, got %v", err)
+	}
+}
+
+// spanRecorder is a Tracer that keeps every span it starts.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name  string
+	attrs map[string]string
+	err   error
+	ended bool
+}
+
+type recordedSpanKey struct{}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &recordedSpan{name: name, attrs: map[string]string{}}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) { s.attrs[key] = value }
+func (s *recordedSpan) RecordError(err error)          { s.err = err }
+func (s *recordedSpan) End()                           { s.ended = true }
+
+// spanCheckingStore records the span in the context of each Delete.
+type spanCheckingStore struct {
+	Store
+	deleteSpans []any
+}
+
+func (s *spanCheckingStore) Delete(ctx context.Context, key string) error {
+	s.deleteSpans = append(s.deleteSpans, ctx.Value(recordedSpanKey{}))
+	return s.Store.Delete(ctx, key)
+}
+
+func TestWithTracer(t *testing.T) {
+	ctx := context.Background()
+	recorder := &spanRecorder{}
+	remote := &spanCheckingStore{Store: NewMemoryStore(1000)}
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithRemoteStore(remote), WithTracer(recorder))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "key", []byte("value"))
+	c.Get(ctx, "key")
+	c.Get(ctx, "missing")
+	c.Delete(ctx, "key")
+
+	want := []struct {
+		name, tier, result string
+	}{
+		{"cache.Set", "", "ok"},
+		{"cache.Get", "memory", "hit"},
+		{"cache.Get", "none", "miss"},
+		{"cache.Delete", "", "ok"},
+	}
+	if len(recorder.spans) != len(want) {
+		t.Fatalf("Expected %d spans, got %d", len(want), len(recorder.spans))
+	}
+	for i, w := range want {
+		span := recorder.spans[i]
+		if span.name != w.name || span.attrs[TraceAttrTier] != w.tier || span.attrs[TraceAttrResult] != w.result {
+			t.Errorf("Span %d: expected %s with tier %q and result %q, got %s with %v", i, w.name, w.tier, w.result, span.name, span.attrs)
+		}
+		if !span.ended {
+			t.Errorf("Span %d (%s) was not ended", i, span.name)
+		}
+	}
+	if key := recorder.spans[1].attrs[TraceAttrKey]; key != "key" {
+		t.Errorf("Expected the key attribute to be %q, got %q", "key", key)
+	}
+	if len(remote.deleteSpans) != 1 || remote.deleteSpans[0] != recorder.spans[3] {
+		t.Error("Expected the remote tier to get the Delete span's context")
+	}
+
+	// A failed Set records its error.
+	c.Set(ctx, "key", make([]byte, 5000))
+	if span := recorder.spans[len(recorder.spans)-1]; span.attrs[TraceAttrResult] != "error" || span.err == nil {
+		t.Errorf("Expected an error span for a failed Set, got %v with error %v", span.attrs, span.err)
+	}
+}
+
+func TestWithTraceKeyHashing(t *testing.T) {
+	recorder := &spanRecorder{}
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithTracer(recorder), WithTraceKeyHashing(true))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Get(context.Background(), "secret")
+	// The SHA-256 of "secret".
+	want := "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"
+	if key := recorder.spans[0].attrs[TraceAttrKey]; key != want {
+		t.Errorf("Expected the hashed key %q, got %q", want, key)
+	}
+}
+
+func TestKeyValidation(t *testing.T) {
+	ctx := context.Background()
+	remote := &countingStore{Store: NewMemoryStore(1000)}
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithRemoteStore(remote), WithMaxKeyLength(8))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	for _, key := range []string{"", "ninechars"} {
+		if err := c.Set(ctx, key, []byte("value")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Set(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := c.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if err := c.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := c.GetMulti(ctx, []string{"a", key}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("GetMulti(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if c.Has(ctx, key) {
+			t.Errorf("Has(%q): expected false", key)
+		}
+		if _, err := c.Peek(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Peek(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := c.CompareAndSwap(ctx, key, nil, []byte("1")); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CompareAndSwap(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+		if _, err := c.Increment(ctx, key, 1); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Increment(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+	if n := remote.gets.Load(); n != 0 {
+		t.Errorf("Expected invalid keys not to reach the remote tier, got %d gets", n)
+	}
+	if _, misses := c.GetStats(); misses != 0 {
+		t.Errorf("Expected invalid keys not to count as misses, got %d", misses)
+	}
+
+	// A key of exactly the maximum length is accepted.
+	if err := c.Set(ctx, "eightchr", []byte("value")); err != nil {
+		t.Fatalf("Set at the maximum key length failed: %v", err)
+	}
+	if value, err := c.Get(ctx, "eightchr"); err != nil || string(value) != "value" {
+		t.Errorf("Expected value, got %q, %v", value, err)
+	}
+	if err := c.Delete(ctx, "eightchr"); err != nil {
+		t.Errorf("Delete at the maximum key length failed: %v", err)
+	}
+
+	// Without WithMaxKeyLength keys aren't limited.
+	unlimited := newSimulatedCache(t, 10000, 10000)
+	defer unlimited.Close()
+	long := strings.Repeat("k", 4096)
+	if err := unlimited.Set(ctx, long, []byte("value")); err != nil {
+		t.Fatalf("Set with a long key failed: %v", err)
+	}
+	if value, err := unlimited.Get(ctx, long); err != nil || string(value) != "value" {
+		t.Errorf("Expected value for a long key, got %q, %v", value, err)
+	}
+}
+
+type objectPoint struct {
+	X, Y  int
+	Label string
+}
+
+func init() {
+	RegisterType(objectPoint{})
+}
+
+func TestSetObject(t *testing.T) {
+	c := newSimulatedCache(t, 20, 20)
+	ctx := context.Background()
+	point := objectPoint{X: 1, Y: 2, Label: "origin"}
+
+	if err := c.SetObject(ctx, "point", point, 8); err != nil {
+		t.Fatalf("SetObject failed: %v", err)
+	}
+	if obj, err := c.GetObject(ctx, "point"); err != nil || obj != point {
+		t.Fatalf("Expected %+v from memory, got %+v, %v", point, obj, err)
+	}
+
+	// Push point down to disk, where it is gob-encoded with its entry.
+	c.Set(ctx, "k1", []byte("0123456789"))
+	c.Set(ctx, "k2", []byte("0123456789"))
+	entry, err := c.diskStore.Get(ctx, "point")
+	if err != nil {
+		t.Fatalf("Expected point on disk: %v", err)
+	}
+	if entry.Object != point || entry.Value != nil {
+		t.Errorf("Expected the disk tier to round-trip the object, got %+v", entry)
+	}
+	if usage := c.diskStore.GetUsage(); usage != 8+10 {
+		t.Errorf("Expected the object to be charged its size 8 on disk, got %d in all", usage)
+	}
+
+	// And on to the remote tier.
+	c.Set(ctx, "k3", []byte("0123456789"))
+	if entry, err := c.remoteStore.Get(ctx, "point"); err != nil || entry.Object != point {
+		t.Fatalf("Expected the remote tier to round-trip the object, got %+v, %v", entry, err)
+	}
+	if obj, err := c.GetObject(ctx, "point"); err != nil || obj != point {
+		t.Errorf("Expected %+v from the remote tier, got %+v, %v", point, obj, err)
+	}
+
+	if _, err := c.GetObject(ctx, "k3"); !errors.Is(err, ErrDecode) {
+		t.Errorf("Expected ErrDecode for a byte value, got %v", err)
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	t.Setenv("SIMULATE_REMOTE_STORE", "true")
+	ctx := context.Background()
+	remote, err := NewRemoteStore("localhost:6379")
+	if err != nil {
+		t.Fatalf("Failed to create remote store: %v", err)
+	}
+	src, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100), WithRemoteStore(remote))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer src.Close()
+
+	src.SetWithMetadata(ctx, "local", []byte("value1"), map[string]string{"content-type": "text/plain"})
+	remote.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("value2"), Metadata: map[string]string{"etag": "abc"}})
+
+	var buf bytes.Buffer
+	if err := src.Export(ctx, &buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	dst, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer dst.Close()
+	if err := dst.Import(ctx, &buf); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	for key, want := range map[string]map[string]string{
+		"local":  {"content-type": "text/plain"},
+		"remote": {"etag": "abc"},
+	} {
+		entry, err := dst.GetWithMetadata(ctx, key)
+		if err != nil || !maps.Equal(entry.Metadata, want) {
+			t.Errorf("Expected %s to keep metadata %v, got %+v, %v", key, want, entry, err)
+		}
+	}
+}
+
+func TestExportImportObject(t *testing.T) {
+	src := newSimulatedCache(t, 20, 20)
+	defer src.Close()
+	ctx := context.Background()
+	onDisk := objectPoint{X: 1, Y: 2, Label: "disk"}
+	inMemory := objectPoint{X: 3, Y: 4, Label: "memory"}
+
+	src.SetObject(ctx, "a", onDisk, 8)
+	src.Set(ctx, "k1", []byte("0123456789"))
+	src.SetObject(ctx, "b", inMemory, 8)
+	if _, err := src.diskStore.Get(ctx, "a"); err != nil {
+		t.Fatalf("Expected a to have been pushed to disk: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := src.Export(ctx, &buf); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	dst, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(100))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer dst.Close()
+	if err := dst.Import(ctx, &buf); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	for key, want := range map[string]objectPoint{"a": onDisk, "b": inMemory} {
+		if obj, err := dst.GetObject(ctx, key); err != nil || obj != want {
+			t.Errorf("Expected %s=%+v after import, got %+v, %v", key, want, obj, err)
+		}
+		if entry, err := dst.memoryStore.Get(ctx, key); err != nil || entry.Size != 8 {
+			t.Errorf("Expected %s to keep its size 8, got %+v, %v", key, entry, err)
+		}
+	}
+	if got, err := dst.Get(ctx, "k1"); err != nil || string(got) != "0123456789" {
+		t.Errorf("Expected k1 after import, got %q, %v", got, err)
+	}
+}
+
+func TestTopEntries(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000), WithClock(clock), WithNamespace("ns"))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	// a is the largest and least recently used, c the most accessed.
+	c.Set(ctx, "a", []byte(strings.Repeat("a", 30)))
+	clock.Advance(time.Second)
+	c.Set(ctx, "b", []byte(strings.Repeat("b", 10)))
+	clock.Advance(time.Second)
+	c.Set(ctx, "c", []byte(strings.Repeat("c", 20)))
+	for i := 0; i < 3; i++ {
+		c.Get(ctx, "c")
+	}
+	c.Get(ctx, "b")
+	c.SetWithTTL(ctx, "expired", []byte(strings.Repeat("x", 100)), time.Second)
+	clock.Advance(2 * time.Second)
+
+	tests := []struct {
+		by   SortKey
+		want string
+	}{
+		{SortBySize, "[a c b]"},
+		{SortByLastAccess, "[a c b]"},
+		{SortByFrequency, "[c b a]"},
+	}
+	for _, tt := range tests {
+		var keys []string
+		for _, entry := range c.TopEntries(ctx, tt.by, 10) {
+			keys = append(keys, entry.Key)
+		}
+		if fmt.Sprint(keys) != tt.want {
+			t.Errorf("TopEntries(%v): expected %s, got %v", tt.by, tt.want, keys)
+		}
+	}
+
+	top := c.TopEntries(ctx, SortBySize, 1)
+	if len(top) != 1 || top[0].Key != "a" || top[0].Tier != TierMemory {
+		t.Fatalf("Expected only a, from memory, got %+v", top)
+	}
+	top[0].Value[0] = 'z'
+	if value, _ := c.Get(ctx, "a"); value[0] != 'a' {
+		t.Error("Expected TopEntries to return copies")
+	}
+}
+
+func TestWithAutoTune(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000), WithAutoTune(5*time.Millisecond, 50, 600))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	// Every read is a memory hit, so memory should gain capacity from disk.
+	c.Set(ctx, "hot", []byte("value"))
+	deadline := time.Now().Add(2 * time.Second)
+	for c.memoryStore.GetCapacity() < 600 && time.Now().Before(deadline) {
+		c.Get(ctx, "hot")
+	}
+	memCap, diskCap := c.memoryStore.GetCapacity(), c.diskStore.GetCapacity()
+	if memCap != 600 {
+		t.Errorf("Expected memory to grow to its bound of 600, got %d", memCap)
+	}
+	if memCap+diskCap != 1100 {
+		t.Errorf("Expected the total capacity to stay 1100, got %d + %d", memCap, diskCap)
+	}
+	if value, err := c.Get(ctx, "hot"); err != nil || string(value) != "value" {
+		t.Errorf("Expected hot to survive tuning, got %q, %v", value, err)
+	}
+}
+
+func TestDeleteExisting(t *testing.T) {
+	c := newSimulatedCache(t, 1000, 1000)
+	defer c.Close()
+	ctx := context.Background()
+
+	c.Set(ctx, "local", []byte("value"))
+	// Only in Redis, so only DEL's count can tell it was there.
+	c.remoteStore.Set(ctx, &CacheEntry{Key: "remote", Value: []byte("value")})
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"local", true},
+		{"local", false},
+		{"remote", true},
+		{"remote", false},
+		{"never", false},
+	}
+	for _, tt := range tests {
+		existed, err := c.DeleteExisting(ctx, tt.key)
+		if err != nil {
+			t.Fatalf("DeleteExisting(%q) failed: %v", tt.key, err)
+		}
+		if existed != tt.want {
+			t.Errorf("DeleteExisting(%q): expected %v, got %v", tt.key, tt.want, existed)
+		}
+	}
+	if _, err := c.Get(ctx, "local"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected local to be deleted, got %v", err)
+	}
+	if _, err := c.DeleteExisting(ctx, ""); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for an empty key, got %v", err)
+	}
+}
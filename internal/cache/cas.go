@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Swapper is implemented by stores that can replace a value conditionally
+// and atomically.
+type Swapper interface {
+	CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error)
+}
+
+// AbsentSetter is implemented by stores that can store an entry only if its
+// key is absent, atomically.
+type AbsentSetter interface {
+	SetIfAbsent(ctx context.Context, entry *CacheEntry) (bool, error)
+}
+
+// CompareAndSwap replaces the value at key with new only if the current
+// value equals old, and reports whether it did. A missing or expired key
+// never swaps. The entry's expiry is kept.
+//
+// As with Increment, the tier holding the key does the comparison, and with
+// write-through and a swapping remote tier the remote value is
+// authoritative.
+func (c *MultiTierCache) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	defer c.observeLatency("cas", time.Now())
+	defer c.dispatchEvictions()
+
+	if err := c.checkKey("cas", key); err != nil {
+		return false, err
+	}
+	if c.tooLarge(len(new)) {
+		return false, &CacheError{Op: "cas", Key: key, Err: ErrEntryTooLarge}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sk := c.storeKey(key)
+	c.recordAccess(sk)
+
+	if swapper, ok := c.remoteStore.(Swapper); ok && c.writeThrough {
+		swapped, err := swapper.CompareAndSwap(ctx, sk, old, new)
+		if err != nil || !swapped {
+			return false, err
+		}
+		c.refreshLocal(ctx, &CacheEntry{
+			Key:        sk,
+			Value:      new,
+			Size:       len(new),
+			LastAccess: c.clock.Now(),
+			AccessSeq:  nextAccessSeq(),
+			Frequency:  1,
+		})
+		return true, nil
+	}
+
+	now := c.clock.Now()
+	for _, store := range []Store{c.memoryStore, c.diskStore, c.remoteStore} {
+		swapper, ok := store.(Swapper)
+		if !ok || !storeHas(ctx, store, sk, now) {
+			continue
+		}
+		swapped, err := swapper.CompareAndSwap(ctx, sk, old, new)
+		if err != nil || !swapped {
+			return false, err
+		}
+		if store == c.memoryStore {
+			return true, c.persistUpdated(ctx, sk)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// GetWithVersion is like Get but also returns the entry's version, for a
+// later SetWithVersion.
+func (c *MultiTierCache) GetWithVersion(ctx context.Context, key string) ([]byte, uint64, error) {
+	entry, _, err := c.get(ctx, key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return entry.Value, entry.Version, nil
+}
+
+// SetWithVersion stores value at key only if the key's version is still
+// expectedVersion, failing with ErrVersionMismatch if another Set got there
+// first. Use 0 for a key that doesn't exist yet. Versions are tracked by
+// the memory and disk tiers.
+func (c *MultiTierCache) SetWithVersion(ctx context.Context, key string, value []byte, expectedVersion uint64) error {
+	return c.set(ctx, key, value, SetOptions{}, &expectedVersion)
+}
+
+// swapEntry returns the entry that replaces existing with value if
+// existing is live at now and holds old, or nil otherwise.
+func swapEntry(existing *CacheEntry, old, value []byte, now time.Time) *CacheEntry {
+	if existing == nil || existing.expired(now) || !bytes.Equal(existing.Value, old) {
+		return nil
+	}
+	swapped := *existing
+	swapped.Value = value
+	swapped.Size = len(value)
+	swapped.LastAccess = now
+	swapped.AccessSeq = nextAccessSeq()
+	swapped.Frequency++
+	swapped.Version++
+	return &swapped
+}
+
+// GetOrSet returns the live value for key, or stores value and returns it if
+// there is none, reporting whether it did the set. The check and the set
+// happen under the cache's write lock, so concurrent callers all see the
+// same value and only one of them sets it. With write-through and a remote
+// tier implementing AbsentSetter, as RemoteStore does with SET NX, the remote
+// tier decides, so caches sharing it agree as well. Under write-back the set
+// is persisted synchronously.
+func (c *MultiTierCache) GetOrSet(ctx context.Context, key string, value []byte) ([]byte, bool, error) {
+	defer c.observeLatency("set", time.Now())
+	defer c.dispatchEvictions()
+
+	entry, err := c.newEntry(key, value, SetOptions{})
+	if err != nil {
+		return nil, false, err
+	}
+	existing, err := c.getOrSet(ctx, entry)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		return existing.Value, false, nil
+	}
+	c.publishInvalidation(ctx, entry.Key)
+	return value, true, nil
+}
+
+// getOrSet returns the live entry for entry.Key or, if there is none, stores
+// entry and returns nil.
+func (c *MultiTierCache) getOrSet(ctx context.Context, entry *CacheEntry) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sk := entry.Key
+	now := c.clock.Now()
+	negative := c.negatives.has(sk, now)
+	if !negative {
+		if existing, _, ok := c.getLocal(ctx, sk, now); ok {
+			return existing, nil
+		}
+	}
+
+	setter, atomicRemote := c.remoteStore.(AbsentSetter)
+	atomicRemote = atomicRemote && c.writeThrough
+	if atomicRemote {
+		set, err := setter.SetIfAbsent(ctx, entry)
+		if err != nil {
+			return nil, &CacheError{Op: "set", Tier: TierRemote, Key: sk, Err: err}
+		}
+		c.noteRemoteKey(sk)
+		if !set {
+			existing, err := c.remoteStore.Get(ctx, sk)
+			if err != nil {
+				return nil, err
+			}
+			c.remoteHit(ctx, sk, existing)
+			return existing, nil
+		}
+	} else if !negative {
+		if existing, err := c.remoteStore.Get(ctx, sk); err == nil {
+			c.remoteHit(ctx, sk, existing)
+			return existing, nil
+		}
+	}
+
+	c.recordMiss(sk)
+	atomic.AddInt64(&c.statsMisses, 1)
+	c.negatives.remove(sk)
+	c.recordAccess(sk)
+	if err := c.assignVersion(ctx, entry, nil); err != nil {
+		return nil, err
+	}
+	defer c.fitTotalCapacity(ctx, sk)
+	switch {
+	case atomicRemote:
+		c.setInStore(ctx, c.memoryStore, entry)
+		c.setInStore(ctx, c.diskStore, entry)
+		return nil, nil
+	case c.writeThrough:
+		return nil, c.setWriteThrough(ctx, entry)
+	case c.writeBack != nil:
+		c.setInStore(ctx, c.memoryStore, entry)
+		return nil, c.persistLocked(ctx, entry)
+	}
+	return nil, c.setEntry(ctx, entry)
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// DebugHandler returns a read-only http.Handler for inspecting c, serving
+// JSON on these GET endpoints:
+//
+//	/keys          the keys in any tier, or those matching ?pattern=
+//	/keys/{key}    the entry at key, read as GetWithMetadata does
+//	/stats         the Stats snapshot
+//	/metrics       each tier's usage and capacity, and Redis's metrics
+//
+// Mount it under a prefix with http.StripPrefix. Entries are read through
+// the cache, so looking one up counts as a hit and may promote it. The
+// handler exposes cached values to anyone who can reach it.
+func DebugHandler(c *MultiTierCache) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		var keys []string
+		if pattern := r.URL.Query().Get("pattern"); pattern != "" {
+			keys = c.KeysMatching(r.Context(), pattern)
+		} else {
+			keys = c.Keys(r.Context())
+		}
+		if keys == nil {
+			keys = []string{}
+		}
+		writeDebugJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+	})
+	mux.HandleFunc("GET /keys/{key...}", func(w http.ResponseWriter, r *http.Request) {
+		entry, err := c.GetWithMetadata(r.Context(), r.PathValue("key"))
+		if err != nil {
+			writeDebugError(w, err)
+			return
+		}
+		writeDebugJSON(w, http.StatusOK, newDebugEntry(entry))
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, http.StatusOK, c.Stats())
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, http.StatusOK, c.debugMetrics(r.Context()))
+	})
+	return mux
+}
+
+// debugEntry is the JSON form of an entry. Values that are valid UTF-8 are
+// shown as text in Value; others are base64 encoded in ValueBase64.
+type debugEntry struct {
+	Key         string
+	Tier        string
+	Value       *string `json:",omitempty"`
+	ValueBase64 []byte  `json:",omitempty"`
+	Size        int
+	Version     uint64
+	Frequency   int
+	Priority    int
+	LastAccess  time.Time
+	ExpiresAt   *time.Time        `json:",omitempty"`
+	Metadata    map[string]string `json:",omitempty"`
+}
+
+func newDebugEntry(entry *CacheEntry) debugEntry {
+	e := debugEntry{
+		Key:        entry.Key,
+		Tier:       entry.Tier.String(),
+		Size:       entry.Size,
+		Version:    entry.Version,
+		Frequency:  entry.Frequency,
+		Priority:   entry.Priority,
+		LastAccess: entry.LastAccess,
+		Metadata:   entry.Metadata,
+	}
+	if utf8.Valid(entry.Value) {
+		value := string(entry.Value)
+		e.Value = &value
+	} else {
+		e.ValueBase64 = entry.Value
+	}
+	if !entry.ExpiresAt.IsZero() {
+		e.ExpiresAt = &entry.ExpiresAt
+	}
+	return e
+}
+
+// debugTierMetrics is a tier's entry in the /metrics response.
+type debugTierMetrics struct {
+	Usage    int
+	Capacity int
+}
+
+type debugRemoteMetrics struct {
+	StoreMetrics
+	Error string `json:",omitempty"`
+}
+
+func (c *MultiTierCache) debugMetrics(ctx context.Context) map[string]any {
+	metrics := map[string]any{
+		"memory": debugTierMetrics{Usage: c.memoryStore.GetUsage(), Capacity: c.memoryStore.GetCapacity()},
+		"disk":   debugTierMetrics{Usage: c.diskStore.GetUsage(), Capacity: c.diskStore.GetCapacity()},
+	}
+	if m, ok := c.remoteStore.(interface {
+		GetMetrics(context.Context) (StoreMetrics, error)
+	}); ok {
+		remote, err := m.GetMetrics(ctx)
+		r := debugRemoteMetrics{StoreMetrics: remote}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		metrics["remote"] = r
+	}
+	return metrics
+}
+
+func writeDebugJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeDebugError reports err with the status that fits its cause.
+func writeDebugError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrNegativeCached):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrInvalidKey):
+		status = http.StatusBadRequest
+	}
+	writeDebugJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getDebugJSON(t *testing.T, h http.Handler, path string, wantStatus int, v any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != wantStatus {
+		t.Fatalf("GET %s: expected status %d, got %d: %s", path, wantStatus, rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET %s: expected JSON, got %q", path, ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("GET %s: invalid JSON %q: %v", path, rec.Body, err)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	c := newSimulatedCache(t, 1000, 1000)
+	defer c.Close()
+	ctx := context.Background()
+	c.SetWithMetadata(ctx, "user:1", []byte("alice"), map[string]string{"type": "text"})
+	c.Set(ctx, "user:2", []byte("bob"))
+	c.Set(ctx, "blob", []byte{0xff, 0x00})
+	c.Get(ctx, "user:1")
+	c.Get(ctx, "missing")
+
+	h := DebugHandler(c)
+
+	var keys struct{ Keys []string }
+	getDebugJSON(t, h, "/keys?pattern=user:*", http.StatusOK, &keys)
+	if len(keys.Keys) != 2 {
+		t.Errorf("Expected the two user keys, got %v", keys.Keys)
+	}
+
+	var entry debugEntry
+	getDebugJSON(t, h, "/keys/user:1", http.StatusOK, &entry)
+	if entry.Key != "user:1" || entry.Tier != "memory" || entry.Value == nil || *entry.Value != "alice" || entry.Metadata["type"] != "text" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	entry = debugEntry{}
+	getDebugJSON(t, h, "/keys/blob", http.StatusOK, &entry)
+	if entry.Value != nil || string(entry.ValueBase64) != "\xff\x00" {
+		t.Errorf("Expected a binary value in ValueBase64, got %+v", entry)
+	}
+
+	var errResp struct{ Error string }
+	getDebugJSON(t, h, "/keys/missing", http.StatusNotFound, &errResp)
+	if errResp.Error == "" {
+		t.Error("Expected an error message for a missing key")
+	}
+
+	var stats Stats
+	getDebugJSON(t, h, "/stats", http.StatusOK, &stats)
+	if stats.MemoryHits != 3 || stats.Misses != 2 {
+		t.Errorf("Expected 3 memory hits and 2 misses, got %+v", stats)
+	}
+
+	var metrics struct {
+		Memory, Disk debugTierMetrics
+		Remote       debugRemoteMetrics
+	}
+	getDebugJSON(t, h, "/metrics", http.StatusOK, &metrics)
+	if metrics.Memory.Capacity != 1000 || metrics.Memory.Usage != c.memoryStore.GetUsage() || metrics.Disk.Capacity != 1000 {
+		t.Errorf("Unexpected tier metrics %+v", metrics)
+	}
+	if metrics.Remote.KeyCount != int64(len(c.remoteStore.(*RemoteStore).simulateMap)) || metrics.Remote.Error != "" {
+		t.Errorf("Unexpected remote metrics %+v", metrics.Remote)
+	}
+}
@@ -1,148 +1,990 @@
 package cache
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
-	"encoding/gob"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"io/ioutil"
-	"os"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type DiskStore struct {
 	mu       sync.RWMutex
+	fs       FileSystem
 	dir      string
 	capacity int
 	usage    int
+	compress bool
+	codec    EntryCodec
+	maxFiles int
+	// aead encrypts entry files when the store has an encryption key.
+	aead cipher.AEAD
+	// sizes records the bytes each key was charged on Set so Delete can
+	// release the same amount. It also serves as the index of original keys,
+	// since filenames are hashes.
+	sizes map[string]int
+	// streamed holds the keys whose values are in a separate file written
+	// by SetStream.
+	streamed map[string]struct{}
+	clock    Clock
+	logger   Logger
+	// keyLocks serialize file operations on keys that hash to the same
+	// lock, so that mu, which guards the index, is never held during I/O
+	// and different keys are read and written in parallel.
+	keyLocks [diskKeyLocks]sync.RWMutex
+	// slots bounds the file operations in flight when MaxConcurrency is
+	// set.
+	slots chan struct{}
+}
+
+// diskKeyLocks is the number of locks DiskStore spreads keys over.
+const diskKeyLocks = 64
+
+// streamSuffix names the file holding a streamed entry's value, next to
+// the entry's own file.
+const streamSuffix = ".value"
+
+// tempSuffix names the file an entry is written to before it is renamed
+// over the entry's own file.
+const tempSuffix = ".tmp"
+
+// DiskStoreOptions configures a DiskStore created with
+// NewDiskStoreWithOptions.
+type DiskStoreOptions struct {
+	// Dir holds the entry files. It is created if needed, and entries left
+	// there by an earlier store are recovered. If empty, a fresh temporary
+	// directory is used.
+	Dir string
+	// Compress gzip-compresses values before writing them.
+	Compress bool
+	// Codec serializes entries. Defaults to GobEntryCodec.
+	Codec EntryCodec
+	// MaxFiles caps the number of entry files; zero means no limit.
+	MaxFiles int
+	// EncryptionKey, if set, must be 32 bytes. Entry files are then
+	// encrypted with AES-256-GCM under a random per-file nonce.
+	EncryptionKey []byte
+	// FileSystem performs all file operations. Defaults to the host
+	// filesystem.
+	FileSystem FileSystem
+	// Clock decides which entries have expired. Defaults to the real
+	// clock.
+	Clock Clock
+	// Logger is told about corrupt entry files as they are removed.
+	// Defaults to discarding messages.
+	Logger Logger
+	// MaxConcurrency caps the reads and writes of entry files in flight
+	// at once, so many concurrent Sets queue instead of overwhelming the
+	// disk; zero means no limit. Operations on the same key always run
+	// one at a time.
+	MaxConcurrency int
 }
 
 func NewDiskStore(capacity int) (*DiskStore, error) {
-	dir, err := os.MkdirTemp("", "diskcache")
-	if err != nil {
+	return NewDiskStoreWithOptions(capacity, DiskStoreOptions{})
+}
+
+// NewDiskStoreWithDir creates a DiskStore in dir, creating it if needed.
+// Entries left in dir by an earlier store are recovered, so the disk tier
+// persists across restarts; expired ones are removed.
+func NewDiskStoreWithDir(dir string, capacity int) (*DiskStore, error) {
+	return NewDiskStoreWithOptions(capacity, DiskStoreOptions{Dir: dir})
+}
+
+func NewDiskStoreWithOptions(capacity int, opts DiskStoreOptions) (*DiskStore, error) {
+	s := &DiskStore{
+		fs:       opts.FileSystem,
+		dir:      opts.Dir,
+		capacity: capacity,
+		compress: opts.Compress,
+		codec:    opts.Codec,
+		maxFiles: opts.MaxFiles,
+		sizes:    make(map[string]int),
+		streamed: make(map[string]struct{}),
+		clock:    clockOrReal(opts.Clock),
+		logger:   opts.Logger,
+	}
+	if s.logger == nil {
+		s.logger = nopLogger{}
+	}
+	if s.codec == nil {
+		s.codec = GobEntryCodec{}
+	}
+	if opts.MaxConcurrency > 0 {
+		s.slots = make(chan struct{}, opts.MaxConcurrency)
+	}
+	if s.fs == nil {
+		s.fs = osFS{}
+	}
+
+	if opts.EncryptionKey != nil {
+		if len(opts.EncryptionKey) != 32 {
+			return nil, errors.New("disk encryption key must be 32 bytes")
+		}
+		block, err := aes.NewCipher(opts.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		if s.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.dir == "" {
+		dir, err := s.fs.MkdirTemp("", "diskcache")
+		if err != nil {
+			return nil, err
+		}
+		s.dir = dir
+		return s, nil
+	}
+
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
 		return nil, err
 	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	return &DiskStore{
-		dir:      dir,
-		capacity: capacity,
-	}, nil
+// load rebuilds the key index and usage from the files in the store's
+// directory. Files that aren't entries written by a DiskStore are ignored.
+func (s *DiskStore) load() error {
+	now := s.clock.Now()
+	return s.walk(func(path string) bool {
+		entry, err := s.decodeEntry(path)
+		if err != nil {
+			return true
+		}
+		if entry.removable(now) {
+			s.fs.Remove(path)
+			return true
+		}
+		size := chargedSize(entry)
+		if entry.Streamed {
+			size = entry.Size
+			s.streamed[entry.Key] = struct{}{}
+		}
+		s.sizes[entry.Key] = size
+		s.usage += size
+		return true
+	})
 }
 
-func (s *DiskStore) Get(_ context.Context, key string) (*CacheEntry, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *DiskStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	path := filepath.Join(s.dir, key)
-	file, err := os.Open(path)
+	unlock, err := s.rlockKey(ctx, key)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	path := s.path(key)
+	entry, err := s.readEntry(path)
+	unlock()
 
-	var entry CacheEntry
-	if err := gob.NewDecoder(file).Decode(&entry); err != nil {
-		return nil, err
+	if errors.Is(err, ErrDecode) {
+		s.removeCorrupt(ctx, key, path)
+		err = ErrKeyNotFound
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		err = ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, &CacheError{Op: "get", Tier: TierDisk, Key: key, Err: err}
+	}
+	return entry, nil
+}
+
+func (s *DiskStore) Set(ctx context.Context, entry *CacheEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	return &entry, nil
+	unlock, err := s.lockKey(ctx, entry.Key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return s.set(entry)
 }
 
-func (s *DiskStore) Set(_ context.Context, entry *CacheEntry) error {
+// set writes entry's file. The caller holds entry's key lock; mu is only
+// held to charge the entry against the capacity, so other keys can be
+// written meanwhile.
+func (s *DiskStore) set(entry *CacheEntry) error {
+	stored := entry
+	if s.compress && entry.Object == nil {
+		value, err := compressValue(entry.Value)
+		if err != nil {
+			return err
+		}
+		compressed := *entry
+		compressed.Value = value
+		compressed.Compressed = true
+		stored = &compressed
+	}
+
+	data, err := s.encodeEntry(stored)
+	if err != nil {
+		return err
+	}
+	path := s.path(entry.Key)
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	undo, err := s.charge(entry.Key, chargedSize(stored))
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(path, data, undo); err != nil {
+		return err
+	}
+	s.dropStream(entry.Key)
+	return nil
+}
+
+// charge records that key's file holds size bytes of value, failing
+// without changing anything if that would exceed the capacity or the file
+// limit. It returns a function that restores the previous charge, for when
+// the file turns out not to have been written; the caller must hold key's
+// lock until then.
+func (s *DiskStore) charge(key string, size int) (func(), error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if s.usage+entry.Size > s.capacity {
-		return errors.New("insufficient capacity")
+	prev, ok := s.sizes[key]
+	newUsage := s.usage + size - prev
+	if newUsage > s.capacity {
+		return nil, ErrInsufficientCapacity
+	}
+	if !ok && s.maxFiles > 0 && len(s.sizes) >= s.maxFiles {
+		return nil, ErrInsufficientCapacity
+	}
+	s.usage = newUsage
+	s.sizes[key] = size
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.usage += prev - size
+		if ok {
+			s.sizes[key] = prev
+		} else {
+			delete(s.sizes, key)
+		}
+	}, nil
+}
+
+func (s *DiskStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
 
-	path := filepath.Join(s.dir, entry.Key)
-	file, err := os.Create(path)
+	unlock, err := s.lockKey(ctx, key)
 	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	now := s.clock.Now()
+	entry := newCounterEntry(key, 0, now)
+	existing, err := s.readEntry(s.path(key))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, &CacheError{Op: "increment", Tier: TierDisk, Key: key, Err: err}
+	}
+	if err == nil && !existing.expired(now) {
+		entry = existing
+		entry.Frequency++
+		entry.Version++
+	}
+
+	n, err := incrementValue(entry.Value, delta)
+	if err != nil {
+		return 0, &CacheError{Op: "increment", Tier: TierDisk, Key: key, Err: err}
+	}
+	entry.Value = []byte(strconv.FormatInt(n, 10))
+	entry.Size = len(entry.Value)
+	if err := s.set(entry); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *DiskStore) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	unlock, err := s.lockKey(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	existing, err := s.readEntry(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, &CacheError{Op: "cas", Tier: TierDisk, Key: key, Err: err}
+	}
+	swapped := swapEntry(existing, old, new, s.clock.Now())
+	if swapped == nil {
+		return false, nil
+	}
+	if err := s.set(swapped); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Expire sets the expiry of the unexpired entry at key, rewriting only its
+// entry file: the value is kept as stored, compressed or streamed.
+func (s *DiskStore) Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	if err := gob.NewEncoder(file).Encode(entry); err != nil {
+	unlock, err := s.lockKey(ctx, key)
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	s.usage += entry.Size
-	return nil
+	path := s.path(key)
+	entry, err := s.decodeEntry(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && entry.expired(s.clock.Now()) {
+		err = ErrKeyNotFound
+	}
+	if err != nil {
+		return &CacheError{Op: "expire", Tier: TierDisk, Key: key, Err: err}
+	}
+	entry.ExpiresAt, entry.StaleUntil = expiresAt, staleUntil
+	data, err := s.encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path, data, nil)
 }
 
-func (s *DiskStore) Delete(_ context.Context, key string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *DiskStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	path := filepath.Join(s.dir, key)
-	info, err := os.Stat(path)
-	if err == nil {
-		s.usage -= int(info.Size())
-		return os.Remove(path)
+	unlock, err := s.lockKey(ctx, key)
+	if err != nil {
+		return err
 	}
+	defer unlock()
+
+	if err := s.fs.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// A file already removed behind the store's back still leaves its
+	// index entry and charge to drop.
+	s.dropStream(key)
+	s.unindex(key)
 	return nil
 }
 
-func (s *DiskStore) Clear(_ context.Context) error {
+// Clear removes every entry. Files in the store's directory that the store
+// didn't write are left in place.
+func (s *DiskStore) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i := range s.keyLocks {
+		s.keyLocks[i].Lock()
+		defer s.keyLocks[i].Unlock()
+	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if err := os.RemoveAll(s.dir); err != nil {
+	if err := s.removeFiles(); err != nil {
 		return err
 	}
 	s.usage = 0
-	return os.MkdirAll(s.dir, 0755)
+	s.sizes = make(map[string]int)
+	s.streamed = make(map[string]struct{})
+	return s.fs.MkdirAll(s.dir, 0755)
+}
+
+// Recalculate rebuilds the key index and usage from the files in the
+// store's directory, repairing drift left by files changed or removed
+// behind the store's back without touching any entry. A streamed value is
+// charged the size of its value file, and an entry whose value file is
+// missing is dropped from the index. Reads and writes wait until it is
+// done, so it suits startup or a quiet moment.
+func (s *DiskStore) Recalculate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for i := range s.keyLocks {
+		s.keyLocks[i].Lock()
+		defer s.keyLocks[i].Unlock()
+	}
+
+	sizes := make(map[string]int)
+	streamed := make(map[string]struct{})
+	usage := 0
+	err := s.walk(func(path string) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		entry, err := s.decodeEntry(path)
+		if err != nil {
+			return true
+		}
+		size := chargedSize(entry)
+		if entry.Streamed {
+			info, err := s.fs.Stat(path + streamSuffix)
+			if err != nil {
+				return true
+			}
+			size = int(info.Size())
+			streamed[entry.Key] = struct{}{}
+		}
+		sizes[entry.Key] = size
+		usage += size
+		return true
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes, s.streamed, s.usage = sizes, streamed, usage
+	return nil
 }
 
 func (s *DiskStore) GetCapacity() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.capacity
 }
 
+// SetCapacity changes the store's capacity to n bytes. Entries that no
+// longer fit are kept until the caller evicts them.
+func (s *DiskStore) SetCapacity(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = n
+}
+
 func (s *DiskStore) GetUsage() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.usage
 }
 
-func (s *DiskStore) Keys(_ context.Context) []string {
+// Len returns the number of entries in the store.
+func (s *DiskStore) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+	return len(s.sizes)
+}
 
-	var keys []string
-	files, _ := ioutil.ReadDir(s.dir)
-	for _, file := range files {
-		keys = append(keys, file.Name())
-	}
-	return keys
+func (s *DiskStore) MaxEntries() int {
+	return s.maxFiles
 }
 
-func (s *DiskStore) GetAll(_ context.Context) []*CacheEntry {
+func (s *DiskStore) Keys(_ context.Context) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	keys := make([]string, 0, len(s.sizes))
+	for k := range s.sizes {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (s *DiskStore) GetAll(ctx context.Context) []*CacheEntry {
 	var entries []*CacheEntry
-	files, _ := ioutil.ReadDir(s.dir)
-	for _, file := range files {
-		path := filepath.Join(s.dir, file.Name())
-		if entry, err := s.readEntry(path); err == nil {
+	for _, key := range s.Keys(ctx) {
+		if ctx.Err() != nil {
+			break
+		}
+		unlock, err := s.rlockKey(ctx, key)
+		if err != nil {
+			break
+		}
+		entry, err := s.readEntry(s.path(key))
+		unlock()
+		if err == nil {
 			entries = append(entries, entry)
 		}
 	}
 	return entries
 }
 
+// lockKey locks key for writing and waits for an operation slot,
+// returning a function that releases both.
+func (s *DiskStore) lockKey(ctx context.Context, key string) (func(), error) {
+	l := s.keyLock(key)
+	l.Lock()
+	release, err := s.acquire(ctx)
+	if err != nil {
+		l.Unlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		l.Unlock()
+	}, nil
+}
+
+// rlockKey is lockKey for reading.
+func (s *DiskStore) rlockKey(ctx context.Context, key string) (func(), error) {
+	l := s.keyLock(key)
+	l.RLock()
+	release, err := s.acquire(ctx)
+	if err != nil {
+		l.RUnlock()
+		return nil, err
+	}
+	return func() {
+		release()
+		l.RUnlock()
+	}, nil
+}
+
+func (s *DiskStore) keyLock(key string) *sync.RWMutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &s.keyLocks[h.Sum32()%diskKeyLocks]
+}
+
+// acquire takes an operation slot, waiting until one is free or ctx is
+// done, and returns a function that gives it back.
+func (s *DiskStore) acquire(ctx context.Context) (func(), error) {
+	if s.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return func() { <-s.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// path maps a key to its file. Keys are hashed so that separators, ".."
+// and overly long keys can't escape the cache directory or exceed
+// filesystem name limits. Files are sharded into subdirectories named after
+// the first byte of the hash to keep directories small.
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(s.dir, name[:2], name)
+}
+
+// walk calls fn with the path of every file in the store's shard
+// directories until fn returns false.
+func (s *DiskStore) walk(fn func(path string) bool) error {
+	shards, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, shard.Name())
+		files, err := s.fs.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			name := file.Name()
+			if file.IsDir() || strings.HasSuffix(name, streamSuffix) || strings.HasSuffix(name, tempSuffix) {
+				continue
+			}
+			if !fn(filepath.Join(dir, name)) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// removeFiles removes the store's entry, value and temporary files and then
+// its shard directories, leaving anything else in its directory alone.
+func (s *DiskStore) removeFiles() error {
+	shards, err := s.fs.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isHexName(shard.Name(), 2) {
+			continue
+		}
+		dir := filepath.Join(s.dir, shard.Name())
+		files, err := s.fs.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := strings.TrimSuffix(strings.TrimSuffix(file.Name(), tempSuffix), streamSuffix)
+			if file.IsDir() || !isHexName(name, sha256.Size*2) || !strings.HasPrefix(name, shard.Name()) {
+				continue
+			}
+			if err := s.fs.Remove(filepath.Join(dir, file.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		// A shard directory holding files of someone else's is kept.
+		s.fs.Remove(dir)
+	}
+	return nil
+}
+
+// isHexName reports whether name is n lowercase hex digits, as the store's
+// shard directories and entry files are named.
+func isHexName(name string, n int) bool {
+	if len(name) != n {
+		return false
+	}
+	for _, r := range name {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// chargedSize is the bytes an entry as written to its file is charged: its
+// value, possibly compressed, or for an Object entry the Size it was set
+// with.
+func chargedSize(stored *CacheEntry) int {
+	if stored.Object != nil {
+		return stored.Size
+	}
+	return len(stored.Value)
+}
+
+// writeFile replaces the file at path with data atomically: data is
+// written to a temporary file beside it, which is then renamed into place,
+// so readers see the previous contents or data but never part of it. If
+// that fails the temporary file is removed, the previous contents are
+// intact, and undo, if not nil, is called to restore their charge.
+func (s *DiskStore) writeFile(path string, data []byte, undo func()) error {
+	tmp := path + tempSuffix
+	f, err := s.fs.Create(tmp)
+	if err == nil {
+		_, err = f.Write(data)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = s.fs.Rename(tmp, path)
+		}
+		if err != nil {
+			s.fs.Remove(tmp)
+		}
+	}
+	if err != nil && undo != nil {
+		undo()
+	}
+	return err
+}
+
+// removeCorrupt is called by readers, which hold key's lock only for
+// reading, once they have found its entry file at path corrupt. It takes
+// the lock for writing and drops the file if it still fails to decode, so
+// that one written meanwhile by Set is kept.
+func (s *DiskStore) removeCorrupt(ctx context.Context, key, path string) {
+	unlock, err := s.lockKey(ctx, key)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	if _, err := s.readEntry(path); errors.Is(err, ErrDecode) {
+		s.dropCorrupt(key, path, err)
+	}
+}
+
+// dropCorrupt removes key's entry file at path, which failed to decode
+// with cause, and any value file beside it, releasing its charge so the key
+// can be set again. Disk faults or a crash in the middle of a SetStream can
+// leave such a file behind, as can writing to the directory with another
+// codec or encryption key. The caller holds key's lock for writing.
+func (s *DiskStore) dropCorrupt(key, path string, cause error) {
+	s.logger.Warn("removing corrupt disk entry", "key", key, "path", path, "err", cause)
+	s.fs.Remove(path + streamSuffix)
+	s.forget(key)
+}
+
+// forget removes key's files and drops it from the index.
+func (s *DiskStore) forget(key string) {
+	s.fs.Remove(s.path(key))
+	s.dropStream(key)
+	s.unindex(key)
+}
+
+// unindex drops key from the index, releasing the bytes it was charged.
+func (s *DiskStore) unindex(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage -= s.sizes[key]
+	delete(s.sizes, key)
+}
+
+// dropStream removes key's value file if it was written by SetStream.
+func (s *DiskStore) dropStream(key string) {
+	s.mu.Lock()
+	_, ok := s.streamed[key]
+	delete(s.streamed, key)
+	s.mu.Unlock()
+	if ok {
+		s.fs.Remove(s.path(key) + streamSuffix)
+	}
+}
+
+// SetStream stores entry with the first entry.Size bytes read from r as its
+// value, copying them to a file of their own as they are read instead of
+// holding them in memory. Streamed values aren't compressed, and a store
+// with an encryption key refuses them with errors.ErrUnsupported.
+func (s *DiskStore) SetStream(ctx context.Context, entry *CacheEntry, r io.Reader) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.aead != nil {
+		return &CacheError{Op: "set", Tier: TierDisk, Key: entry.Key, Err: errors.ErrUnsupported}
+	}
+
+	unlock, err := s.lockKey(ctx, entry.Key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	stored := *entry
+	stored.Value = nil
+	stored.Compressed = false
+	stored.Streamed = true
+	data, err := s.encodeEntry(&stored)
+	if err != nil {
+		return err
+	}
+	path := s.path(entry.Key)
+	if err := s.fs.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if _, err := s.charge(entry.Key, entry.Size); err != nil {
+		return err
+	}
+	// From here on key's previous value may be overwritten, so a failure
+	// forgets the key.
+	s.mu.Lock()
+	s.streamed[entry.Key] = struct{}{}
+	s.mu.Unlock()
+	if err := s.writeStream(path+streamSuffix, r, entry.Size); err != nil {
+		s.forget(entry.Key)
+		return err
+	}
+	// The value file has already replaced the old one, so the entry
+	// can't be restored if its own file isn't written.
+	return s.writeFile(path, data, func() { s.forget(entry.Key) })
+}
+
+// writeStream copies exactly size bytes from r to the file at path.
+func (s *DiskStore) writeStream(path string, r io.Reader, size int) error {
+	f, err := s.fs.Create(path)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, int64(size)))
+	if err == nil && n < int64(size) {
+		err = io.ErrUnexpectedEOF
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// GetStream returns the entry for key without its value, and a reader for
+// the value. A value written with SetStream is read from its file as the
+// caller reads; the caller must close the reader.
+func (s *DiskStore) GetStream(ctx context.Context, key string) (*CacheEntry, io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	unlock, err := s.rlockKey(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := s.path(key)
+	entry, err := s.decodeEntry(path)
+	if err == nil && entry.Streamed {
+		var f io.ReadCloser
+		if f, err = s.fs.Open(path + streamSuffix); err == nil {
+			unlock()
+			entry.Streamed = false
+			return entry, f, nil
+		}
+	}
+	if err == nil {
+		err = s.loadValue(path, entry)
+	}
+	unlock()
+
+	if errors.Is(err, ErrDecode) {
+		s.removeCorrupt(ctx, key, path)
+		err = ErrKeyNotFound
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		err = ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, nil, &CacheError{Op: "get", Tier: TierDisk, Key: key, Err: err}
+	}
+	value := entry.Value
+	entry.Value = nil
+	return entry, io.NopCloser(bytes.NewReader(value)), nil
+}
+
+func (s *DiskStore) readFile(path string) ([]byte, error) {
+	f, err := s.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
 func (s *DiskStore) readEntry(path string) (*CacheEntry, error) {
-	file, err := os.Open(path)
+	entry, err := s.decodeEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadValue(path, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// loadValue gives entry, decoded from the file at path, its value as it was
+// set: decompressed, or read from its own file if it was streamed. A
+// missing value file or a value that doesn't decompress fails with
+// ErrDecode.
+func (s *DiskStore) loadValue(path string, entry *CacheEntry) error {
+	if entry.Streamed {
+		value, err := s.readFile(path + streamSuffix)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w: missing value file", ErrDecode)
+		}
+		if err != nil {
+			return err
+		}
+		entry.Value = value
+		entry.Streamed = false
+	}
+
+	if entry.Compressed {
+		value, err := decompressValue(entry.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrDecode, err)
+		}
+		entry.Value = value
+		entry.Compressed = false
+	}
+
+	return nil
+}
+
+func (s *DiskStore) encodeEntry(entry *CacheEntry) ([]byte, error) {
+	data, err := s.codec.Encode(entry)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	if s.aead == nil {
+		return data, nil
+	}
 
-	var entry CacheEntry
-	if err := gob.NewDecoder(file).Decode(&entry); err != nil {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
 		return nil, err
 	}
+	return s.aead.Seal(nonce, nonce, data, nil), nil
+}
 
-	return &entry, nil
+// decodeEntry reads the entry stored at path as written, without
+// decompressing its value. Files that can't be decrypted with the store's
+// key or decoded with its codec, or that hold an entry for a different key,
+// fail with ErrDecode.
+func (s *DiskStore) decodeEntry(path string) (*CacheEntry, error) {
+	data, err := s.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.aead != nil {
+		nonceSize := s.aead.NonceSize()
+		if len(data) < nonceSize {
+			return nil, fmt.Errorf("%w: truncated encrypted entry", ErrDecode)
+		}
+		data, err = s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+		}
+	}
+
+	entry, err := s.codec.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	if s.path(entry.Key) != path {
+		return nil, fmt.Errorf("%w: entry does not match its file", ErrDecode)
+	}
+	return entry, nil
+}
+
+func compressValue(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressValue(value []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
 }
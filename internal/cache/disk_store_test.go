@@ -0,0 +1,836 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskStoreCompression(t *testing.T) {
+	ctx := context.Background()
+	value := []byte(strings.Repeat("highly compressible text ", 200))
+
+	plain, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	compressed, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to create compressed disk store: %v", err)
+	}
+
+	for _, store := range []*DiskStore{plain, compressed} {
+		if err := store.Set(ctx, &CacheEntry{Key: "key1", Value: value, Size: len(value)}); err != nil {
+			t.Fatalf("Failed to set key1: %v", err)
+		}
+		entry, err := store.Get(ctx, "key1")
+		if err != nil {
+			t.Fatalf("Failed to get key1: %v", err)
+		}
+		if string(entry.Value) != string(value) {
+			t.Errorf("Unexpected value for key1 (compress=%v)", store.compress)
+		}
+	}
+
+	plainInfo, err := os.Stat(plain.path("key1"))
+	if err != nil {
+		t.Fatalf("Failed to stat plain file: %v", err)
+	}
+	compressedInfo, err := os.Stat(compressed.path("key1"))
+	if err != nil {
+		t.Fatalf("Failed to stat compressed file: %v", err)
+	}
+	if compressedInfo.Size() >= plainInfo.Size() {
+		t.Errorf("Expected compressed file to be smaller. Got %d, plain %d", compressedInfo.Size(), plainInfo.Size())
+	}
+	if compressed.GetUsage() >= plain.GetUsage() {
+		t.Errorf("Expected compressed usage to be smaller. Got %d, plain %d", compressed.GetUsage(), plain.GetUsage())
+	}
+}
+
+func TestDiskStoreCancelledContext(t *testing.T) {
+	store, err := NewDiskStore(1000)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(context.Background(), &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.Get(ctx, "key1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Get, got %v", err)
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value2"), Size: 6}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Set, got %v", err)
+	}
+	if err := store.Delete(ctx, "key1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Delete, got %v", err)
+	}
+	if err := store.Clear(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Clear, got %v", err)
+	}
+	if entries := store.GetAll(ctx); len(entries) != 0 {
+		t.Errorf("Expected GetAll to stop on cancelled context, got %d entries", len(entries))
+	}
+
+	if _, err := store.Get(context.Background(), "key1"); err != nil {
+		t.Errorf("Expected key1 to be untouched by cancelled operations, got error: %v", err)
+	}
+}
+
+func TestDiskStoreUsageAccounting(t *testing.T) {
+	ctx := context.Background()
+
+	for _, compress := range []bool{false, true} {
+		store, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{Compress: compress})
+		if err != nil {
+			t.Fatalf("Failed to create disk store: %v", err)
+		}
+
+		for i := 0; i < 50; i++ {
+			value := []byte(strings.Repeat("v", i+1))
+			key := fmt.Sprintf("key%d", i)
+			if err := store.Set(ctx, &CacheEntry{Key: key, Value: value, Size: len(value)}); err != nil {
+				t.Fatalf("Failed to set %s: %v", key, err)
+			}
+		}
+		// Overwriting must not double-count.
+		store.Set(ctx, &CacheEntry{Key: "key0", Value: []byte("overwritten"), Size: 11})
+
+		for i := 0; i < 50; i++ {
+			if err := store.Delete(ctx, fmt.Sprintf("key%d", i)); err != nil {
+				t.Fatalf("Failed to delete key%d: %v", i, err)
+			}
+		}
+
+		if usage := store.GetUsage(); usage != 0 {
+			t.Errorf("Expected usage 0 after deleting everything (compress=%v), got %d", compress, usage)
+		}
+	}
+}
+
+func TestDiskStoreKeySanitization(t *testing.T) {
+	store, err := NewDiskStore(1 << 20)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	ctx := context.Background()
+
+	keys := []string{"a/b", "../etc/passwd", strings.Repeat("k", 1000)}
+	for _, key := range keys {
+		if err := store.Set(ctx, &CacheEntry{Key: key, Value: []byte("value"), Size: 5}); err != nil {
+			t.Fatalf("Failed to set %q: %v", key, err)
+		}
+		entry, err := store.Get(ctx, key)
+		if err != nil || string(entry.Value) != "value" {
+			t.Errorf("Failed to get %q. Error: %v", key, err)
+		}
+	}
+
+	var files []string
+	store.walk(func(path string) bool {
+		files = append(files, path)
+		return true
+	})
+	if len(files) != len(keys) {
+		t.Errorf("Expected %d files inside the store dir, got %d", len(keys), len(files))
+	}
+	for _, path := range files {
+		if rel, err := filepath.Rel(store.dir, path); err != nil || strings.HasPrefix(rel, "..") {
+			t.Errorf("File %q is outside the store dir", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(store.dir), "etc")); err == nil {
+		t.Error("Key escaped the store directory")
+	}
+
+	got := map[string]bool{}
+	for _, key := range store.Keys(ctx) {
+		got[key] = true
+	}
+	for _, key := range keys {
+		if !got[key] {
+			t.Errorf("Expected Keys to return original key %q", key)
+		}
+	}
+
+	for _, key := range keys {
+		store.Delete(ctx, key)
+	}
+	if usage := store.GetUsage(); usage != 0 {
+		t.Errorf("Expected usage 0 after deleting sanitized keys, got %d", usage)
+	}
+}
+
+func TestDiskStoreIncrement(t *testing.T) {
+	store, err := NewDiskStore(1 << 20)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if _, err := store.Increment(ctx, "counter", 1); err != nil {
+					t.Errorf("Increment failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	entry, err := store.Get(ctx, "counter")
+	if err != nil {
+		t.Fatalf("Failed to get counter: %v", err)
+	}
+	if string(entry.Value) != "200" {
+		t.Errorf("Expected 200 after concurrent increments, got %s", entry.Value)
+	}
+	if usage := store.GetUsage(); usage != 3 {
+		t.Errorf("Expected usage 3, got %d", usage)
+	}
+}
+
+func TestDiskStoreRecalculate(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDiskStore(1000)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+	store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value22"), Size: 7})
+	if err := store.SetStream(ctx, &CacheEntry{Key: "blob", Size: 10}, strings.NewReader("0123456789")); err != nil {
+		t.Fatalf("Failed to stream blob: %v", err)
+	}
+	if usage := store.GetUsage(); usage != 23 {
+		t.Fatalf("Expected usage 23, got %d", usage)
+	}
+
+	store.mu.Lock()
+	store.usage = 500
+	store.mu.Unlock()
+	if err := store.Recalculate(ctx); err != nil {
+		t.Fatalf("Recalculate failed: %v", err)
+	}
+	if usage := store.GetUsage(); usage != 23 {
+		t.Errorf("Expected Recalculate to restore usage 23, got %d", usage)
+	}
+
+	// Files removed behind the store's back are dropped from the index,
+	// and the remaining entries are left intact.
+	os.Remove(store.path("key2"))
+	os.Remove(store.path("blob") + streamSuffix)
+	if err := store.Recalculate(ctx); err != nil {
+		t.Fatalf("Recalculate failed: %v", err)
+	}
+	if usage, n := store.GetUsage(), store.Len(); usage != 6 || n != 1 {
+		t.Errorf("Expected usage 6 over 1 entry, got %d over %d", usage, n)
+	}
+	if entry, err := store.Get(ctx, "key1"); err != nil || string(entry.Value) != "value1" {
+		t.Errorf("Expected key1 to survive, got %v, %v", entry, err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.Recalculate(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDiskStoreDeleteMissingFile(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewDiskStore(1000)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1")})
+	store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value22")})
+
+	os.Remove(store.path("key1"))
+	if err := store.Delete(ctx, "key1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if usage, n := store.GetUsage(), store.Len(); usage != 7 || n != 1 {
+		t.Errorf("Expected usage 7 over 1 entry after deleting a missing file, got %d over %d", usage, n)
+	}
+	if err := store.Delete(ctx, "never-set"); err != nil {
+		t.Errorf("Expected deleting an unknown key to succeed, got %v", err)
+	}
+}
+
+func TestDiskStoreClearKeepsForeignFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewDiskStoreWithDir(dir, 1000)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1")})
+	if err := store.SetStream(ctx, &CacheEntry{Key: "blob", Size: 4}, strings.NewReader("blob")); err != nil {
+		t.Fatalf("Failed to stream blob: %v", err)
+	}
+	foreign := []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(filepath.Dir(store.path("key1")), "notes.txt"),
+	}
+	for _, path := range foreign {
+		if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	if err := store.Clear(ctx); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if store.Len() != 0 || store.GetUsage() != 0 {
+		t.Errorf("Expected an empty store, got %d entries using %d", store.Len(), store.GetUsage())
+	}
+	for _, path := range []string{store.path("key1"), store.path("blob"), store.path("blob") + streamSuffix} {
+		if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range foreign {
+		if data, err := os.ReadFile(path); err != nil || string(data) != "keep" {
+			t.Errorf("Expected Clear to leave %s alone, got %q, %v", path, data, err)
+		}
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("again")}); err != nil {
+		t.Errorf("Set after Clear failed: %v", err)
+	}
+}
+
+func TestDiskStoreObject(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{Dir: dir, Compress: true})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	point := objectPoint{X: 3, Y: 4, Label: "registered"}
+	if err := store.Set(ctx, &CacheEntry{Key: "point", Object: point, Size: 30}); err != nil {
+		t.Fatalf("Failed to set an object: %v", err)
+	}
+
+	reopened, err := NewDiskStoreWithDir(dir, 1000)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk store: %v", err)
+	}
+	entry, err := reopened.Get(ctx, "point")
+	if err != nil || entry.Object != point {
+		t.Fatalf("Expected %+v back, got %+v, %v", point, entry, err)
+	}
+	if usage := reopened.GetUsage(); usage != 30 {
+		t.Errorf("Expected the object to be charged 30 bytes, got %d", usage)
+	}
+
+	type unregistered struct{ A int }
+	if err := store.Set(ctx, &CacheEntry{Key: "other", Object: unregistered{1}, Size: 8}); err == nil {
+		t.Error("Expected an unregistered type to fail to encode")
+	}
+
+	jsonStore, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{Codec: JSONEntryCodec{}})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	if err := jsonStore.Set(ctx, &CacheEntry{Key: "point", Object: point, Size: 30}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected the JSON codec to refuse objects, got %v", err)
+	}
+}
+
+// recordingLogger keeps the messages logged at Warn.
+type recordingLogger struct {
+	nopLogger
+	mu    sync.Mutex
+	warns []string
+}
+
+func (l *recordingLogger) Warn(msg string, _ ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.warns = append(l.warns, msg)
+}
+
+func TestDiskStoreCorruptFile(t *testing.T) {
+	ctx := context.Background()
+	logger := &recordingLogger{}
+	store, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{Compress: true, Logger: logger})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "good", Value: []byte("value"), Size: 5})
+	store.Set(ctx, &CacheEntry{Key: "bad", Value: []byte("value"), Size: 5})
+	usage := store.GetUsage()
+
+	// Garbage in place of bad's file, as a crash mid-write could leave.
+	path := store.path("bad")
+	if err := os.WriteFile(path, []byte("\x07garbage"), 0644); err != nil {
+		t.Fatalf("Failed to corrupt the file: %v", err)
+	}
+	if _, err := store.Get(ctx, "bad"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound for a corrupt file, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected the corrupt file to be removed")
+	}
+	if got := store.GetUsage(); got >= usage || store.Len() != 1 {
+		t.Errorf("Expected bad's charge to be released, got usage %d (was %d) over %d entries", got, usage, store.Len())
+	}
+	if len(logger.warns) != 1 {
+		t.Errorf("Expected the corruption to be logged once, got %v", logger.warns)
+	}
+
+	// The key can be populated again.
+	if err := store.Set(ctx, &CacheEntry{Key: "bad", Value: []byte("fresh"), Size: 5}); err != nil {
+		t.Fatalf("Failed to set bad again: %v", err)
+	}
+	if entry, err := store.Get(ctx, "bad"); err != nil || string(entry.Value) != "fresh" {
+		t.Errorf("Expected fresh, got %v, %v", entry, err)
+	}
+	if entry, err := store.Get(ctx, "good"); err != nil || string(entry.Value) != "value" {
+		t.Errorf("Expected good to be untouched, got %v, %v", entry, err)
+	}
+
+	// A reader that saw the corrupt file leaves it if a Set has replaced
+	// it by the time it holds the write lock.
+	store.removeCorrupt(ctx, "bad", store.path("bad"))
+	if entry, err := store.Get(ctx, "bad"); err != nil || string(entry.Value) != "fresh" {
+		t.Errorf("Expected the replaced file to be kept, got %v, %v", entry, err)
+	}
+	if len(logger.warns) != 1 {
+		t.Errorf("Expected no further corruption to be logged, got %v", logger.warns)
+	}
+}
+
+func TestDiskStoreWithDirRecovers(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	store, err := NewDiskStoreWithDir(dir, 1000)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+	store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value22"), Size: 7})
+	store.Set(ctx, &CacheEntry{Key: "expired", Value: []byte("old"), Size: 3, ExpiresAt: time.Now().Add(-time.Second)})
+	os.WriteFile(filepath.Join(dir, "stray"), []byte("not an entry"), 0644)
+
+	reopened, err := NewDiskStoreWithDir(dir, 1000)
+	if err != nil {
+		t.Fatalf("Failed to reopen disk store: %v", err)
+	}
+	if usage := reopened.GetUsage(); usage != 13 {
+		t.Errorf("Expected recovered usage 13, got %d", usage)
+	}
+	keys := reopened.Keys(ctx)
+	sort.Strings(keys)
+	if fmt.Sprint(keys) != "[key1 key2]" {
+		t.Errorf("Expected recovered keys [key1 key2], got %v", keys)
+	}
+	entry, err := reopened.Get(ctx, "key1")
+	if err != nil || string(entry.Value) != "value1" {
+		t.Errorf("Expected key1 to be recovered, got %v, %v", entry, err)
+	}
+	if _, err := os.Stat(reopened.path("expired")); !os.IsNotExist(err) {
+		t.Error("Expected expired entry to be removed on load")
+	}
+}
+
+func TestDiskStoreEncryption(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte("k"), 32)
+
+	store, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{Dir: dir, EncryptionKey: key})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	secret := []byte("top secret value")
+	if err := store.Set(ctx, &CacheEntry{Key: "key1", Value: secret, Size: len(secret)}); err != nil {
+		t.Fatalf("Failed to set: %v", err)
+	}
+
+	raw, err := os.ReadFile(store.path("key1"))
+	if err != nil {
+		t.Fatalf("Failed to read entry file: %v", err)
+	}
+	if bytes.Contains(raw, secret) {
+		t.Error("Expected entry file not to contain the plaintext value")
+	}
+
+	entry, err := store.Get(ctx, "key1")
+	if err != nil || !bytes.Equal(entry.Value, secret) {
+		t.Fatalf("Expected to read back the value with the key, got %v, %v", entry, err)
+	}
+
+	for name, opts := range map[string]DiskStoreOptions{
+		"wrong key": {Dir: dir, EncryptionKey: bytes.Repeat([]byte("x"), 32)},
+		"no key":    {Dir: dir},
+	} {
+		other, err := NewDiskStoreWithOptions(1<<20, opts)
+		if err != nil {
+			t.Fatalf("Failed to create disk store: %v", err)
+		}
+		if _, err := other.decodeEntry(store.path("key1")); !errors.Is(err, ErrDecode) {
+			t.Errorf("Expected ErrDecode reading with %s, got %v", name, err)
+		}
+	}
+
+	if _, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{EncryptionKey: []byte("short")}); err == nil {
+		t.Error("Expected an error for a key that isn't 32 bytes")
+	}
+}
+
+func TestDiskStoreEntryCodec(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	jsonStore, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{Dir: dir, Codec: JSONEntryCodec{}})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	jsonStore.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+
+	raw, _ := os.ReadFile(jsonStore.path("key1"))
+	if !bytes.HasPrefix(raw, []byte("{")) {
+		t.Errorf("Expected a JSON entry file, got %q", raw)
+	}
+	entry, err := jsonStore.Get(ctx, "key1")
+	if err != nil || string(entry.Value) != "value1" {
+		t.Fatalf("Expected to read back with the same codec, got %v, %v", entry, err)
+	}
+
+	// Reading the JSON file with gob, and a gob file with JSON, misses
+	// cleanly and removes the unreadable file.
+	gobStore, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	if len(gobStore.Keys(ctx)) != 0 {
+		t.Error("Expected entries in another format not to be recovered")
+	}
+	if _, err := gobStore.Get(ctx, "key1"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound with a mismatched codec, got %v", err)
+	}
+	if _, err := os.Stat(jsonStore.path("key1")); !os.IsNotExist(err) {
+		t.Error("Expected the undecodable file to be removed")
+	}
+
+	gobStore.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value2"), Size: 6})
+	if _, err := jsonStore.Get(ctx, "key2"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected ErrKeyNotFound reading gob with JSON, got %v", err)
+	}
+}
+
+func TestDiskStoreSharding(t *testing.T) {
+	store, err := NewDiskStore(1 << 20)
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	ctx := context.Background()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	shards, err := os.ReadDir(store.dir)
+	if err != nil {
+		t.Fatalf("Failed to read disk store dir: %v", err)
+	}
+	maxPerShard := 0
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			t.Errorf("Unexpected file %q at the top of the store dir", shard.Name())
+			continue
+		}
+		files, _ := os.ReadDir(filepath.Join(store.dir, shard.Name()))
+		maxPerShard = max(maxPerShard, len(files))
+	}
+	if len(shards) < 200 {
+		t.Errorf("Expected keys to spread over most of the 256 shards, got %d", len(shards))
+	}
+	if maxPerShard > 20 {
+		t.Errorf("Expected small shards, got one with %d files", maxPerShard)
+	}
+
+	if got := len(store.GetAll(ctx)); got != n {
+		t.Errorf("Expected GetAll to find %d entries, got %d", n, got)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := store.Get(ctx, fmt.Sprintf("key%d", i)); err != nil {
+			t.Fatalf("Failed to get key%d: %v", i, err)
+		}
+	}
+
+	store.Clear(ctx)
+	if got := len(store.GetAll(ctx)); got != 0 {
+		t.Errorf("Expected Clear to remove every shard, got %d entries", got)
+	}
+}
+
+func TestDiskStoreMaxFiles(t *testing.T) {
+	store, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	ctx := context.Background()
+
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("v"), Size: 1})
+	store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("v"), Size: 1})
+	if err := store.Set(ctx, &CacheEntry{Key: "key3", Value: []byte("v"), Size: 1}); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("Expected ErrInsufficientCapacity at the file limit, got %v", err)
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("vv"), Size: 2}); err != nil {
+		t.Errorf("Expected overwrite at the file limit to succeed, got %v", err)
+	}
+}
+
+// faultyFS fails selected operations on the host filesystem.
+type faultyFS struct {
+	osFS
+	createErr error
+	writeErr  error
+	openErr   error
+	renameErr error
+}
+
+func (f *faultyFS) Create(name string) (io.WriteCloser, error) {
+	if f.createErr != nil {
+		return nil, f.createErr
+	}
+	w, err := f.osFS.Create(name)
+	if err != nil || f.writeErr == nil {
+		return w, err
+	}
+	return failingWriter{w, f.writeErr}, nil
+}
+
+func (f *faultyFS) Rename(oldpath, newpath string) error {
+	if f.renameErr != nil {
+		return f.renameErr
+	}
+	return f.osFS.Rename(oldpath, newpath)
+}
+
+func (f *faultyFS) Open(name string) (io.ReadCloser, error) {
+	if f.openErr != nil {
+		return nil, f.openErr
+	}
+	return f.osFS.Open(name)
+}
+
+type failingWriter struct {
+	io.WriteCloser
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+func TestDiskStoreFileSystemErrors(t *testing.T) {
+	ctx := context.Background()
+	fsys := &faultyFS{}
+	store, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{FileSystem: fsys})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "kept", Value: []byte("value")})
+	store.Set(ctx, &CacheEntry{Key: "rewritten", Value: []byte("value")})
+
+	diskFull := errors.New("no space left on device")
+	fsys.createErr = diskFull
+	if err := store.Set(ctx, &CacheEntry{Key: "new", Value: []byte("value")}); !errors.Is(err, diskFull) {
+		t.Errorf("Expected Set to return the Create error, got %v", err)
+	}
+	if store.GetUsage() != 10 || store.Len() != 2 {
+		t.Errorf("Expected a failed Create to leave usage alone, got %d bytes in %d entries", store.GetUsage(), store.Len())
+	}
+
+	// A write failing partway leaves the previous entry in place, with
+	// no partial file beside it.
+	fsys.createErr, fsys.writeErr = nil, diskFull
+	if err := store.Set(ctx, &CacheEntry{Key: "rewritten", Value: []byte("longer value")}); !errors.Is(err, diskFull) {
+		t.Errorf("Expected Set to return the write error, got %v", err)
+	}
+	fsys.writeErr = nil
+	if store.GetUsage() != 10 || store.Len() != 2 {
+		t.Errorf("Expected a failed write to leave usage alone, got %d bytes in %d entries", store.GetUsage(), store.Len())
+	}
+	if entry, err := store.Get(ctx, "rewritten"); err != nil || string(entry.Value) != "value" {
+		t.Errorf("Expected the previous entry to be untouched, got %v, %v", entry, err)
+	}
+	if _, err := os.Stat(store.path("rewritten") + tempSuffix); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be removed")
+	}
+
+	fsys.renameErr = fs.ErrPermission
+	if err := store.Set(ctx, &CacheEntry{Key: "rewritten", Value: []byte("longer value")}); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected Set to return the rename error, got %v", err)
+	}
+	fsys.renameErr = nil
+	if entry, err := store.Get(ctx, "rewritten"); err != nil || string(entry.Value) != "value" {
+		t.Errorf("Expected the previous entry to survive a failed rename, got %v, %v", entry, err)
+	}
+	if _, err := os.Stat(store.path("rewritten") + tempSuffix); !os.IsNotExist(err) {
+		t.Error("Expected the unrenamed file to be removed")
+	}
+
+	denied := fs.ErrPermission
+	fsys.openErr = denied
+	_, err = store.Get(ctx, "kept")
+	var cacheErr *CacheError
+	if !errors.Is(err, denied) || !errors.As(err, &cacheErr) || cacheErr.Tier != TierDisk {
+		t.Errorf("Expected Get to return the Open error from the disk tier, got %v", err)
+	}
+	fsys.openErr = nil
+	if entry, err := store.Get(ctx, "kept"); err != nil || string(entry.Value) != "value" {
+		t.Errorf("Expected kept to survive the failures, got %v, %v", entry, err)
+	}
+}
+
+func TestDiskStorePing(t *testing.T) {
+	ctx := context.Background()
+	fsys := &faultyFS{}
+	store, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{FileSystem: fsys})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	store.Set(ctx, &CacheEntry{Key: "key", Value: []byte("value")})
+
+	if err := store.Ping(ctx); err != nil {
+		t.Errorf("Expected a writable directory to ping, got %v", err)
+	}
+	if keys := store.Keys(ctx); len(keys) != 1 {
+		t.Errorf("Expected Ping to leave only the entry behind, got %v", keys)
+	}
+
+	fsys.createErr = fs.ErrPermission
+	if err := store.Ping(ctx); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected an unwritable directory to fail Ping, got %v", err)
+	}
+}
+
+// slowFS delays each Create on the host filesystem and records the most
+// that were in flight at once.
+type slowFS struct {
+	osFS
+	delay    time.Duration
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (f *slowFS) Create(name string) (io.WriteCloser, error) {
+	f.mu.Lock()
+	f.inFlight++
+	f.peak = max(f.peak, f.inFlight)
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.mu.Unlock()
+	}()
+	time.Sleep(f.delay)
+	return f.osFS.Create(name)
+}
+
+func TestDiskStoreMaxConcurrency(t *testing.T) {
+	for _, limit := range []int{1, 4} {
+		fsys := &slowFS{delay: 5 * time.Millisecond}
+		store, err := NewDiskStoreWithOptions(1<<20, DiskStoreOptions{FileSystem: fsys, MaxConcurrency: limit})
+		if err != nil {
+			t.Fatalf("Failed to create disk store: %v", err)
+		}
+
+		ctx := context.Background()
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				if err := store.Set(ctx, &CacheEntry{Key: key, Value: []byte("value")}); err != nil {
+					t.Errorf("Set(%q) failed: %v", key, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if fsys.peak > limit {
+			t.Errorf("Expected at most %d writes in flight, got %d", limit, fsys.peak)
+		}
+		if limit > 1 && fsys.peak < 2 {
+			t.Errorf("Expected writes to different keys to run in parallel with a limit of %d, got %d at once", limit, fsys.peak)
+		}
+		if store.Len() != 16 || store.GetUsage() != 16*len("value") {
+			t.Errorf("Expected 16 entries charged %d bytes, got %d entries and %d bytes", 16*len("value"), store.Len(), store.GetUsage())
+		}
+	}
+
+	store, err := NewDiskStoreWithOptions(1000, DiskStoreOptions{MaxConcurrency: 1})
+	if err != nil {
+		t.Fatalf("Failed to create disk store: %v", err)
+	}
+	unlock, err := store.lockKey(context.Background(), "busy")
+	if err != nil {
+		t.Fatalf("lockKey failed: %v", err)
+	}
+	defer unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := store.Set(ctx, &CacheEntry{Key: "other", Value: []byte("v")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Set to give up waiting for a slot with its context, got %v", err)
+	}
+}
+
+func BenchmarkDiskStoreParallel(b *testing.B) {
+	for _, limit := range []int{0, 1, 4, 16} {
+		b.Run(fmt.Sprintf("MaxConcurrency=%d", limit), func(b *testing.B) {
+			store, err := NewDiskStoreWithOptions(1<<30, DiskStoreOptions{MaxConcurrency: limit})
+			if err != nil {
+				b.Fatalf("Failed to create disk store: %v", err)
+			}
+			ctx := context.Background()
+			value := bytes.Repeat([]byte("v"), 4096)
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%d", i)
+				store.Set(ctx, &CacheEntry{Key: keys[i], Value: value})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						store.Set(ctx, &CacheEntry{Key: key, Value: value})
+					} else {
+						store.Get(ctx, key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// EntryCodec serializes CacheEntry values for the disk tier. Switching
+// codecs changes the on-disk format, so entries written with another codec
+// fail to decode rather than being misread.
+type EntryCodec interface {
+	Encode(entry *CacheEntry) ([]byte, error)
+	Decode(data []byte) (*CacheEntry, error)
+}
+
+// GobEntryCodec is the default EntryCodec.
+type GobEntryCodec struct{}
+
+func (GobEntryCodec) Encode(entry *CacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobEntryCodec) Decode(data []byte) (*CacheEntry, error) {
+	var entry CacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// JSONEntryCodec stores entries as JSON, which other languages can read.
+type JSONEntryCodec struct{}
+
+// Encode refuses Object entries with errors.ErrUnsupported, since JSON
+// can't restore their types.
+func (JSONEntryCodec) Encode(entry *CacheEntry) ([]byte, error) {
+	if entry.Object != nil {
+		return nil, fmt.Errorf("json entry codec can't store objects: %w", errors.ErrUnsupported)
+	}
+	return json.Marshal(entry)
+}
+
+func (JSONEntryCodec) Decode(data []byte) (*CacheEntry, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	var entry CacheEntry
+	if err := dec.Decode(&entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
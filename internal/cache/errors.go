@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrKeyNotFound          = errors.New("key not found")
+	ErrInsufficientCapacity = errors.New("insufficient capacity")
+	ErrEntryTooLarge        = errors.New("entry too large")
+	ErrNotInteger           = errors.New("value is not an integer or out of range")
+	ErrDecode               = errors.New("decode failed")
+	ErrCircuitOpen          = errors.New("remote circuit breaker open")
+	ErrVersionMismatch      = errors.New("version mismatch")
+	ErrUnsupportedFormat    = errors.New("unsupported export format")
+	ErrNegativeCached       = errors.New("key is cached as missing")
+	ErrPubSubUnsupported    = errors.New("remote tier does not support pub/sub")
+	ErrTTLRequired          = errors.New("entries must have a ttl")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrRateLimited          = errors.New("remote rate limit exceeded")
+)
+
+// Tier identifies one of the cache's storage tiers.
+type Tier int
+
+const (
+	TierNone Tier = iota
+	TierMemory
+	TierDisk
+	TierRemote
+)
+
+func (t Tier) String() string {
+	switch t {
+	case TierMemory:
+		return "memory"
+	case TierDisk:
+		return "disk"
+	case TierRemote:
+		return "remote"
+	default:
+		return "none"
+	}
+}
+
+// CacheError describes a failed cache operation. Use errors.Is with the
+// sentinel errors above to test for a particular cause.
+type CacheError struct {
+	Op   string // "get", "set", "delete", ...
+	Tier Tier   // TierNone when the error isn't specific to one tier
+	Key  string
+	Err  error
+}
+
+func (e *CacheError) Error() string {
+	if e.Tier == TierNone {
+		return fmt.Sprintf("cache %s %q: %v", e.Op, e.Key, e.Err)
+	}
+	return fmt.Sprintf("cache %s %q (%s): %v", e.Op, e.Key, e.Tier, e.Err)
+}
+
+func (e *CacheError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Expirer is implemented by stores that can change when an entry expires
+// without rewriting its value.
+type Expirer interface {
+	// Expire sets the expiry of the unexpired entry at key, failing with
+	// ErrKeyNotFound if there is none. Zero times mean never.
+	Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error
+}
+
+// Touch gives the entry at key a new TTL starting now, in every tier that
+// holds it, without rewriting its value: the remote tier uses Redis EXPIRE
+// and the others update the entry's metadata in place. A TTL of zero
+// removes the expiry, subject to the TTL bounds as with Set. It fails with
+// ErrKeyNotFound if no tier holds an unexpired entry for key.
+func (c *MultiTierCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	defer c.observeLatency("touch", time.Now())
+
+	expiresAt, staleUntil, ok := c.expiry(ttl, c.clock.Now())
+	if !ok {
+		return &CacheError{Op: "touch", Key: key, Err: ErrTTLRequired}
+	}
+
+	sk := c.storeKey(key)
+	if err := c.touchLocked(ctx, key, sk, expiresAt, staleUntil); err != nil {
+		return err
+	}
+	c.publishInvalidation(ctx, sk)
+	return nil
+}
+
+func (c *MultiTierCache) touchLocked(ctx context.Context, key, sk string, expiresAt, staleUntil time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := false
+	tiers := []struct {
+		store Store
+		tier  Tier
+	}{
+		{c.memoryStore, TierMemory},
+		{c.diskStore, TierDisk},
+		{c.remoteStore, TierRemote},
+	}
+	for _, t := range tiers {
+		if t.tier == TierRemote && !c.remoteMayHave(sk) {
+			continue
+		}
+		err := c.expireEntry(ctx, t.store, sk, expiresAt, staleUntil)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return &CacheError{Op: "touch", Tier: t.tier, Key: key, Err: err}
+		}
+		found = true
+	}
+	if !found {
+		return &CacheError{Op: "touch", Key: key, Err: ErrKeyNotFound}
+	}
+	return nil
+}
+
+// expireEntry sets the expiry of key in store, rewriting the entry if the
+// store isn't an Expirer.
+func (c *MultiTierCache) expireEntry(ctx context.Context, store Store, key string, expiresAt, staleUntil time.Time) error {
+	if expirer, ok := store.(Expirer); ok {
+		return expirer.Expire(ctx, key, expiresAt, staleUntil)
+	}
+	entry, err := peekEntry(ctx, store, key)
+	if err != nil {
+		return err
+	}
+	if entry.expired(c.clock.Now()) {
+		return ErrKeyNotFound
+	}
+	entry.ExpiresAt, entry.StaleUntil = expiresAt, staleUntil
+	return store.Set(ctx, entry)
+}
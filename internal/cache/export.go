@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ExportFormatVersion is the version of the format written by Export.
+// Import reads dumps written in this version or an earlier one and rejects
+// others with ErrUnsupportedFormat. Version 2 added metadata and Object
+// entries.
+const ExportFormatVersion = 2
+
+const exportMagic = "go-cache-export"
+
+// exportHeader starts every dump.
+type exportHeader struct {
+	Magic   string
+	Version int
+}
+
+// exportRecord is one entry in a dump. It holds only what a cache in
+// another environment needs, so the format doesn't change with CacheEntry.
+type exportRecord struct {
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+	Metadata  map[string]string
+	// Object and Size hold an entry stored with SetObject, and the size it
+	// was charged.
+	Object any
+	Size   int
+}
+
+// Export writes every unexpired entry in the cache's namespace to w, taking
+// each key from the highest tier that holds it. Keys are written without
+// the namespace, so a dump can be imported into a cache with a different
+// one. The remote tier is streamed rather than loaded at once when it
+// supports iteration. Object entries are gob-encoded, so their types must
+// be registered with RegisterType.
+func (c *MultiTierCache) Export(ctx context.Context, w io.Writer) error {
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(exportHeader{Magic: exportMagic, Version: ExportFormatVersion}); err != nil {
+		return err
+	}
+
+	now := c.clock.Now()
+	seen := make(map[string]struct{})
+	var encErr error
+	write := func(entry *CacheEntry) bool {
+		if _, ok := seen[entry.Key]; ok || entry.expired(now) {
+			return true
+		}
+		key, ok := c.userKey(entry.Key)
+		if !ok {
+			return true
+		}
+		seen[entry.Key] = struct{}{}
+		record := exportRecord{Key: key, Value: entry.Value, ExpiresAt: entry.ExpiresAt, Metadata: entry.Metadata}
+		if entry.Object != nil {
+			record.Object, record.Size = entry.Object, entry.Size
+		}
+		encErr = enc.Encode(record)
+		return encErr == nil
+	}
+
+	c.mu.RLock()
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		for _, entry := range store.GetAll(ctx) {
+			if !write(entry) {
+				c.mu.RUnlock()
+				return encErr
+			}
+		}
+	}
+	c.mu.RUnlock()
+
+	if it, ok := c.remoteStore.(interface {
+		Iterate(ctx context.Context, fn func(entry *CacheEntry) bool) error
+	}); ok {
+		if err := it.Iterate(ctx, write); err != nil {
+			return err
+		}
+		return encErr
+	}
+	for _, entry := range c.remoteStore.GetAll(ctx) {
+		if !write(entry) {
+			return encErr
+		}
+	}
+	return ctx.Err()
+}
+
+// Import loads a dump written by Export, storing each entry as Set would so
+// that capacities, the eviction policy and the write mode all apply.
+// Expired entries and ones too large for this cache are skipped; the rest
+// keep their remaining TTL.
+func (c *MultiTierCache) Import(ctx context.Context, r io.Reader) error {
+	dec := gob.NewDecoder(r)
+	var header exportHeader
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("%w: %v", ErrDecode, err)
+	}
+	if header.Magic != exportMagic || header.Version < 1 || header.Version > ExportFormatVersion {
+		return fmt.Errorf("%w: %q version %d", ErrUnsupportedFormat, header.Magic, header.Version)
+	}
+
+	for {
+		var record exportRecord
+		if err := dec.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return fmt.Errorf("%w: %v", ErrDecode, err)
+		}
+
+		var ttl time.Duration
+		if !record.ExpiresAt.IsZero() {
+			if ttl = record.ExpiresAt.Sub(c.clock.Now()); ttl <= 0 {
+				continue
+			}
+		}
+		opts := SetOptions{TTL: ttl, Metadata: record.Metadata}
+		if record.Object != nil {
+			opts.object, opts.objectSize = record.Object, record.Size
+		}
+		err := c.set(ctx, record.Key, record.Value, opts, nil)
+		if err != nil && !errors.Is(err, ErrEntryTooLarge) {
+			return err
+		}
+	}
+}
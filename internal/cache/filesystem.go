@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// FileSystem is the set of file operations DiskStore needs. It defaults to
+// the host filesystem; tests can supply one that fails on demand.
+type FileSystem interface {
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates name for writing.
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	// Rename replaces newpath with oldpath, atomically where the
+	// filesystem allows.
+	Rename(oldpath, newpath string) error
+	RemoveAll(path string) error
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	MkdirAll(path string, perm fs.FileMode) error
+	MkdirTemp(dir, pattern string) (string, error)
+}
+
+// osFS implements FileSystem with the os package.
+type osFS struct{}
+
+func (osFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (osFS) Create(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Rename(oldpath, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+func (osFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) MkdirAll(path string, perm fs.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func (osFS) MkdirTemp(dir, pattern string) (string, error) {
+	return os.MkdirTemp(dir, pattern)
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// Pinger is implemented by stores that can check they are usable without
+// touching any entry.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthCheck checks each tier and returns the result keyed by tier name:
+// "memory", "disk" and "remote". A nil error means the tier is healthy;
+// the disk tier must be able to write to its directory and the remote
+// tier must answer a PING. Tiers whose store isn't a Pinger, such as the
+// memory tier, are reported healthy. It is meant for readiness probes.
+func (c *MultiTierCache) HealthCheck(ctx context.Context) map[string]error {
+	health := make(map[string]error, 3)
+	for tier, store := range map[Tier]Store{
+		TierMemory: c.memoryStore,
+		TierDisk:   c.diskStore,
+		TierRemote: c.remoteStore,
+	} {
+		var err error
+		if pinger, ok := store.(Pinger); ok {
+			if pingErr := pinger.Ping(ctx); pingErr != nil {
+				err = &CacheError{Op: "ping", Tier: tier, Err: pingErr}
+			}
+		}
+		health[tier.String()] = err
+	}
+	return health
+}
+
+// pingFile is written and removed by DiskStore.Ping. It sits beside the
+// shard directories, where it is never mistaken for an entry.
+const pingFile = ".ping"
+
+// Ping checks that the store's directory is writable by creating and
+// removing a file in it.
+func (s *DiskStore) Ping(ctx context.Context) error {
+	release, err := s.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := s.fs.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, pingFile)
+	f, err := s.fs.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("ping"))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := s.fs.Remove(path); err == nil {
+		err = removeErr
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+package cache
+
+import "sync"
+
+// EvictReason says why an entry left the cache.
+type EvictReason int
+
+const (
+	// EvictReasonCapacity means the entry was evicted to make room.
+	EvictReasonCapacity EvictReason = iota
+	// EvictReasonExpired means the entry's TTL elapsed.
+	EvictReasonExpired
+	// EvictReasonManual means the entry was removed by Delete.
+	EvictReasonManual
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonCapacity:
+		return "capacity"
+	case EvictReasonExpired:
+		return "expired"
+	case EvictReasonManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// EvictFunc is called when an entry is evicted, expires, or is deleted.
+type EvictFunc func(key string, entry *CacheEntry, reason EvictReason)
+
+type evictionEvent struct {
+	key    string
+	entry  *CacheEntry
+	reason EvictReason
+}
+
+// evictionEvents buffers events raised while the cache lock is held so
+// that callbacks can run after it is released and may safely re-enter the
+// cache.
+type evictionEvents struct {
+	mu     sync.Mutex
+	events []evictionEvent
+}
+
+func (c *MultiTierCache) recordEviction(entry *CacheEntry, reason EvictReason) {
+	if c.onEvict == nil {
+		return
+	}
+	key, ok := c.userKey(entry.Key)
+	if !ok {
+		return
+	}
+
+	c.evictions.mu.Lock()
+	c.evictions.events = append(c.evictions.events, evictionEvent{key: key, entry: entry, reason: reason})
+	c.evictions.mu.Unlock()
+}
+
+// dispatchEvictions runs the OnEvict callback for buffered events. Callers
+// defer it ahead of taking the cache lock.
+func (c *MultiTierCache) dispatchEvictions() {
+	if c.onEvict == nil {
+		return
+	}
+
+	c.evictions.mu.Lock()
+	events := c.evictions.events
+	c.evictions.events = nil
+	c.evictions.mu.Unlock()
+
+	for _, ev := range events {
+		c.onEvict(ev.key, ev.entry, ev.reason)
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"sync"
+)
+
+// DeletePrefix removes every key starting with prefix from all tiers and
+// returns how many were removed. The remote tier is searched with SCAN
+// MATCH rather than listing the whole keyspace.
+func (c *MultiTierCache) DeletePrefix(ctx context.Context, prefix string) (int, error) {
+	keys := c.KeysMatching(ctx, escapeGlob(prefix)+"*")
+	return c.DeleteMulti(ctx, keys)
+}
+
+// SetWithTags stores value like Set and attaches tags to key so that
+// InvalidateTag can later remove it. Tags stay attached until the key is
+// deleted or invalidated. The tag index is held in process, so it isn't
+// shared with other caches using the same remote tier.
+func (c *MultiTierCache) SetWithTags(ctx context.Context, key string, value []byte, tags ...string) error {
+	if err := c.Set(ctx, key, value); err != nil {
+		return err
+	}
+	c.tags.add(key, tags)
+	return nil
+}
+
+// InvalidateTag removes every key tagged with tag from all tiers and
+// returns how many were removed.
+func (c *MultiTierCache) InvalidateTag(ctx context.Context, tag string) (int, error) {
+	return c.DeleteMulti(ctx, c.tags.keysFor(tag))
+}
+
+// tagIndex maps tags to the keys carrying them and back, so that a key's
+// tags can be dropped when it is deleted.
+type tagIndex struct {
+	mu    sync.Mutex
+	byTag map[string]map[string]struct{}
+	byKey map[string]map[string]struct{}
+}
+
+func (t *tagIndex) add(key string, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.byTag == nil {
+		t.byTag = make(map[string]map[string]struct{})
+		t.byKey = make(map[string]map[string]struct{})
+	}
+	if t.byKey[key] == nil {
+		t.byKey[key] = make(map[string]struct{})
+	}
+	for _, tag := range tags {
+		if t.byTag[tag] == nil {
+			t.byTag[tag] = make(map[string]struct{})
+		}
+		t.byTag[tag][key] = struct{}{}
+		t.byKey[key][tag] = struct{}{}
+	}
+}
+
+func (t *tagIndex) keysFor(tag string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := make([]string, 0, len(t.byTag[tag]))
+	for key := range t.byTag[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// remove drops key from every tag it carries.
+func (t *tagIndex) remove(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tag := range t.byKey[key] {
+		delete(t.byTag[tag], key)
+		if len(t.byTag[tag]) == 0 {
+			delete(t.byTag, tag)
+		}
+	}
+	delete(t.byKey, key)
+}
+
+func (t *tagIndex) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.byTag = nil
+	t.byKey = nil
+}
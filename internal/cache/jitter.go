@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ttlJitter spreads out expiry times so that keys written together with
+// the same TTL don't all expire at once.
+type ttlJitter struct {
+	max time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newTTLJitter(max time.Duration, src rand.Source) *ttlJitter {
+	if src == nil {
+		src = rand.NewSource(time.Now().UnixNano())
+	}
+	return &ttlJitter{max: max, rand: rand.New(src)}
+}
+
+// apply offsets ttl by a random amount within ±max. A TTL is never
+// jittered down to zero or below.
+func (j *ttlJitter) apply(ttl time.Duration) time.Duration {
+	j.mu.Lock()
+	offset := time.Duration(j.rand.Int63n(2*int64(j.max)+1)) - j.max
+	j.mu.Unlock()
+
+	if jittered := ttl + offset; jittered > 0 {
+		return jittered
+	}
+	return ttl
+}
+
+// ttlBounds holds the limits set by WithMinTTL, WithMaxTTL and
+// WithRejectNoExpiry. Zero limits don't apply.
+type ttlBounds struct {
+	min, max       time.Duration
+	rejectNoExpiry bool
+}
+
+// apply clamps ttl into the bounds. A non-positive ttl means no expiry and
+// becomes max, or is rejected by returning false.
+func (b ttlBounds) apply(ttl time.Duration) (time.Duration, bool) {
+	if ttl <= 0 {
+		return b.max, !b.rejectNoExpiry
+	}
+	if b.min > 0 {
+		ttl = max(ttl, b.min)
+	}
+	if b.max > 0 {
+		ttl = min(ttl, b.max)
+	}
+	return ttl, true
+}
@@ -0,0 +1,17 @@
+package cache
+
+// Logger receives the cache's diagnostic messages. keysAndValues holds
+// alternating keys and values, such as "op", "get", "key", key, in the style
+// of log/slog, so a *slog.Logger can be used directly.
+type Logger interface {
+	Debug(msg string, keysAndValues ...any)
+	Info(msg string, keysAndValues ...any)
+	Warn(msg string, keysAndValues ...any)
+}
+
+// nopLogger discards everything. It is the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
@@ -1,85 +1,344 @@
 package cache
 
 import (
+	"container/list"
 	"context"
-	"errors"
+	"strconv"
 	"sync"
+	"time"
 )
 
-var ErrInsufficientCapacity = errors.New("insufficient capacity")
+// RecencyOrdered is implemented by stores that keep their entries ordered
+// by access, so LRU eviction can pick a victim without scanning every entry.
+type RecencyOrdered interface {
+	LeastRecentlyUsed() (*CacheEntry, bool)
+}
+
+// EntryIterator is implemented by stores that can visit their entries
+// without copying them all into a slice.
+type EntryIterator interface {
+	// ForEach calls fn for each entry until fn returns false. fn sees the
+	// stored entry and must not modify or retain it, nor call back into
+	// the store.
+	ForEach(ctx context.Context, fn func(*CacheEntry) bool) error
+}
 
+// MemoryStore keeps entries in a map ordered by recency. Entries passed to
+// Set are copied, and Get, Peek and GetAll return copies, so callers can
+// never modify what is stored.
 type MemoryStore struct {
-	mu       sync.RWMutex
-	items    map[string]*CacheEntry
+	mu    sync.RWMutex
+	items map[string]*list.Element
+	// order holds *CacheEntry values, most recently used at the front.
+	order    *list.List
 	capacity int
 	usage    int
+	// maxEntries caps the number of entries; zero means no limit.
+	maxEntries int
+	// maxEntrySize rejects entries charged more than this; zero means no
+	// limit.
+	maxEntrySize int
+	sizeFunc     SizeFunc
+	clock        Clock
+}
+
+// SizeFunc returns the number of bytes an entry is charged against a
+// store's capacity.
+type SizeFunc func(entry *CacheEntry) int
+
+// DefaultSizeFunc charges an entry for its key and value, or an Object
+// entry for the Size it was set with.
+func DefaultSizeFunc(entry *CacheEntry) int {
+	if entry.Object != nil {
+		return entry.Size
+	}
+	return len(entry.Key) + len(entry.Value)
+}
+
+// MemoryStoreOptions configures a MemoryStore created with
+// NewMemoryStoreWithOptions.
+type MemoryStoreOptions struct {
+	// MaxEntries caps the number of entries regardless of their size. Zero
+	// means no limit.
+	MaxEntries int
+	// SizeFunc computes each entry's footprint. Defaults to
+	// DefaultSizeFunc.
+	SizeFunc SizeFunc
+	// MaxEntrySize makes Set reject entries whose footprint exceeds it
+	// with ErrEntryTooLarge, so one huge value can't flush the store. Zero
+	// means no limit.
+	MaxEntrySize int
+	// Clock decides which entries have expired. Defaults to the real
+	// clock.
+	Clock Clock
 }
 
 func NewMemoryStore(capacity int) *MemoryStore {
+	return NewMemoryStoreWithOptions(capacity, MemoryStoreOptions{})
+}
+
+func NewMemoryStoreWithOptions(capacity int, opts MemoryStoreOptions) *MemoryStore {
+	sizeFunc := opts.SizeFunc
+	if sizeFunc == nil {
+		sizeFunc = DefaultSizeFunc
+	}
 	return &MemoryStore{
-		items:    make(map[string]*CacheEntry),
-		capacity: capacity,
+		items:        make(map[string]*list.Element),
+		order:        list.New(),
+		capacity:     capacity,
+		maxEntries:   opts.MaxEntries,
+		maxEntrySize: opts.MaxEntrySize,
+		sizeFunc:     sizeFunc,
+		clock:        clockOrReal(opts.Clock),
 	}
 }
 
-func (s *MemoryStore) Get(_ context.Context, key string) (*CacheEntry, error) {
+func (s *MemoryStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	return s.get(ctx, key, (*CacheEntry).Clone)
+}
+
+// GetShared is like Get, but the entry returned shares its Value and
+// Metadata with the stored entry instead of copying them, so the caller
+// must not modify them. Stored values are never modified in place, so they
+// stay valid after the key is set again.
+func (s *MemoryStore) GetShared(ctx context.Context, key string) (*CacheEntry, error) {
+	return s.get(ctx, key, (*CacheEntry).shallow)
+}
+
+func (s *MemoryStore) get(ctx context.Context, key string, copyEntry func(*CacheEntry) *CacheEntry) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.order.MoveToFront(elem)
+		return copyEntry(elem.Value.(*CacheEntry)), nil
+	}
+	return nil, &CacheError{Op: "get", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+}
+
+// Peek returns the entry for key without affecting its recency.
+func (s *MemoryStore) Peek(ctx context.Context, key string) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if entry, ok := s.items[key]; ok {
-		return entry, nil
+	if elem, ok := s.items[key]; ok {
+		return elem.Value.(*CacheEntry).Clone(), nil
+	}
+	return nil, &CacheError{Op: "get", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+}
+
+// Touch records an access to key at the given time, bumping its
+// Frequency.
+func (s *MemoryStore) Touch(ctx context.Context, key string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return &CacheError{Op: "touch", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
 	}
-	return nil, errors.New("key not found")
+	entry := elem.Value.(*CacheEntry)
+	entry.LastAccess = at
+	entry.AccessSeq = nextAccessSeq()
+	entry.Frequency++
+	return nil
 }
 
-func (s *MemoryStore) Set(_ context.Context, entry *CacheEntry) error {
+// Has reports whether key is present and unexpired without affecting its
+// recency.
+func (s *MemoryStore) Has(_ context.Context, key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem, ok := s.items[key]
+	return ok && !elem.Value.(*CacheEntry).expired(s.clock.Now())
+}
+
+func (s *MemoryStore) Set(ctx context.Context, entry *CacheEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.set(entry)
+}
 
-	newUsage := s.usage + entry.Size
-	if existing, ok := s.items[entry.Key]; ok {
-		newUsage -= existing.Size
+// set stores a copy of entry, recording the size it is charged in the
+// copy's Size. The caller's entry is left as it was.
+func (s *MemoryStore) set(entry *CacheEntry) error {
+	size := s.sizeFunc(entry)
+	if s.maxEntrySize > 0 && size > s.maxEntrySize {
+		return ErrEntryTooLarge
+	}
+	newUsage := s.usage + size
+	existing, ok := s.items[entry.Key]
+	if ok {
+		newUsage -= existing.Value.(*CacheEntry).Size
 	}
 
 	if newUsage > s.capacity {
 		return ErrInsufficientCapacity
 	}
+	if !ok && s.maxEntries > 0 && len(s.items) >= s.maxEntries {
+		return ErrInsufficientCapacity
+	}
 
-	s.items[entry.Key] = entry
+	stored := entry.Clone()
+	stored.Size = size
+	if ok {
+		existing.Value = stored
+		s.order.MoveToFront(existing)
+	} else {
+		s.items[entry.Key] = s.order.PushFront(stored)
+	}
 	s.usage = newUsage
 	return nil
 }
 
-func (s *MemoryStore) Delete(_ context.Context, key string) error {
+func (s *MemoryStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.clock.Now()
+	entry := newCounterEntry(key, 0, now)
+	if elem, ok := s.items[key]; ok && !elem.Value.(*CacheEntry).expired(now) {
+		entry = elem.Value.(*CacheEntry).Clone()
+		entry.LastAccess = now
+		entry.AccessSeq = nextAccessSeq()
+		entry.Frequency++
+		entry.Version++
+	}
+
+	n, err := incrementValue(entry.Value, delta)
+	if err != nil {
+		return 0, &CacheError{Op: "increment", Tier: TierMemory, Key: key, Err: err}
+	}
+	entry.Value = []byte(strconv.FormatInt(n, 10))
+	entry.Size = len(entry.Value)
+	if err := s.set(entry); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *MemoryStore) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+	swapped := swapEntry(elem.Value.(*CacheEntry), old, new, s.clock.Now())
+	if swapped == nil {
+		return false, nil
+	}
+	if err := s.set(swapped); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Expire sets the expiry of the unexpired entry at key in place.
+func (s *MemoryStore) Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok || elem.Value.(*CacheEntry).expired(s.clock.Now()) {
+		return &CacheError{Op: "expire", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+	}
+	entry := elem.Value.(*CacheEntry)
+	entry.ExpiresAt, entry.StaleUntil = expiresAt, staleUntil
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if entry, ok := s.items[key]; ok {
-		s.usage -= entry.Size
+	if elem, ok := s.items[key]; ok {
+		s.usage -= elem.Value.(*CacheEntry).Size
+		s.order.Remove(elem)
 		delete(s.items, key)
 	}
 	return nil
 }
 
-func (s *MemoryStore) Clear(_ context.Context) error {
+func (s *MemoryStore) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.items = make(map[string]*CacheEntry)
+	s.items = make(map[string]*list.Element)
+	s.order.Init()
 	s.usage = 0
 	return nil
 }
 
 func (s *MemoryStore) GetCapacity() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.capacity
 }
 
+// SetCapacity changes the store's capacity to n bytes. Entries that no
+// longer fit are kept until the caller evicts them.
+func (s *MemoryStore) SetCapacity(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = n
+}
+
 func (s *MemoryStore) GetUsage() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.usage
 }
 
+// Len returns the number of entries in the store.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+func (s *MemoryStore) MaxEntries() int {
+	return s.maxEntries
+}
+
 func (s *MemoryStore) Keys(_ context.Context) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -96,8 +355,36 @@ func (s *MemoryStore) GetAll(_ context.Context) []*CacheEntry {
 	defer s.mu.RUnlock()
 
 	entries := make([]*CacheEntry, 0, len(s.items))
-	for _, v := range s.items {
-		entries = append(entries, v)
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		entries = append(entries, elem.Value.(*CacheEntry).Clone())
 	}
 	return entries
 }
+
+// ForEach visits entries most recently used first.
+func (s *MemoryStore) ForEach(ctx context.Context, fn func(*CacheEntry) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+		if !fn(elem.Value.(*CacheEntry)) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) LeastRecentlyUsed() (*CacheEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elem := s.order.Back()
+	if elem == nil {
+		return nil, false
+	}
+	return elem.Value.(*CacheEntry).Clone(), true
+}
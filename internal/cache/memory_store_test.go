@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreCancelledContext(t *testing.T) {
+	store := NewMemoryStore(100)
+	store.Set(context.Background(), &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := store.Get(ctx, "key1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Get, got %v", err)
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key2", Value: []byte("value2"), Size: 6}); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Set, got %v", err)
+	}
+	if err := store.Delete(ctx, "key1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Delete, got %v", err)
+	}
+	if err := store.Clear(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from Clear, got %v", err)
+	}
+
+	if _, err := store.Get(context.Background(), "key1"); err != nil {
+		t.Errorf("Expected key1 to be untouched by cancelled operations, got error: %v", err)
+	}
+}
+
+func TestMemoryStoreRecencyOrder(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(100)
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1})
+	}
+	store.Get(ctx, "key1")
+
+	entry, ok := store.LeastRecentlyUsed()
+	if !ok || entry.Key != "key2" {
+		t.Errorf("Expected key2 to be least recently used, got %v", entry)
+	}
+
+	store.Has(ctx, "key2")
+	if entry, _ := store.LeastRecentlyUsed(); entry.Key != "key2" {
+		t.Errorf("Expected Has not to affect recency, got %s", entry.Key)
+	}
+
+	store.Delete(ctx, "key2")
+	if entry, _ := store.LeastRecentlyUsed(); entry.Key != "key3" {
+		t.Errorf("Expected key3 to be least recently used after delete, got %s", entry.Key)
+	}
+}
+
+func TestMemoryStoreMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStoreWithOptions(1000, MemoryStoreOptions{MaxEntries: 3})
+
+	for _, key := range []string{"key1", "key2", "key3"} {
+		if err := store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v"), Size: 1}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key4", Value: []byte("v"), Size: 1}); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("Expected ErrInsufficientCapacity at the entry limit, got %v", err)
+	}
+	// Overwriting doesn't add an entry.
+	if err := store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("vv"), Size: 2}); err != nil {
+		t.Errorf("Expected overwrite at the entry limit to succeed, got %v", err)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	c, err := NewCache(
+		WithMemoryCapacity(1000),
+		WithMaxEntries(10),
+		WithDiskCapacity(1000),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 50; i++ {
+		if err := c.Set(ctx, fmt.Sprintf("key%d", i), []byte("v")); err != nil {
+			t.Fatalf("Failed to set key%d: %v", i, err)
+		}
+	}
+
+	if n := len(c.memoryStore.Keys(ctx)); n != 10 {
+		t.Errorf("Expected 10 entries in memory, got %d", n)
+	}
+	// key40..key49 are charged 5 bytes of key and 1 of value each.
+	if usage := c.memoryStore.GetUsage(); usage != 60 {
+		t.Errorf("Expected memory usage 60, got %d", usage)
+	}
+	// The most recent writes stay in memory; older ones were evicted to disk.
+	if _, err := c.memoryStore.Get(ctx, "key49"); err != nil {
+		t.Error("Expected key49 to be in memory")
+	}
+	if _, err := c.diskStore.Get(ctx, "key0"); err != nil {
+		t.Errorf("Expected key0 to have been evicted to disk, got error: %v", err)
+	}
+}
+
+func TestMemoryStoreSizeFunc(t *testing.T) {
+	ctx := context.Background()
+
+	store := NewMemoryStore(1000)
+	store.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6})
+	if usage := store.GetUsage(); usage != len("key1")+len("value1") {
+		t.Errorf("Expected usage to include the key length, got %d", usage)
+	}
+
+	custom := NewMemoryStoreWithOptions(1000, MemoryStoreOptions{
+		SizeFunc: func(entry *CacheEntry) int { return 100 + len(entry.Value) },
+	})
+	custom.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("value1")})
+	custom.Set(ctx, &CacheEntry{Key: "key1", Value: []byte("v")})
+	if usage := custom.GetUsage(); usage != 101 {
+		t.Errorf("Expected custom SizeFunc to be used on overwrite, got %d", usage)
+	}
+	custom.Delete(ctx, "key1")
+	if usage := custom.GetUsage(); usage != 0 {
+		t.Errorf("Expected usage 0 after delete, got %d", usage)
+	}
+
+	// The charged size is recorded on the stored copy, not the caller's
+	// entry.
+	for _, store := range []Store{NewMemoryStore(1000), NewShardedMemoryStore(1000, 4, MemoryStoreOptions{})} {
+		entry := &CacheEntry{Key: "key1", Value: []byte("value1"), Size: 6}
+		store.Set(ctx, entry)
+		if entry.Size != 6 {
+			t.Errorf("%T: expected the caller's Size to stay 6, got %d", store, entry.Size)
+		}
+		if stored, err := store.Get(ctx, "key1"); err != nil || stored.Size != 10 {
+			t.Errorf("%T: expected the stored Size to be 10, got %v, %v", store, stored, err)
+		}
+	}
+}
+
+// scanOnlyLRU hides LRUPolicy.ChooseFromStore so eviction falls back to a
+// full GetAll scan, as it did before stores tracked recency.
+type scanOnlyLRU struct {
+	lru LRUPolicy
+}
+
+func (p *scanOnlyLRU) Choose(entries []*CacheEntry) string {
+	return p.lru.Choose(entries)
+}
+
+func BenchmarkSetUnderPressure(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		policy EvictionPolicy
+	}{
+		{"Scan", &scanOnlyLRU{}},
+		{"Ordered", &LRUPolicy{}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			c, err := NewCache(
+				WithMemoryCapacity(10000),
+				WithDiskCapacity(0),
+				WithPolicy(bc.policy),
+			)
+			if err != nil {
+				b.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			ctx := context.Background()
+			value := make([]byte, 10)
+			for i := 0; i < 1000; i++ {
+				c.Set(ctx, fmt.Sprintf("warm%d", i), value)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Set(ctx, fmt.Sprintf("key%d", i), value)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(100)
+
+	value := []byte("value")
+	store.Set(ctx, &CacheEntry{Key: "key", Value: value})
+	value[0] = 'X'
+
+	entry, _ := store.Get(ctx, "key")
+	entry.Value[0] = 'Y'
+	entry.Frequency = 100
+	peeked, _ := store.Peek(ctx, "key")
+	peeked.Value[1] = 'Z'
+	store.GetAll(ctx)[0].Value[2] = 'W'
+
+	stored, _ := store.Get(ctx, "key")
+	if string(stored.Value) != "value" {
+		t.Errorf("Expected stored value to be unchanged, got %q", stored.Value)
+	}
+	if stored.Frequency != 0 {
+		t.Errorf("Expected stored Frequency to be unchanged, got %d", stored.Frequency)
+	}
+
+	c := NewMemoryCache(100, nil)
+	defer c.Close()
+	c.Set(ctx, "key", []byte("value"))
+	got, _ := c.Get(ctx, "key")
+	got[0] = 'X'
+	if again, _ := c.Get(ctx, "key"); string(again) != "value" {
+		t.Errorf("Expected modifying a Get result not to affect the cache, got %q", again)
+	}
+	if entry, _ := c.memoryStore.Get(ctx, "key"); entry.Frequency != 3 {
+		t.Errorf("Expected Get to record hits on the stored entry, got Frequency %d", entry.Frequency)
+	}
+}
+
+func TestShardedMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewShardedMemoryStore(30, 4, MemoryStoreOptions{MaxEntries: 5})
+
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if err := store.Set(ctx, &CacheEntry{Key: key, Value: []byte("value1")}); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+	if usage := store.GetUsage(); usage != 30 {
+		t.Errorf("Expected usage 30 across shards, got %d", usage)
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key3", Value: []byte("v")}); !errors.Is(err, ErrInsufficientCapacity) {
+		t.Errorf("Expected ErrInsufficientCapacity when the store is full, got %v", err)
+	}
+	if err := store.Set(ctx, &CacheEntry{Key: "key0", Value: []byte("v")}); err != nil {
+		t.Errorf("Expected replacing an entry with a smaller one to fit, got %v", err)
+	}
+
+	store.Get(ctx, "key1")
+	store.Get(ctx, "key0")
+	if entry, ok := store.LeastRecentlyUsed(); !ok || entry.Key != "key2" {
+		t.Errorf("Expected key2 to be least recently used across shards, got %v", entry)
+	}
+
+	store.Delete(ctx, "key2")
+	if store.Len() != 2 || store.GetUsage() != 15 {
+		t.Errorf("Expected 2 entries using 15 bytes after delete, got %d using %d", store.Len(), store.GetUsage())
+	}
+	store.Clear(ctx)
+	if store.Len() != 0 || store.GetUsage() != 0 || len(store.Keys(ctx)) != 0 {
+		t.Errorf("Expected an empty store after Clear, got %d entries using %d", store.Len(), store.GetUsage())
+	}
+}
+
+func TestMemoryStoreIncrementKeepsFields(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	stores := map[string]Store{
+		"memory":  NewMemoryStore(100),
+		"sharded": NewShardedMemoryStore(100, 4, MemoryStoreOptions{}),
+	}
+	for name, store := range stores {
+		store.Set(ctx, &CacheEntry{
+			Key:        "n",
+			Value:      []byte("1"),
+			ExpiresAt:  expiresAt,
+			StaleUntil: expiresAt.Add(time.Minute),
+			Metadata:   map[string]string{"owner": "a"},
+			Priority:   3,
+		})
+		if n, err := store.(Incrementer).Increment(ctx, "n", 1); err != nil || n != 2 {
+			t.Fatalf("%s: Increment = %d, %v", name, n, err)
+		}
+		entry, err := store.Get(ctx, "n")
+		if err != nil {
+			t.Fatalf("%s: Get failed: %v", name, err)
+		}
+		if !entry.ExpiresAt.Equal(expiresAt) || !entry.StaleUntil.Equal(expiresAt.Add(time.Minute)) ||
+			entry.Metadata["owner"] != "a" || entry.Priority != 3 {
+			t.Errorf("%s: Expected Increment to keep the entry's fields, got %+v", name, entry)
+		}
+	}
+}
+
+func TestShardedMemoryCacheEvicts(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(50), WithMemoryShards(8), WithDiskCapacity(0))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := c.Set(ctx, fmt.Sprintf("key%02d", i), []byte("value")); err != nil {
+			t.Fatalf("Failed to set key%02d: %v", i, err)
+		}
+	}
+	if usage := c.MemoryStore().GetUsage(); usage > 50 {
+		t.Errorf("Expected usage within capacity, got %d", usage)
+	}
+	if _, err := c.Get(ctx, "key19"); err != nil {
+		t.Errorf("Expected the newest key to be cached, got %v", err)
+	}
+	if _, err := c.Get(ctx, "key00"); err == nil {
+		t.Error("Expected the oldest key to be evicted")
+	}
+}
+
+func BenchmarkMemoryStoreParallel(b *testing.B) {
+	for _, bc := range []struct {
+		name  string
+		store Store
+	}{
+		{"Single", NewMemoryStore(1 << 20)},
+		{"Sharded", NewShardedMemoryStore(1<<20, 16, MemoryStoreOptions{})},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ctx := context.Background()
+			keys := make([]string, 1024)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key%d", i)
+				bc.store.Set(ctx, &CacheEntry{Key: keys[i], Value: []byte("value")})
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						bc.store.Set(ctx, &CacheEntry{Key: key, Value: []byte("value")})
+					} else {
+						bc.store.Get(ctx, key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestMemoryStoreForEach(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(100)
+	for _, key := range []string{"key1", "key2", "key3"} {
+		store.Set(ctx, &CacheEntry{Key: key, Value: []byte("v")})
+	}
+
+	var visited []string
+	store.ForEach(ctx, func(entry *CacheEntry) bool {
+		visited = append(visited, entry.Key)
+		return len(visited) < 2
+	})
+	if !reflect.DeepEqual(visited, []string{"key3", "key2"}) {
+		t.Errorf("Expected ForEach to visit key3, key2 and stop, got %v", visited)
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.ForEach(ctx2, func(*CacheEntry) bool { return true }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ForEach, got %v", err)
+	}
+}
+
+// sliceOnly hides a policy's ChooseSeq so eviction copies every entry with
+// GetAll.
+type sliceOnly struct {
+	EvictionPolicy
+}
+
+func BenchmarkEvictionScan(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		policy EvictionPolicy
+	}{
+		{"GetAll", sliceOnly{&SLRUPolicy{}}},
+		{"ForEach", &SLRUPolicy{}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			c, err := NewCache(
+				WithMemoryCapacity(10000),
+				WithDiskCapacity(0),
+				WithPolicy(bc.policy),
+			)
+			if err != nil {
+				b.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			ctx := context.Background()
+			value := make([]byte, 10)
+			for i := 0; i < 1000; i++ {
+				c.Set(ctx, fmt.Sprintf("warm%d", i), value)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				c.Set(ctx, fmt.Sprintf("key%d", i), value)
+			}
+		})
+	}
+}
+
+func BenchmarkSetLargeIntoFullCache(b *testing.B) {
+	for _, batch := range []int{1, 64} {
+		b.Run(fmt.Sprintf("Batch%d", batch), func(b *testing.B) {
+			c, err := NewCache(
+				WithMemoryCapacity(20000),
+				WithDiskCapacity(0),
+				WithPolicy(&SLRUPolicy{}),
+				WithEvictionBatchSize(batch),
+			)
+			if err != nil {
+				b.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			ctx := context.Background()
+			small := make([]byte, 10)
+			large := make([]byte, 10000)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c.Clear(ctx)
+				for j := 0; j < 1000; j++ {
+					c.Set(ctx, fmt.Sprintf("small%04d", j), small)
+				}
+				b.StartTimer()
+				c.Set(ctx, "large", large)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+)
+
+// SetWithMetadata stores value with metadata, such as a content type, kept
+// alongside it in every tier. GetWithMetadata returns it.
+func (c *MultiTierCache) SetWithMetadata(ctx context.Context, key string, value []byte, metadata map[string]string) error {
+	return c.set(ctx, key, value, SetOptions{Metadata: metadata}, nil)
+}
+
+// metadataMagic starts a remote value that carries metadata. Redis only
+// holds strings, so such values are stored as the magic, the length of the
+// JSON-encoded metadata as a uvarint, the metadata and then the value.
+// Values without metadata are stored as they are, so other clients and
+// INCRBY still see them unchanged. The CAS script decodes the length to
+// compare and replace only the value.
+const metadataMagic = "\x00gcmeta\x00"
+
+// encodeRemoteValue returns what the remote tier stores for entry.
+func encodeRemoteValue(entry *CacheEntry) ([]byte, error) {
+	if entry.Object != nil {
+		return encodeRemoteObject(entry)
+	}
+	if len(entry.Metadata) == 0 {
+		return entry.Value, nil
+	}
+	// A map[string]string always marshals.
+	meta, _ := json.Marshal(entry.Metadata)
+	buf := make([]byte, 0, len(metadataMagic)+binary.MaxVarintLen64+len(meta)+len(entry.Value))
+	buf = append(buf, metadataMagic...)
+	buf = binary.AppendUvarint(buf, uint64(len(meta)))
+	buf = append(buf, meta...)
+	return append(buf, entry.Value...), nil
+}
+
+// remoteEntry returns the entry for key read back from the remote tier as
+// data, splitting off any metadata encodeRemoteValue added. Data that only
+// looks like it has metadata is returned as the value.
+func remoteEntry(key string, data []byte) *CacheEntry {
+	entry := &CacheEntry{Key: key, Value: data, Size: len(data)}
+	if rest, ok := bytes.CutPrefix(data, []byte(objectMagic)); ok {
+		if object, ok := remoteObjectEntry(key, rest); ok {
+			object.Size = len(data)
+			return object
+		}
+		return entry
+	}
+	rest, ok := bytes.CutPrefix(data, []byte(metadataMagic))
+	if !ok {
+		return entry
+	}
+	n, k := binary.Uvarint(rest)
+	if k <= 0 || n > uint64(len(rest)-k) {
+		return entry
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(rest[k:k+int(n)], &metadata); err != nil {
+		return entry
+	}
+	entry.Metadata = metadata
+	entry.Value = rest[k+int(n):]
+	entry.Size = len(entry.Value)
+	return entry
+}
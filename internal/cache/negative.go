@@ -0,0 +1,67 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SetMissing records that key is known to be absent from the backing store,
+// so that for ttl Get, Peek and GetOrLoad fail fast with ErrNegativeCached
+// instead of probing every tier or calling the loader, and Has reports
+// false. Any write to key clears it, as do Delete and Clear. A non-positive
+// ttl clears it too. Like tags, tombstones are held in process.
+func (c *MultiTierCache) SetMissing(ctx context.Context, key string, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	sk := c.storeKey(key)
+	if ttl <= 0 {
+		c.negatives.remove(sk)
+		return nil
+	}
+	c.negatives.add(sk, c.clock.Now().Add(ttl))
+	return nil
+}
+
+// negativeIndex holds the expiry of each tombstone set with SetMissing.
+type negativeIndex struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+}
+
+func (n *negativeIndex) add(key string, until time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.until == nil {
+		n.until = make(map[string]time.Time)
+	}
+	n.until[key] = until
+}
+
+// has reports whether key has an unexpired tombstone, dropping it once it
+// has expired.
+func (n *negativeIndex) has(key string, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	until, ok := n.until[key]
+	if ok && !now.Before(until) {
+		delete(n.until, key)
+		return false
+	}
+	return ok
+}
+
+func (n *negativeIndex) remove(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.until, key)
+}
+
+func (n *negativeIndex) reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.until = nil
+}
@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"context"
+)
+
+// NullStore is a Store that holds nothing. It stands in for the remote tier
+// when no Redis server is configured, and for the disk tier of a cache made
+// with NewMemoryCache.
+type NullStore struct{}
+
+func NewNullStore() *NullStore {
+	return &NullStore{}
+}
+
+func (s *NullStore) Get(_ context.Context, key string) (*CacheEntry, error) {
+	return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: ErrKeyNotFound}
+}
+
+func (s *NullStore) Has(_ context.Context, _ string) bool {
+	return false
+}
+
+func (s *NullStore) Set(_ context.Context, _ *CacheEntry) error {
+	return nil
+}
+
+func (s *NullStore) Delete(_ context.Context, _ string) error {
+	return nil
+}
+
+func (s *NullStore) Clear(_ context.Context) error {
+	return nil
+}
+
+func (s *NullStore) GetCapacity() int {
+	return 0
+}
+
+func (s *NullStore) GetUsage() int {
+	return 0
+}
+
+func (s *NullStore) Keys(_ context.Context) []string {
+	return []string{}
+}
+
+func (s *NullStore) GetAll(_ context.Context) []*CacheEntry {
+	return []*CacheEntry{}
+}
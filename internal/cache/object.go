@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+// RegisterType registers the concrete type of v, such as a struct value,
+// so that entries holding values of that type in Object can be written to
+// the disk and remote tiers. Like gob.Register, which it calls, it must be
+// called before such entries are stored, typically from an init function,
+// and panics if the type is registered twice under different names.
+func RegisterType(v any) {
+	gob.Register(v)
+}
+
+// SetObject stores v at key as an Object entry, without the caller
+// serializing it: the memory tier holds v itself, and the disk tier
+// gob-encodes it as part of the entry's file, so it is serialized once on
+// its way to disk instead of once by the caller and again by the tier. The
+// remote tier gob-encodes it as its value. v's type must be registered with
+// RegisterType. Since the cache can't measure v without encoding it, the
+// entry is charged size bytes against the memory and disk capacities.
+// Callers must not modify v afterwards, as GetObject returns it as is.
+func (c *MultiTierCache) SetObject(ctx context.Context, key string, v any, size int) error {
+	if v == nil {
+		return &CacheError{Op: "set", Key: key, Err: errors.New("nil object")}
+	}
+	return c.set(ctx, key, nil, SetOptions{object: v, objectSize: size}, nil)
+}
+
+// GetObject returns the value stored at key with SetObject. An entry
+// stored as bytes is reported with ErrDecode.
+func (c *MultiTierCache) GetObject(ctx context.Context, key string) (any, error) {
+	entry, _, err := c.get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Object == nil {
+		return nil, &CacheError{Op: "get", Key: key, Err: fmt.Errorf("%w: entry holds no object", ErrDecode)}
+	}
+	return entry.Object, nil
+}
+
+// objectMagic starts a remote value holding an Object entry: the magic
+// followed by the gob encoding of a remoteObject.
+const objectMagic = "\x00gcobj\x00"
+
+// remoteObject is what the remote tier stores for an Object entry.
+type remoteObject struct {
+	Object   any
+	Metadata map[string]string
+}
+
+func encodeRemoteObject(entry *CacheEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(objectMagic)
+	err := gob.NewEncoder(&buf).Encode(remoteObject{Object: entry.Object, Metadata: entry.Metadata})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// remoteObjectEntry decodes data, a remote value without its objectMagic,
+// into the Object entry for key, reporting false if it isn't one.
+func remoteObjectEntry(key string, data []byte) (*CacheEntry, bool) {
+	var object remoteObject
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&object); err != nil || object.Object == nil {
+		return nil, false
+	}
+	return &CacheEntry{Key: key, Object: object.Object, Metadata: object.Metadata}, true
+}
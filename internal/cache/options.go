@@ -0,0 +1,478 @@
+package cache
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultMemoryCapacity = 64 << 20
+	DefaultDiskCapacity   = 1 << 30
+)
+
+type config struct {
+	memoryCapacity      int
+	maxEntries          int
+	memoryEntryFraction float64
+	memoryShards        int
+	sizeFunc            SizeFunc
+	diskCapacity        int
+	memoryOnly          bool
+	diskDir             string
+	diskEncryptionKey   []byte
+	diskCodec           EntryCodec
+	maxDiskFiles        int
+	diskConcurrency     int
+	remote              RemoteStoreConfig
+	remoteStore         Store
+	remoteBloomKeys     int
+	remoteBloomFPR      float64
+	invalidationChannel string
+	namespace           string
+	policy              EvictionPolicy
+	janitorInterval     time.Duration
+	autoTuneInterval    time.Duration
+	autoTuneMinMemory   int
+	autoTuneMaxMemory   int
+	ttlJitter           time.Duration
+	ttlBounds           ttlBounds
+	staleWindow         time.Duration
+	promotionThreshold  int
+	evictionBatchSize   int
+	evictionCascade     bool
+	zeroCopyReads       bool
+	tracer              Tracer
+	hashTraceKeys       bool
+	thrashWindow        time.Duration
+	thrashThreshold     int
+	thrashCooldown      time.Duration
+	totalCapacity       int
+	randSource          rand.Source
+	writeThrough        bool
+	maxEntrySize        int
+	maxKeyLength        int
+	onEvict             EvictFunc
+	loader              LoaderFunc
+	logger              Logger
+	clock               Clock
+
+	writeBackQueueSize int
+	writeBackPolicy    BackpressurePolicy
+}
+
+// Option configures a MultiTierCache created with NewCache.
+type Option func(*config)
+
+func defaultConfig() config {
+	return config{
+		memoryCapacity:  DefaultMemoryCapacity,
+		diskCapacity:    DefaultDiskCapacity,
+		policy:          &LRUPolicy{},
+		evictionCascade: true,
+		logger:          nopLogger{},
+		clock:           realClock{},
+	}
+}
+
+func WithMemoryCapacity(n int) Option {
+	return func(c *config) {
+		c.memoryCapacity = n
+	}
+}
+
+// WithMaxEntries limits the memory tier to n entries regardless of their
+// size. Both the entry and byte limits are enforced.
+func WithMaxEntries(n int) Option {
+	return func(c *config) {
+		c.maxEntries = n
+	}
+}
+
+// WithTotalCapacity caps the bytes held by the memory and disk tiers
+// together, on top of their own capacities. When a write takes the total
+// over, entries are evicted from disk, and then memory, to the remote tier
+// even if a tier has room of its own. The remote tier isn't counted, since
+// Redis only reports usage for the whole server.
+func WithTotalCapacity(bytes int) Option {
+	return func(c *config) {
+		c.totalCapacity = bytes
+	}
+}
+
+// WithEvictionBatchSize lets a policy implementing BatchChooser pick up to
+// n victims per scan of a full tier, instead of scanning once per victim,
+// when a large entry needs many evictions to fit. Victims are still evicted
+// one at a time and only until the entry fits.
+func WithEvictionBatchSize(n int) Option {
+	return func(c *config) {
+		c.evictionBatchSize = n
+	}
+}
+
+// WithEvictionCascade controls whether an entry evicted from a tier for
+// capacity moves down to the next tier, as it does by default. With false
+// it is dropped, so memory acts as a pure cache without spilling to disk,
+// along with any older copy of it in the tiers below.
+func WithEvictionCascade(enabled bool) Option {
+	return func(c *config) {
+		c.evictionCascade = enabled
+	}
+}
+
+// WithZeroCopyReads controls whether Get, GetFromTier and GetWithMetadata
+// return values from the memory tier without copying them, saving an
+// allocation and a copy per hit. It is off by default, so every read
+// returns a copy the caller owns. When on, a returned value may be the
+// cache's own slice: the caller must treat it as read-only, since
+// modifying it would corrupt the cached entry for every other reader. It
+// stays valid after the key is set again or deleted.
+func WithZeroCopyReads(enabled bool) Option {
+	return func(c *config) {
+		c.zeroCopyReads = enabled
+	}
+}
+
+// WithTracer wraps Get, Set and Delete, and the variants built on them, in
+// spans started by tracer. Each span is named after its operation, such as
+// "cache.Get", and carries the key, the tier that served a Get, and the
+// result as TraceAttrKey, TraceAttrTier and TraceAttrResult. The context
+// carrying the span is passed down to the tiers, so spans they start, such
+// as for Redis commands, become its children. Without a tracer no tracing
+// work is done.
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithTraceKeyHashing controls whether spans carry the SHA-256 of each key,
+// hex encoded, instead of the key itself, for keys that shouldn't reach
+// the tracing backend.
+func WithTraceKeyHashing(enabled bool) Option {
+	return func(c *config) {
+		c.hashTraceKeys = enabled
+	}
+}
+
+// WithThrashProtection enables protection against entries churning through
+// a memory tier too small for them. An entry evicted from memory within
+// window of being promoted, threshold times in a row, isn't promoted again
+// until cooldown has passed; it is served from the lower tiers meanwhile.
+// CacheStats.Thrashes counts those quick evictions. A threshold of zero
+// leaves the protection off, as it is by default. DefaultThrashWindow,
+// DefaultThrashThreshold and DefaultThrashCooldown are reasonable settings.
+func WithThrashProtection(window time.Duration, threshold int, cooldown time.Duration) Option {
+	return func(c *config) {
+		c.thrashWindow = window
+		c.thrashThreshold = threshold
+		c.thrashCooldown = cooldown
+	}
+}
+
+// WithMemoryShards splits the memory tier into n shards, each with its own
+// lock, to reduce contention between concurrent operations on different
+// keys. Capacity and MaxEntries still apply to the tier as a whole.
+func WithMemoryShards(n int) Option {
+	return func(c *config) {
+		c.memoryShards = n
+	}
+}
+
+// WithMemoryEntryFraction keeps entries larger than fraction of the memory
+// capacity out of memory, so that a single huge value lands on disk or the
+// remote tier instead of evicting everything else. For example 0.25 limits
+// memory entries to a quarter of its capacity.
+func WithMemoryEntryFraction(fraction float64) Option {
+	return func(c *config) {
+		c.memoryEntryFraction = fraction
+	}
+}
+
+// WithSizeFunc sets how the memory tier measures an entry's footprint
+// against its capacity. Defaults to DefaultSizeFunc, the key and value
+// length.
+func WithSizeFunc(fn SizeFunc) Option {
+	return func(c *config) {
+		c.sizeFunc = fn
+	}
+}
+
+func WithDiskCapacity(n int) Option {
+	return func(c *config) {
+		c.diskCapacity = n
+	}
+}
+
+// WithDiskDir keeps the disk tier in dir instead of a fresh temporary
+// directory, so its entries survive restarts.
+func WithDiskDir(dir string) Option {
+	return func(c *config) {
+		c.diskDir = dir
+	}
+}
+
+// WithMaxDiskFiles limits the disk tier to n entry files regardless of
+// their size.
+func WithMaxDiskFiles(n int) Option {
+	return func(c *config) {
+		c.maxDiskFiles = n
+	}
+}
+
+// WithDiskConcurrency lets at most n disk file reads and writes run at
+// once. Zero, the default, means no limit.
+func WithDiskConcurrency(n int) Option {
+	return func(c *config) {
+		c.diskConcurrency = n
+	}
+}
+
+// WithDiskCodec sets how disk entries are serialized. Defaults to
+// GobEntryCodec.
+func WithDiskCodec(codec EntryCodec) Option {
+	return func(c *config) {
+		c.diskCodec = codec
+	}
+}
+
+// WithDiskEncryption encrypts disk entry files with AES-256-GCM under key,
+// which must be 32 bytes.
+func WithDiskEncryption(key []byte) Option {
+	return func(c *config) {
+		c.diskEncryptionKey = key
+	}
+}
+
+// WithRemote enables the Redis tier. Without it the cache runs on memory
+// and disk only.
+func WithRemote(cfg RemoteStoreConfig) Option {
+	return func(c *config) {
+		c.remote = cfg
+	}
+}
+
+// WithRemoteStore uses store as the remote tier instead of connecting with
+// a RemoteStoreConfig. It lets several caches share one store.
+func WithRemoteStore(store Store) Option {
+	return func(c *config) {
+		c.remoteStore = store
+	}
+}
+
+// WithClock makes the cache and its stores read the time from clock, such
+// as a FakeClock in tests, for access times and expiry. Defaults to the real
+// clock.
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clockOrReal(clock)
+	}
+}
+
+// WithRemoteBloomFilter keeps a bloom filter of the keys written to the
+// remote tier, sized for expectedKeys keys with the given false positive
+// rate, so that a Get for a key never written there misses without a round
+// trip. The filter is seeded with the remote tier's keys when the cache is
+// created and learns keys other caches publish through
+// WithInvalidationChannel; keys written to a shared remote tier by caches
+// without that are reported as misses. Deleted keys stay in the filter and
+// just fall through to the remote tier.
+func WithRemoteBloomFilter(expectedKeys int, falsePositiveRate float64) Option {
+	return func(c *config) {
+		c.remoteBloomKeys = expectedKeys
+		c.remoteBloomFPR = falsePositiveRate
+	}
+}
+
+// WithLogger sends the cache's diagnostic messages, including the remote
+// tier's when it is created from a RemoteStoreConfig without a Logger of its
+// own, to logger. By default they are discarded.
+func WithLogger(logger Logger) Option {
+	return func(c *config) {
+		if logger == nil {
+			logger = nopLogger{}
+		}
+		c.logger = logger
+	}
+}
+
+// LoaderFunc loads the value for key from the backing store, returning the
+// TTL to cache it with; zero means no expiry.
+type LoaderFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+// WithLoader makes the cache read-through: a Get that misses every tier
+// calls fn, caches the value with the TTL it returns, and returns it.
+// Concurrent misses on one key share a single call. Loader errors are
+// returned to the caller and not cached, except that an error wrapping
+// ErrKeyNotFound with a positive TTL records the key with SetMissing for
+// that long.
+func WithLoader(fn LoaderFunc) Option {
+	return func(c *config) {
+		c.loader = fn
+	}
+}
+
+// WithInvalidationChannel keeps caches sharing a remote tier consistent:
+// each Set and Delete publishes the key on the named pub/sub channel, and
+// every other cache subscribed to it drops the key from its memory and disk
+// tiers. The remote tier must implement PubSub, as RemoteStore does.
+func WithInvalidationChannel(name string) Option {
+	return func(c *config) {
+		c.invalidationChannel = name
+	}
+}
+
+func WithPolicy(policy EvictionPolicy) Option {
+	return func(c *config) {
+		c.policy = policy
+	}
+}
+
+// WithAutoTune starts a background goroutine that rebalances the memory
+// and disk capacities every interval, keeping their sum. Whichever tier
+// served clearly more hits per byte of capacity over the interval gains a
+// step of capacity from the other, a tenth of the distance between
+// minMemory and maxMemory, and the memory capacity never leaves those
+// bounds. Shrinking a tier evicts from it as with SetMemoryCapacity and
+// SetDiskCapacity. It has no effect without a disk tier.
+func WithAutoTune(interval time.Duration, minMemory, maxMemory int) Option {
+	return func(c *config) {
+		c.autoTuneInterval = interval
+		c.autoTuneMinMemory = minMemory
+		c.autoTuneMaxMemory = maxMemory
+	}
+}
+
+// WithJanitorInterval starts a background goroutine that purges expired
+// entries from the memory and disk tiers every interval. Expired entries
+// are otherwise only removed lazily on Get.
+func WithJanitorInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.janitorInterval = interval
+	}
+}
+
+// WithTTLJitter offsets every TTL passed to SetWithTTL by a random amount
+// within ±maxJitter, so that keys set together don't all expire together.
+func WithTTLJitter(maxJitter time.Duration) Option {
+	return func(c *config) {
+		c.ttlJitter = maxJitter
+	}
+}
+
+// WithMinTTL raises any TTL shorter than d to d.
+func WithMinTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.ttlBounds.min = d
+	}
+}
+
+// WithMaxTTL lowers any TTL longer than d to d, and gives entries written
+// without a TTL a TTL of d, so that nothing stays in a shared Redis forever.
+// WithRejectNoExpiry rejects such writes instead.
+func WithMaxTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.ttlBounds.max = d
+	}
+}
+
+// WithRejectNoExpiry makes writes without a TTL, including plain Set, fail
+// with ErrTTLRequired.
+func WithRejectNoExpiry() Option {
+	return func(c *config) {
+		c.ttlBounds.rejectNoExpiry = true
+	}
+}
+
+// WithStaleWindow keeps entries for window past their TTL so that
+// GetStaleWhileRevalidate can serve them while it refreshes them. Get still
+// treats them as expired.
+func WithStaleWindow(window time.Duration) Option {
+	return func(c *config) {
+		c.staleWindow = window
+	}
+}
+
+// WithPromotionThreshold only promotes a disk or remote entry to memory
+// once its Frequency reaches n, so that entries read once by a scan don't
+// displace the memory tier. Below the threshold Get serves the entry from
+// the tier it is in.
+func WithPromotionThreshold(n int) Option {
+	return func(c *config) {
+		c.promotionThreshold = n
+	}
+}
+
+// WithRandSource sets the source of randomness for TTL jitter, e.g. a fixed
+// seed for reproducible tests.
+func WithRandSource(src rand.Source) Option {
+	return func(c *config) {
+		c.randSource = src
+	}
+}
+
+// WithWriteThrough makes Set write every entry to memory, disk and the
+// remote tier synchronously instead of only the first tier with room. Values
+// survive memory eviction and restarts, at the cost of a remote round trip
+// on every Set.
+func WithWriteThrough() Option {
+	return func(c *config) {
+		c.writeThrough = true
+	}
+}
+
+// WithWriteBack makes Set write to memory only and persist entries to the
+// disk and remote tiers from a background worker fed by a queue of
+// queueSize entries. Close flushes the queue. Writes still queued when the
+// process dies are lost.
+func WithWriteBack(queueSize int) Option {
+	return func(c *config) {
+		c.writeBackQueueSize = queueSize
+	}
+}
+
+// WithWriteBackBackpressure selects what Set does when the write-back queue
+// is full. The default is BackpressureBlock.
+func WithWriteBackBackpressure(policy BackpressurePolicy) Option {
+	return func(c *config) {
+		c.writeBackPolicy = policy
+	}
+}
+
+// WithMaxEntrySize makes Set reject values larger than n bytes with
+// ErrEntryTooLarge before touching any tier. Without it, values larger than
+// the biggest tier's capacity are rejected.
+func WithMaxEntrySize(n int) Option {
+	return func(c *config) {
+		c.maxEntrySize = n
+	}
+}
+
+// WithMaxKeyLength makes Get, Set and Delete, the variants built on them,
+// GetMulti, Peek, CompareAndSwap and Increment reject keys longer than n
+// bytes with ErrInvalidKey before touching any tier, and Has report them
+// absent. The namespace prefix doesn't count towards n. Keys aren't limited
+// by default or when n <= 0. Empty keys are always rejected.
+func WithMaxKeyLength(n int) Option {
+	return func(c *config) {
+		c.maxKeyLength = n
+	}
+}
+
+// WithNamespace prefixes every key with "prefix:" in all tiers so that
+// caches for different services can share one Redis. Keys returns keys
+// without the prefix, and Clear only removes the namespace's remote keys.
+func WithNamespace(prefix string) Option {
+	return func(c *config) {
+		c.namespace = prefix
+	}
+}
+
+// WithOnEvict registers fn to be called whenever an entry is evicted for
+// capacity, expires, or is removed by Delete. fn runs after the cache lock
+// is released, so it may call back into the cache.
+func WithOnEvict(fn EvictFunc) Option {
+	return func(c *config) {
+		c.onEvict = fn
+	}
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// SetPlan describes what setting a batch of items would do.
+type SetPlan struct {
+	// Tiers holds the highest tier each item would be stored in, or
+	// TierNone if it would be rejected, for example for being too large.
+	Tiers map[string]Tier
+	// Evicted lists the keys that would be pushed out of the memory or
+	// disk tier to make room, in the order they would first be evicted.
+	Evicted []PlannedEviction
+}
+
+// PlannedEviction is a key SetPlan expects to be evicted and the tier it
+// would end up in, or TierNone if it would be dropped.
+type PlannedEviction struct {
+	Key  string
+	Tier Tier
+}
+
+// shadower is implemented by the stores PlanSet can simulate. shadow
+// returns an empty MemoryStore that accepts and charges entries as the
+// store does.
+type shadower interface {
+	shadow() *MemoryStore
+}
+
+func (s *MemoryStore) shadow() *MemoryStore {
+	return NewMemoryStoreWithOptions(s.GetCapacity(), MemoryStoreOptions{
+		MaxEntries:   s.maxEntries,
+		SizeFunc:     s.sizeFunc,
+		MaxEntrySize: s.maxEntrySize,
+		Clock:        s.clock,
+	})
+}
+
+func (s *ShardedMemoryStore) shadow() *MemoryStore {
+	return NewMemoryStoreWithOptions(s.GetCapacity(), MemoryStoreOptions{
+		MaxEntries:   s.maxEntries,
+		SizeFunc:     s.sizeFunc,
+		MaxEntrySize: s.maxEntrySize,
+		Clock:        s.clock,
+	})
+}
+
+func (s *DiskStore) shadow() *MemoryStore {
+	return NewMemoryStoreWithOptions(s.GetCapacity(), MemoryStoreOptions{
+		MaxEntries: s.maxFiles,
+		SizeFunc: func(entry *CacheEntry) int {
+			if s.compress {
+				if value, err := compressValue(entry.Value); err == nil {
+					return len(value)
+				}
+			}
+			return len(entry.Value)
+		},
+		Clock: s.clock,
+	})
+}
+
+// PlanSet reports where each of items would be stored and which existing
+// keys would be evicted if they were set, in key order, with Set. It runs
+// the Set and eviction flow against copies of the memory and disk tiers, so
+// the cache isn't changed, but it reads every entry in both. Policies are
+// asked to choose victims but aren't told about the simulated accesses.
+// Custom memory or disk stores can't be copied and fail with
+// errors.ErrUnsupported.
+func (c *MultiTierCache) PlanSet(ctx context.Context, items map[string][]byte) (*SetPlan, error) {
+	c.mu.RLock()
+	memory, err := shadowStore(ctx, c.memoryStore)
+	var disk Store
+	if err == nil {
+		disk, err = shadowStore(ctx, c.diskStore)
+	}
+	c.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var evicted []string
+	remote := &planRemote{keys: make(map[string]struct{})}
+	sim := &MultiTierCache{
+		memoryStore: memory,
+		diskStore:   disk,
+		remoteStore: remote,
+		policy:      planPolicy{c.policy},
+		// Write-back entries end up in every tier once flushed.
+		writeThrough:    c.writeThrough || c.writeBack != nil,
+		maxEntrySize:    c.maxEntrySize,
+		namespace:       c.namespace,
+		totalCapacity:   c.totalCapacity,
+		evictionCascade: c.evictionCascade,
+		remoteCapacity:  c.remoteCapacity,
+		clock:           c.clock,
+		onEvict: func(key string, _ *CacheEntry, reason EvictReason) {
+			if reason == EvictReasonCapacity {
+				evicted = append(evicted, key)
+			}
+		},
+	}
+
+	keys := slices.Sorted(maps.Keys(items))
+	rejected := make(map[string]bool)
+	now := c.clock.Now()
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		value := items[key]
+		if sim.tooLarge(len(value)) {
+			rejected[key] = true
+			continue
+		}
+		entry := &CacheEntry{Key: sim.storeKey(key), Value: value, Size: len(value), LastAccess: now, AccessSeq: nextAccessSeq(), Frequency: 1}
+		if err := sim.setLocked(ctx, entry, nil); err != nil {
+			rejected[key] = true
+		}
+	}
+	sim.dispatchEvictions()
+
+	tierOf := func(key string) Tier {
+		sk := sim.storeKey(key)
+		switch {
+		case hasEntry(ctx, memory, sk):
+			return TierMemory
+		case hasEntry(ctx, disk, sk):
+			return TierDisk
+		case remote.has(sk):
+			return TierRemote
+		}
+		return TierNone
+	}
+	plan := &SetPlan{Tiers: make(map[string]Tier, len(items))}
+	for _, key := range keys {
+		if rejected[key] {
+			plan.Tiers[key] = TierNone
+		} else {
+			plan.Tiers[key] = tierOf(key)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, key := range evicted {
+		if !seen[key] {
+			seen[key] = true
+			plan.Evicted = append(plan.Evicted, PlannedEviction{Key: key, Tier: tierOf(key)})
+		}
+	}
+	return plan, nil
+}
+
+// shadowStore copies store into its shadow, least recently used first so
+// that the copy's recency order matches. A NullStore holds nothing and is
+// used as is.
+func shadowStore(ctx context.Context, store Store) (Store, error) {
+	if _, ok := store.(*NullStore); ok {
+		return store, nil
+	}
+	s, ok := store.(shadower)
+	if !ok {
+		return nil, fmt.Errorf("%w: can't plan for a %T tier", errors.ErrUnsupported, store)
+	}
+	shadow := s.shadow()
+	entries := store.GetAll(ctx)
+	slices.SortStableFunc(entries, compareLastAccess)
+	for _, entry := range entries {
+		shadow.Set(ctx, entry)
+	}
+	return shadow, nil
+}
+
+func hasEntry(ctx context.Context, store Store, key string) bool {
+	_, err := peekEntry(ctx, store, key)
+	return err == nil
+}
+
+// planRemote stands in for the remote tier in PlanSet, recording the keys
+// written to it.
+type planRemote struct {
+	NullStore
+	keys map[string]struct{}
+}
+
+func (s *planRemote) Set(_ context.Context, entry *CacheEntry) error {
+	s.keys[entry.Key] = struct{}{}
+	return nil
+}
+
+func (s *planRemote) has(key string) bool {
+	_, ok := s.keys[key]
+	return ok
+}
+
+// planPolicy passes PlanSet's victim and admission choices to the cache's
+// policy without recording accesses, so planning doesn't train it.
+type planPolicy struct {
+	policy EvictionPolicy
+}
+
+func (p planPolicy) Choose(entries []*CacheEntry) string {
+	return p.policy.Choose(entries)
+}
+
+func (p planPolicy) Admit(candidate, victim string) bool {
+	if admitter, ok := p.policy.(AdmissionPolicy); ok {
+		return admitter.Admit(candidate, victim)
+	}
+	return true
+}
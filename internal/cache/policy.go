@@ -1,25 +1,406 @@
 package cache
 
 import (
+	"cmp"
+	"hash/fnv"
+	"iter"
+	"slices"
+	"sync"
 	"time"
 )
 
 type LRUPolicy struct{}
 
-func (p *LRUPolicy) Choose(entries []*CacheEntry) string {
-	if len(entries) == 0 {
-		return ""
+// SeqChooser is implemented by policies that can pick a victim from a
+// stream of entries, so the cache can evict from a store with ForEach
+// without copying every entry into a slice first.
+type SeqChooser interface {
+	ChooseSeq(entries iter.Seq[*CacheEntry]) string
+}
+
+// BatchChooser is implemented by policies that can pick several victims
+// from one scan. ChooseN returns up to n keys, the one to evict first
+// first. The cache uses it when WithEvictionBatchSize is set.
+type BatchChooser interface {
+	ChooseN(entries []*CacheEntry, n int) []string
+}
+
+// firstKeys returns the keys of the first n entries, lowest priority first
+// and then in the order given by order, without reordering entries.
+func firstKeys(entries []*CacheEntry, n int, order func(a, b *CacheEntry) int) []string {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b *CacheEntry) int {
+		if a.Priority != b.Priority {
+			return cmp.Compare(a.Priority, b.Priority)
+		}
+		return order(a, b)
+	})
+	sorted = sorted[:min(n, len(sorted))]
+
+	keys := make([]string, len(sorted))
+	for i, entry := range sorted {
+		keys[i] = entry.Key
 	}
+	return keys
+}
 
-	oldestAccess := time.Now()
-	oldestKey := ""
+// compareLastAccess orders entries by when they were last accessed. It
+// compares AccessSeq when both entries have one, so that a wall clock
+// stepped backwards can't make a newer entry look older.
+func compareLastAccess(a, b *CacheEntry) int {
+	if a.AccessSeq != 0 && b.AccessSeq != 0 {
+		return cmp.Compare(a.AccessSeq, b.AccessSeq)
+	}
+	return a.LastAccess.Compare(b.LastAccess)
+}
 
-	for _, entry := range entries {
-		if entry.LastAccess.Before(oldestAccess) {
-			oldestAccess = entry.LastAccess
+func (p *LRUPolicy) Choose(entries []*CacheEntry) string {
+	return p.ChooseSeq(slices.Values(entries))
+}
+
+func (p *LRUPolicy) ChooseSeq(entries iter.Seq[*CacheEntry]) string {
+	var oldestKey string
+	var oldest CacheEntry
+
+	for entry := range lowestPriority(entries) {
+		if oldestKey == "" || compareLastAccess(entry, &oldest) < 0 {
 			oldestKey = entry.Key
+			oldest = CacheEntry{LastAccess: entry.LastAccess, AccessSeq: entry.AccessSeq}
 		}
 	}
 
 	return oldestKey
 }
+
+func (p *LRUPolicy) ChooseN(entries []*CacheEntry, n int) []string {
+	return firstKeys(entries, n, compareLastAccess)
+}
+
+// ChooseFromStore picks the least recently used key in O(1) when store keeps
+// its own recency order. It returns false if store can't answer, or if that
+// entry has a priority, in which case the caller falls back to Choose.
+func (p *LRUPolicy) ChooseFromStore(store Store) (string, bool) {
+	ordered, ok := store.(RecencyOrdered)
+	if !ok {
+		return "", false
+	}
+	entry, ok := ordered.LeastRecentlyUsed()
+	if !ok || entry.Priority > 0 {
+		return "", false
+	}
+	return entry.Key, true
+}
+
+// SLRUPolicy is a segmented LRU. Entries accessed only once sit in a
+// probationary segment and are evicted first; entries accessed again move
+// to a protected segment, which is only touched when probation is empty.
+// This keeps a one-off scan from flushing a hot working set. Segment
+// membership is derived from CacheEntry.Frequency, which the cache bumps
+// on every hit.
+type SLRUPolicy struct{}
+
+func (p *SLRUPolicy) Choose(entries []*CacheEntry) string {
+	return p.ChooseSeq(slices.Values(entries))
+}
+
+// ChooseN returns probationary entries before protected ones, each in LRU
+// order.
+func (p *SLRUPolicy) ChooseN(entries []*CacheEntry, n int) []string {
+	return firstKeys(entries, n, func(a, b *CacheEntry) int {
+		if ap, bp := a.Frequency < 2, b.Frequency < 2; ap != bp {
+			if ap {
+				return -1
+			}
+			return 1
+		}
+		return compareLastAccess(a, b)
+	})
+}
+
+func (p *SLRUPolicy) ChooseSeq(entries iter.Seq[*CacheEntry]) string {
+	var probationKey, protectedKey string
+	var probation, protected CacheEntry
+
+	for entry := range lowestPriority(entries) {
+		access := CacheEntry{LastAccess: entry.LastAccess, AccessSeq: entry.AccessSeq}
+		if entry.Frequency < 2 {
+			if probationKey == "" || compareLastAccess(&access, &probation) <= 0 {
+				probationKey = entry.Key
+				probation = access
+			}
+			continue
+		}
+		if protectedKey == "" || compareLastAccess(&access, &protected) <= 0 {
+			protectedKey = entry.Key
+			protected = access
+		}
+	}
+
+	if probationKey != "" {
+		return probationKey
+	}
+	return protectedKey
+}
+
+// TTLPolicy evicts the entry that expires soonest, since it would be gone
+// shortly anyway, so long-lived entries survive memory pressure. Entries
+// without a TTL are only evicted once none with one remain, in LRU order.
+type TTLPolicy struct{}
+
+func (p *TTLPolicy) Choose(entries []*CacheEntry) string {
+	return p.ChooseSeq(slices.Values(entries))
+}
+
+// ChooseN returns entries with a TTL, soonest to expire first, before
+// those without one in LRU order.
+func (p *TTLPolicy) ChooseN(entries []*CacheEntry, n int) []string {
+	return firstKeys(entries, n, func(a, b *CacheEntry) int {
+		switch {
+		case a.ExpiresAt.IsZero() && b.ExpiresAt.IsZero():
+			return compareLastAccess(a, b)
+		case a.ExpiresAt.IsZero():
+			return 1
+		case b.ExpiresAt.IsZero():
+			return -1
+		}
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+}
+
+func (p *TTLPolicy) ChooseSeq(entries iter.Seq[*CacheEntry]) string {
+	var soonestKey string
+	var soonest time.Time
+
+	for entry := range lowestPriority(entries) {
+		if entry.ExpiresAt.IsZero() {
+			continue
+		}
+		if soonestKey == "" || entry.ExpiresAt.Before(soonest) {
+			soonestKey = entry.Key
+			soonest = entry.ExpiresAt
+		}
+	}
+
+	if soonestKey != "" {
+		return soonestKey
+	}
+	return (&LRUPolicy{}).ChooseSeq(entries)
+}
+
+// CostAwareLRUPolicy evicts the entry that frees the most space per unit of
+// hotness lost: its Size divided by its Hotness. One large cold entry then
+// goes before several small warm ones, so fitting an incoming entry takes
+// fewer evictions than with LRUPolicy.
+type CostAwareLRUPolicy struct {
+	// Hotness returns how costly evicting entry would be at now; it must
+	// be positive. Nil uses DefaultHotness.
+	Hotness func(entry *CacheEntry, now time.Time) float64
+	// Clock supplies now. Nil uses the real clock.
+	Clock Clock
+}
+
+// DefaultHotness is the number of accesses to entry divided by the seconds
+// since the last one, plus one, so both recent and frequent entries are hot.
+// An access stamped after now, by a clock since stepped back, counts as
+// just made.
+func DefaultHotness(entry *CacheEntry, now time.Time) float64 {
+	return float64(max(entry.Frequency, 1)) / (max(now.Sub(entry.LastAccess).Seconds(), 0) + 1)
+}
+
+// score is the space entry frees per unit of hotness.
+func (p *CostAwareLRUPolicy) score(entry *CacheEntry, now time.Time) float64 {
+	hotness := p.Hotness
+	if hotness == nil {
+		hotness = DefaultHotness
+	}
+	return float64(entry.Size) / hotness(entry, now)
+}
+
+func (p *CostAwareLRUPolicy) Choose(entries []*CacheEntry) string {
+	return p.ChooseSeq(slices.Values(entries))
+}
+
+func (p *CostAwareLRUPolicy) ChooseSeq(entries iter.Seq[*CacheEntry]) string {
+	now := clockOrReal(p.Clock).Now()
+	bestKey := ""
+	bestScore := 0.0
+
+	for entry := range lowestPriority(entries) {
+		if score := p.score(entry, now); bestKey == "" || score > bestScore {
+			bestKey = entry.Key
+			bestScore = score
+		}
+	}
+
+	return bestKey
+}
+
+// ChooseN returns entries in decreasing order of space freed per unit of
+// hotness.
+func (p *CostAwareLRUPolicy) ChooseN(entries []*CacheEntry, n int) []string {
+	now := clockOrReal(p.Clock).Now()
+	scores := make(map[*CacheEntry]float64, len(entries))
+	for _, entry := range entries {
+		scores[entry] = p.score(entry, now)
+	}
+	return firstKeys(entries, n, func(a, b *CacheEntry) int {
+		return cmp.Compare(scores[b], scores[a])
+	})
+}
+
+// AccessRecorder is implemented by policies that keep their own access
+// history. The cache calls RecordAccess on every Get hit and every write.
+type AccessRecorder interface {
+	RecordAccess(key string)
+}
+
+// MissRecorder is implemented by policies that also count lookups of keys
+// the cache doesn't hold.
+type MissRecorder interface {
+	RecordMiss(key string)
+}
+
+// EvictionRecorder is implemented by policies that need to know when an
+// entry leaves the memory tier, whether it was evicted for capacity,
+// expired or was deleted.
+type EvictionRecorder interface {
+	RecordEvict(key string)
+}
+
+// AdmissionPolicy is implemented by policies that decide whether a new
+// entry is worth evicting victim for. When Admit returns false the entry is
+// not stored in that tier and falls through to the next one.
+type AdmissionPolicy interface {
+	Admit(candidate, victim string) bool
+}
+
+// TinyLFUPolicy evicts in LRU order but only admits a new entry into a full
+// tier if its estimated access frequency is higher than that of the entry
+// it would displace. Frequencies are estimated with a count-min sketch that
+// is periodically halved so that old popularity fades. The zero value is
+// ready to use, with a sketch of defaultSketchWidth counters per row.
+type TinyLFUPolicy struct {
+	LRUPolicy
+
+	mu     sync.Mutex
+	sketch *countMinSketch
+}
+
+// NewTinyLFUPolicy creates a TinyLFUPolicy whose sketch has width counters
+// per row. Width should be on the order of the number of distinct keys
+// expected in the cache.
+func NewTinyLFUPolicy(width int) *TinyLFUPolicy {
+	return &TinyLFUPolicy{sketch: newCountMinSketch(width)}
+}
+
+// defaultSketchWidth is the sketch width of a zero-value TinyLFUPolicy.
+const defaultSketchWidth = 1024
+
+// counts returns the policy's sketch, creating it on first use. Callers
+// hold p.mu.
+func (p *TinyLFUPolicy) counts() *countMinSketch {
+	if p.sketch == nil {
+		p.sketch = newCountMinSketch(defaultSketchWidth)
+	}
+	return p.sketch
+}
+
+func (p *TinyLFUPolicy) RecordAccess(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.counts().add(key)
+}
+
+// RecordMiss counts a miss as an access, so that a key requested often
+// while absent is admitted once it is loaded.
+func (p *TinyLFUPolicy) RecordMiss(key string) {
+	p.RecordAccess(key)
+}
+
+func (p *TinyLFUPolicy) Admit(candidate, victim string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sketch := p.counts()
+	return sketch.estimate(candidate) > sketch.estimate(victim)
+}
+
+// Estimate returns the approximate number of recent accesses to key.
+func (p *TinyLFUPolicy) Estimate(key string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int(p.counts().estimate(key))
+}
+
+const (
+	sketchDepth      = 4
+	sketchMaxCounter = 15
+)
+
+type countMinSketch struct {
+	width     uint64
+	counters  [sketchDepth][]uint8
+	additions int
+	// resetAt is the number of additions after which all counters are
+	// halved.
+	resetAt int
+}
+
+func newCountMinSketch(width int) *countMinSketch {
+	if width < 16 {
+		width = 16
+	}
+	s := &countMinSketch{
+		width:   uint64(width),
+		resetAt: 10 * width,
+	}
+	for i := range s.counters {
+		s.counters[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *countMinSketch) indexes(key string) [sketchDepth]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	var idx [sketchDepth]uint64
+	for i := range idx {
+		idx[i] = (h1 + uint64(i)*h2) % s.width
+	}
+	return idx
+}
+
+func (s *countMinSketch) add(key string) {
+	for row, i := range s.indexes(key) {
+		if s.counters[row][i] < sketchMaxCounter {
+			s.counters[row][i]++
+		}
+	}
+
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// reset halves every counter so that past popularity decays.
+func (s *countMinSketch) reset() {
+	for row := range s.counters {
+		for i := range s.counters[row] {
+			s.counters[row][i] /= 2
+		}
+	}
+	s.additions /= 2
+}
+
+func (s *countMinSketch) estimate(key string) uint8 {
+	min := uint8(sketchMaxCounter)
+	for row, i := range s.indexes(key) {
+		if s.counters[row][i] < min {
+			min = s.counters[row][i]
+		}
+	}
+	return min
+}
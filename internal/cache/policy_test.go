@@ -0,0 +1,510 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSLRUPolicyResistsScan(t *testing.T) {
+	ctx := context.Background()
+
+	for _, bc := range []struct {
+		name         string
+		policy       EvictionPolicy
+		wantSurvived bool
+	}{
+		{"LRU", &LRUPolicy{}, false},
+		{"SLRU", &SLRUPolicy{}, true},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			c, err := NewCache(
+				WithMemoryCapacity(100),
+				WithDiskCapacity(0),
+				WithPolicy(bc.policy),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			hot := []string{"hot0", "hot1", "hot2", "hot3", "hot4"}
+			for _, key := range hot {
+				c.Set(ctx, key, make([]byte, 10))
+				c.Get(ctx, key)
+			}
+
+			// A scan of one-time keys, with the hot set touched halfway.
+			for i := 0; i < 50; i++ {
+				c.Set(ctx, fmt.Sprintf("scan%d", i), make([]byte, 10))
+				if i == 25 {
+					c.Get(ctx, "hot0")
+				}
+			}
+
+			survived := true
+			for _, key := range hot {
+				if _, err := c.memoryStore.Get(ctx, key); err != nil {
+					survived = false
+				}
+			}
+			if survived != bc.wantSurvived {
+				t.Errorf("Hot set survived scan = %v, want %v", survived, bc.wantSurvived)
+			}
+		})
+	}
+}
+
+func TestTinyLFUAdmission(t *testing.T) {
+	policy := NewTinyLFUPolicy(1024)
+	c, err := NewCache(
+		WithMemoryCapacity(20),
+		WithDiskCapacity(100),
+		WithPolicy(policy),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+
+	c.Set(ctx, "incumbent", make([]byte, 10))
+	for i := 0; i < 5; i++ {
+		c.Get(ctx, "incumbent")
+	}
+
+	// A rarely-accessed newcomer must not displace the incumbent.
+	c.Set(ctx, "rare", make([]byte, 10))
+	if _, err := c.memoryStore.Get(ctx, "incumbent"); err != nil {
+		t.Error("Expected frequently-accessed incumbent to stay in memory")
+	}
+	if _, err := c.memoryStore.Get(ctx, "rare"); err == nil {
+		t.Error("Expected rarely-accessed key to be rejected from memory")
+	}
+	if _, err := c.diskStore.Get(ctx, "rare"); err != nil {
+		t.Errorf("Expected rejected key to fall through to disk, got error: %v", err)
+	}
+
+	// A key requested more often than the incumbent is admitted.
+	for i := 0; i < 10; i++ {
+		c.Get(ctx, "popular")
+	}
+	c.Set(ctx, "popular", make([]byte, 10))
+	if _, err := c.memoryStore.Get(ctx, "popular"); err != nil {
+		t.Error("Expected popular key to be admitted to memory")
+	}
+}
+
+func TestTinyLFUZeroValue(t *testing.T) {
+	var policy TinyLFUPolicy
+	if policy.Estimate("key") != 0 || policy.Admit("key", "other") {
+		t.Error("Expected a zero-value policy to start with no counts")
+	}
+	policy.RecordAccess("key")
+	policy.RecordMiss("key")
+	if n := policy.Estimate("key"); n != 2 {
+		t.Errorf("Expected an estimate of 2, got %d", n)
+	}
+	if !policy.Admit("key", "other") {
+		t.Error("Expected the more frequent key to be admitted")
+	}
+
+	c, err := NewCache(WithMemoryCapacity(20), WithDiskCapacity(100), WithPolicy(&TinyLFUPolicy{}))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		c.Set(ctx, fmt.Sprintf("key%d", i), make([]byte, 10))
+	}
+	if _, err := c.Get(ctx, "key4"); err != nil {
+		t.Errorf("Get failed: %v", err)
+	}
+}
+
+func TestCountMinSketch(t *testing.T) {
+	s := newCountMinSketch(64)
+	for i := 0; i < 5; i++ {
+		s.add("a")
+	}
+	s.add("b")
+
+	if got := s.estimate("a"); got < 5 {
+		t.Errorf("Expected estimate for a to be at least 5, got %d", got)
+	}
+	if got := s.estimate("a"); got <= s.estimate("b") {
+		t.Errorf("Expected a to be estimated above b")
+	}
+
+	before := s.estimate("a")
+	s.reset()
+	if got := s.estimate("a"); got != before/2 {
+		t.Errorf("Expected reset to halve estimate for a to %d, got %d", before/2, got)
+	}
+}
+
+func TestARCPolicyBeatsLRUOnMixedTrace(t *testing.T) {
+	ctx := context.Background()
+
+	// A hot set is used twice, then revisited in rounds interleaved with
+	// one-off scans. The distance between uses of a hot key exceeds memory,
+	// so LRU loses the hot set to every scan.
+	var trace []string
+	for i := 0; i < 15; i++ {
+		key := fmt.Sprintf("h%04d", i)
+		trace = append(trace, key, key)
+	}
+	scan := 0
+	for round := 0; round < 50; round++ {
+		for i := 0; i < 15; i++ {
+			trace = append(trace, fmt.Sprintf("h%04d", i))
+		}
+		for i := 0; i < 10; i++ {
+			trace = append(trace, fmt.Sprintf("s%04d", scan))
+			scan++
+		}
+	}
+
+	hitRate := func(policy EvictionPolicy) float64 {
+		// 20 entries of 10 bytes each.
+		c, err := NewCache(
+			WithMemoryCapacity(200),
+			WithDiskCapacity(0),
+			WithPolicy(policy),
+		)
+		if err != nil {
+			t.Fatalf("Failed to create cache: %v", err)
+		}
+		defer c.Close()
+
+		for _, key := range trace {
+			if _, err := c.Get(ctx, key); err != nil {
+				c.Set(ctx, key, make([]byte, 5))
+			}
+		}
+		return float64(c.GetTierStats().MemoryHits) / float64(len(trace))
+	}
+
+	lru := hitRate(&LRUPolicy{})
+	arc := hitRate(NewARCPolicy(20))
+	t.Logf("hit rate: LRU %.2f, ARC %.2f", lru, arc)
+	if arc <= lru {
+		t.Errorf("ARC hit rate %.2f, want higher than LRU's %.2f", arc, lru)
+	}
+}
+
+func TestARCPolicyAdaptsTarget(t *testing.T) {
+	policy := NewARCPolicy(2)
+
+	policy.RecordAccess("a")
+	policy.RecordEvict("a")
+	policy.RecordAccess("a")
+	if got := policy.Target(); got != 1 {
+		t.Errorf("Target after B1 hit = %d, want 1", got)
+	}
+
+	policy.RecordEvict("a")
+	policy.RecordAccess("a")
+	if got := policy.Target(); got != 0 {
+		t.Errorf("Target after B2 hit = %d, want 0", got)
+	}
+
+	entries := []*CacheEntry{{Key: "a"}, {Key: "b"}}
+	policy.RecordAccess("b")
+	if got := policy.Choose(entries); got != "b" {
+		t.Errorf("Choose = %q, want T1 entry %q", got, "b")
+	}
+}
+
+func TestTTLPolicyEvictsSoonestToExpire(t *testing.T) {
+	now := time.Now()
+	entries := []*CacheEntry{
+		{Key: "forever-old", LastAccess: now.Add(-time.Hour)},
+		{Key: "later", ExpiresAt: now.Add(time.Hour), LastAccess: now.Add(-time.Minute)},
+		{Key: "forever-new", LastAccess: now},
+		{Key: "soon", ExpiresAt: now.Add(time.Minute), LastAccess: now},
+	}
+
+	policy := &TTLPolicy{}
+	for _, want := range []string{"soon", "later", "forever-old", "forever-new"} {
+		got := policy.Choose(entries)
+		if got != want {
+			t.Fatalf("Choose = %q, want %q", got, want)
+		}
+		for i, entry := range entries {
+			if entry.Key == got {
+				entries = append(entries[:i], entries[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+func TestTTLPolicyInCache(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(
+		WithMemoryCapacity(30),
+		WithDiskCapacity(0),
+		WithPolicy(&TTLPolicy{}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	c.Set(ctx, "aaaa", make([]byte, 6))
+	c.SetWithTTL(ctx, "bbbb", make([]byte, 6), time.Minute)
+	c.SetWithTTL(ctx, "cccc", make([]byte, 6), time.Hour)
+
+	// Needs one slot; the entry expiring soonest goes even though "aaaa"
+	// is older.
+	c.Set(ctx, "dddd", make([]byte, 6))
+	if _, err := c.memoryStore.Get(ctx, "bbbb"); err == nil {
+		t.Error("Expected the soonest-to-expire entry to be evicted")
+	}
+	for _, key := range []string{"aaaa", "cccc", "dddd"} {
+		if _, err := c.memoryStore.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to remain in memory", key)
+		}
+	}
+}
+
+func TestCostAwareLRUPolicy(t *testing.T) {
+	now := time.Now()
+	// The small entries are older than the large one, so LRU would evict
+	// them first, but they are accessed far more often.
+	entries := []*CacheEntry{
+		{Key: "small1", Size: 8, Frequency: 10, LastAccess: now.Add(-30 * time.Second)},
+		{Key: "small2", Size: 8, Frequency: 10, LastAccess: now.Add(-40 * time.Second)},
+		{Key: "large", Size: 50, Frequency: 1, LastAccess: now.Add(-20 * time.Second)},
+		{Key: "small3", Size: 8, Frequency: 10, LastAccess: now.Add(-35 * time.Second)},
+	}
+	if got := (&LRUPolicy{}).Choose(entries); got != "small2" {
+		t.Fatalf("Expected LRU to evict the oldest small entry, got %q", got)
+	}
+
+	policy := &CostAwareLRUPolicy{}
+	if got := policy.Choose(entries); got != "large" {
+		t.Errorf("Expected the large cold entry to be evicted first, got %q", got)
+	}
+	if got := policy.ChooseN(entries, 2); !reflect.DeepEqual(got, []string{"large", "small2"}) {
+		t.Errorf("Expected ChooseN to order by space freed per hotness, got %v", got)
+	}
+
+	bySize := &CostAwareLRUPolicy{Hotness: func(*CacheEntry, time.Time) float64 { return 1 }}
+	entries[0].Size = 100
+	if got := bySize.Choose(entries); got != "small1" {
+		t.Errorf("Expected a custom hotness to be used, got %q", got)
+	}
+
+	ctx := context.Background()
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000), WithPolicy(policy))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+	for _, entry := range entries[1:] {
+		entry := *entry
+		entry.Value = make([]byte, entry.Size-len(entry.Key))
+		c.memoryStore.Set(ctx, &entry)
+	}
+	c.Set(ctx, "incoming", make([]byte, 40))
+	if stats := c.GetCacheStats(); stats.MemoryEvictions != 1 {
+		t.Errorf("Expected one eviction to fit the incoming entry, got %d", stats.MemoryEvictions)
+	}
+	if _, err := c.memoryStore.Get(ctx, "large"); err == nil {
+		t.Error("Expected the large entry to have been evicted")
+	}
+}
+
+func TestLRUPolicyIgnoresWallClockSteps(t *testing.T) {
+	ctx := context.Background()
+	clock := NewFakeClock(time.Now())
+	c, err := NewCache(WithMemoryCapacity(30), WithDiskCapacity(0), WithPolicy(&SLRUPolicy{}), WithClock(clock))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	// The clock steps back a minute before every operation, as if NTP kept
+	// correcting it.
+	for _, key := range []string{"a", "b", "c"} {
+		clock.Advance(-time.Minute)
+		c.Set(ctx, key, make([]byte, 9))
+	}
+	clock.Advance(-time.Minute)
+	c.Get(ctx, "a")
+
+	entries := c.memoryStore.GetAll(ctx)
+	if got := (&LRUPolicy{}).Choose(entries); got != "b" {
+		t.Errorf("Expected LRU to choose the least recently accessed entry b, got %q", got)
+	}
+	if got := (&LRUPolicy{}).ChooseN(entries, 3); !reflect.DeepEqual(got, []string{"b", "c", "a"}) {
+		t.Errorf("Expected ChooseN to follow access order, got %v", got)
+	}
+
+	c.Set(ctx, "d", make([]byte, 9))
+	if _, err := c.memoryStore.Get(ctx, "b"); err == nil {
+		t.Error("Expected b to have been evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, err := c.memoryStore.Get(ctx, key); err != nil {
+			t.Errorf("Expected %s to remain in memory", key)
+		}
+	}
+}
+
+func TestChooseN(t *testing.T) {
+	now := time.Now()
+	entries := []*CacheEntry{
+		{Key: "hot", LastAccess: now.Add(-4 * time.Minute), Frequency: 5},
+		{Key: "newest", LastAccess: now, Frequency: 1},
+		{Key: "oldest", LastAccess: now.Add(-5 * time.Minute), Frequency: 1, ExpiresAt: now.Add(time.Hour)},
+		{Key: "expiring", LastAccess: now.Add(-time.Minute), Frequency: 1, ExpiresAt: now.Add(time.Minute)},
+	}
+
+	for _, bc := range []struct {
+		name   string
+		policy BatchChooser
+		want   []string
+	}{
+		{"LRU", &LRUPolicy{}, []string{"oldest", "hot", "expiring"}},
+		{"SLRU", &SLRUPolicy{}, []string{"oldest", "expiring", "newest"}},
+		{"TTL", &TTLPolicy{}, []string{"expiring", "oldest", "hot"}},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			if got := bc.policy.ChooseN(entries, 3); !reflect.DeepEqual(got, bc.want) {
+				t.Errorf("Expected %v, got %v", bc.want, got)
+			}
+			if first := bc.policy.(EvictionPolicy).Choose(entries); first != bc.want[0] {
+				t.Errorf("Expected ChooseN to start with Choose's victim %s, got %s", first, bc.want[0])
+			}
+		})
+	}
+
+	if got := (&LRUPolicy{}).ChooseN(entries, 10); len(got) != len(entries) {
+		t.Errorf("Expected ChooseN to return every entry when n exceeds them, got %v", got)
+	}
+	if entries[0].Key != "hot" {
+		t.Error("Expected ChooseN not to reorder its input")
+	}
+}
+
+func TestEvictionBatchSize(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCache(
+		WithMemoryCapacity(140),
+		WithDiskCapacity(1000),
+		WithPolicy(&SLRUPolicy{}),
+		WithEvictionBatchSize(8),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.Set(ctx, fmt.Sprintf("key%d", i), []byte("valuevalue"))
+	}
+	c.Set(ctx, "large", make([]byte, 35))
+
+	// Evicting key0..key2 makes room for the 40-byte entry; the rest of
+	// the batch is left alone.
+	if got := c.memoryStore.(*MemoryStore).Len(); got != 8 {
+		t.Errorf("Expected 7 small entries plus the large one in memory, got %d entries", got)
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		_, err := peekEntry(ctx, c.memoryStore, key)
+		if inMemory := err == nil; inMemory != (i >= 3) {
+			t.Errorf("Expected %s in memory to be %v", key, i >= 3)
+		}
+		if _, err := c.diskStore.Get(ctx, key); (err == nil) != (i < 3) {
+			t.Errorf("Expected %s on disk to be %v", key, i < 3)
+		}
+	}
+}
+
+func TestPriorityProtectsFromEviction(t *testing.T) {
+	ctx := context.Background()
+	for _, policy := range []EvictionPolicy{&LRUPolicy{}, &SLRUPolicy{}, &TTLPolicy{}, NewARCPolicy(10)} {
+		t.Run(fmt.Sprintf("%T", policy), func(t *testing.T) {
+			c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(0), WithPolicy(policy))
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			c.SetWithOptions(ctx, "config", []byte("value"), SetOptions{Priority: 1})
+			for i := 0; i < 50; i++ {
+				c.SetWithTTL(ctx, fmt.Sprintf("key%02d", i), []byte("value"), time.Hour)
+			}
+			if _, err := c.memoryStore.Get(ctx, "config"); err != nil {
+				t.Errorf("Expected the prioritized key to survive eviction, got %v", err)
+			}
+		})
+	}
+}
+
+// keyOrderPolicy evicts the smallest key, ignoring priority.
+type keyOrderPolicy struct{}
+
+func (keyOrderPolicy) Choose(entries []*CacheEntry) string {
+	smallest := ""
+	for _, entry := range entries {
+		if smallest == "" || entry.Key < smallest {
+			smallest = entry.Key
+		}
+	}
+	return smallest
+}
+
+func TestPinnedPolicy(t *testing.T) {
+	ctx := context.Background()
+	for _, bc := range []struct {
+		name     string
+		policy   EvictionPolicy
+		survives bool
+	}{
+		{"Unwrapped", keyOrderPolicy{}, false},
+		{"Pinned", NewPinnedPolicy(keyOrderPolicy{}), true},
+	} {
+		t.Run(bc.name, func(t *testing.T) {
+			c, err := NewCache(WithMemoryCapacity(50), WithDiskCapacity(0), WithPolicy(bc.policy))
+			if err != nil {
+				t.Fatalf("Failed to create cache: %v", err)
+			}
+			defer c.Close()
+
+			c.SetWithOptions(ctx, "a-config", []byte("value"), SetOptions{Priority: MaxPriority})
+			for round := 0; round < 5; round++ {
+				for i := 0; i < 10; i++ {
+					c.Set(ctx, fmt.Sprintf("key%d", i), []byte("value"))
+				}
+			}
+			if _, err := c.memoryStore.Get(ctx, "a-config"); (err == nil) != bc.survives {
+				t.Errorf("Expected the pinned key to survive to be %v, got %v", bc.survives, err)
+			}
+		})
+	}
+
+	only := []*CacheEntry{{Key: "pinned", Priority: MaxPriority}}
+	if got := NewPinnedPolicy(nil).Choose(only); got != "pinned" {
+		t.Errorf("Expected a pinned entry to be chosen as a last resort, got %q", got)
+	}
+	tinyLFU := NewTinyLFUPolicy(64)
+	pinned := NewPinnedPolicy(tinyLFU)
+	for i := 0; i < 3; i++ {
+		pinned.RecordAccess("hot")
+	}
+	if pinned.Admit("cold", "hot") || !pinned.Admit("hot", "cold") {
+		t.Error("Expected admission to be left to the wrapped TinyLFUPolicy")
+	}
+	if !NewPinnedPolicy(nil).Admit("cold", "hot") {
+		t.Error("Expected everything to be admitted when the wrapped policy doesn't decide")
+	}
+}
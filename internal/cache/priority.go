@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"iter"
+	"math"
+	"slices"
+)
+
+// MaxPriority is the highest entry priority. PinnedPolicy never evicts
+// entries with it while any other entry remains.
+const MaxPriority = math.MaxInt
+
+// lowestPriority yields only the entries sharing the lowest Priority in
+// entries, so that higher-priority entries are considered only once none
+// of lower priority remain. It reads entries twice.
+func lowestPriority(entries iter.Seq[*CacheEntry]) iter.Seq[*CacheEntry] {
+	return func(yield func(*CacheEntry) bool) {
+		lowest := MaxPriority
+		for entry := range entries {
+			lowest = min(lowest, entry.Priority)
+		}
+		for entry := range entries {
+			if entry.Priority == lowest && !yield(entry) {
+				return
+			}
+		}
+	}
+}
+
+// PinnedPolicy wraps another policy so that entries with MaxPriority are
+// only chosen once nothing else is left, which lets any policy, including
+// ones that ignore Priority, protect keys such as configuration blobs. It
+// passes accesses, misses and evictions on to the wrapped policy, and
+// leaves admission to it if it is an AdmissionPolicy.
+type PinnedPolicy struct {
+	policy EvictionPolicy
+}
+
+// NewPinnedPolicy wraps policy, or LRUPolicy if it is nil.
+func NewPinnedPolicy(policy EvictionPolicy) *PinnedPolicy {
+	if policy == nil {
+		policy = &LRUPolicy{}
+	}
+	return &PinnedPolicy{policy: policy}
+}
+
+func (p *PinnedPolicy) Choose(entries []*CacheEntry) string {
+	unpinned := slices.DeleteFunc(slices.Clone(entries), func(entry *CacheEntry) bool {
+		return entry.Priority == MaxPriority
+	})
+	if len(unpinned) > 0 {
+		return p.policy.Choose(unpinned)
+	}
+	return p.policy.Choose(entries)
+}
+
+func (p *PinnedPolicy) RecordAccess(key string) {
+	if recorder, ok := p.policy.(AccessRecorder); ok {
+		recorder.RecordAccess(key)
+	}
+}
+
+func (p *PinnedPolicy) RecordMiss(key string) {
+	if recorder, ok := p.policy.(MissRecorder); ok {
+		recorder.RecordMiss(key)
+	}
+}
+
+func (p *PinnedPolicy) RecordEvict(key string) {
+	if recorder, ok := p.policy.(EvictionRecorder); ok {
+		recorder.RecordEvict(key)
+	}
+}
+
+func (p *PinnedPolicy) Admit(candidate, victim string) bool {
+	if admitter, ok := p.policy.(AdmissionPolicy); ok {
+		return admitter.Admit(candidate, victim)
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	hitsDesc = prometheus.NewDesc(
+		"cache_hits_total",
+		"Number of Get calls served, by tier.",
+		[]string{"tier"}, nil,
+	)
+	missesDesc = prometheus.NewDesc(
+		"cache_misses_total",
+		"Number of Get calls that missed every tier.",
+		nil, nil,
+	)
+	evictionsDesc = prometheus.NewDesc(
+		"cache_evictions_total",
+		"Number of entries evicted for capacity, by tier.",
+		[]string{"tier"}, nil,
+	)
+	promotionsDesc = prometheus.NewDesc(
+		"cache_promotions_total",
+		"Number of entries promoted into memory from a lower tier.",
+		nil, nil,
+	)
+	thrashesDesc = prometheus.NewDesc(
+		"cache_thrashes_total",
+		"Number of entries evicted from memory soon after being promoted to it.",
+		nil, nil,
+	)
+	usageDesc = prometheus.NewDesc(
+		"cache_store_usage_bytes",
+		"Bytes currently used by each store.",
+		[]string{"tier"}, nil,
+	)
+	capacityDesc = prometheus.NewDesc(
+		"cache_store_capacity_bytes",
+		"Configured capacity of each store.",
+		[]string{"tier"}, nil,
+	)
+	circuitOpenDesc = prometheus.NewDesc(
+		"cache_remote_circuit_open",
+		"1 while the remote tier's circuit breaker is short-circuiting calls.",
+		nil, nil,
+	)
+)
+
+type prometheusCollector struct {
+	cache   *MultiTierCache
+	latency *prometheus.HistogramVec
+}
+
+// NewPrometheusCollector returns a collector exposing hit/miss, eviction
+// and promotion counters, per-store usage and capacity gauges, and a Get/Set latency histogram for
+// c. Register it with a prometheus.Registerer to start scraping.
+func NewPrometheusCollector(c *MultiTierCache) prometheus.Collector {
+	collector := &prometheusCollector{
+		cache: c,
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_operation_duration_seconds",
+			Help:    "Latency of cache operations.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"op"}),
+	}
+	c.addLatencyObserver(func(op string, d time.Duration) {
+		collector.latency.WithLabelValues(op).Observe(d.Seconds())
+	})
+	return collector
+}
+
+func (p *prometheusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hitsDesc
+	ch <- missesDesc
+	ch <- evictionsDesc
+	ch <- promotionsDesc
+	ch <- thrashesDesc
+	ch <- usageDesc
+	ch <- capacityDesc
+	ch <- circuitOpenDesc
+	p.latency.Describe(ch)
+}
+
+func (p *prometheusCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := p.cache.GetCacheStats()
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(stats.MemoryHits), "memory")
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(stats.DiskHits), "disk")
+	ch <- prometheus.MustNewConstMetric(hitsDesc, prometheus.CounterValue, float64(stats.RemoteHits), "remote")
+	ch <- prometheus.MustNewConstMetric(missesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(stats.MemoryEvictions), "memory")
+	ch <- prometheus.MustNewConstMetric(evictionsDesc, prometheus.CounterValue, float64(stats.DiskEvictions), "disk")
+	ch <- prometheus.MustNewConstMetric(promotionsDesc, prometheus.CounterValue, float64(stats.Promotions))
+	ch <- prometheus.MustNewConstMetric(thrashesDesc, prometheus.CounterValue, float64(stats.Thrashes))
+
+	for tier, store := range map[string]Store{
+		"memory": p.cache.MemoryStore(),
+		"disk":   p.cache.DiskStore(),
+		"remote": p.cache.RemoteStore(),
+	} {
+		ch <- prometheus.MustNewConstMetric(usageDesc, prometheus.GaugeValue, float64(store.GetUsage()), tier)
+		ch <- prometheus.MustNewConstMetric(capacityDesc, prometheus.GaugeValue, float64(store.GetCapacity()), tier)
+	}
+
+	if breaker, ok := p.cache.RemoteStore().(interface{ CircuitOpen() bool }); ok {
+		open := 0.0
+		if breaker.CircuitOpen() {
+			open = 1
+		}
+		ch <- prometheus.MustNewConstMetric(circuitOpenDesc, prometheus.GaugeValue, open)
+	}
+
+	p.latency.Collect(ch)
+}
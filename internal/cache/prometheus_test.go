@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func gatherMetrics(t *testing.T, reg *prometheus.Registry) map[string]*dto.MetricFamily {
+	t.Helper()
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Failed to gather metrics: %v", err)
+	}
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	return byName
+}
+
+func counterForTier(family *dto.MetricFamily, tier string) float64 {
+	for _, metric := range family.GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "tier" && label.GetValue() == tier {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return -1
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(100), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(NewPrometheusCollector(c)); err != nil {
+		t.Fatalf("Failed to register collector: %v", err)
+	}
+
+	ctx := context.Background()
+	c.Set(ctx, "key1", []byte("value1"))
+	c.Get(ctx, "key1")
+	c.Get(ctx, "nonexistent")
+
+	families := gatherMetrics(t, reg)
+	for _, name := range []string{
+		"cache_hits_total",
+		"cache_misses_total",
+		"cache_store_usage_bytes",
+		"cache_store_capacity_bytes",
+		"cache_operation_duration_seconds",
+	} {
+		if _, ok := families[name]; !ok {
+			t.Errorf("Expected metric %s to be exposed", name)
+		}
+	}
+
+	if got := counterForTier(families["cache_hits_total"], "memory"); got != 1 {
+		t.Errorf("Unexpected memory hits. Got %v, want 1", got)
+	}
+	if got := families["cache_misses_total"].GetMetric()[0].GetCounter().GetValue(); got != 1 {
+		t.Errorf("Unexpected misses. Got %v, want 1", got)
+	}
+
+	c.Get(ctx, "key1")
+	families = gatherMetrics(t, reg)
+	if got := counterForTier(families["cache_hits_total"], "memory"); got != 2 {
+		t.Errorf("Expected memory hits to advance to 2, got %v", got)
+	}
+
+	var observations uint64
+	for _, metric := range families["cache_operation_duration_seconds"].GetMetric() {
+		observations += metric.GetHistogram().GetSampleCount()
+	}
+	if observations != 4 {
+		t.Errorf("Expected 4 latency observations, got %d", observations)
+	}
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// PubSub is implemented by remote tiers that can broadcast messages to every
+// cache sharing them. RemoteStore implements it with Redis PUBLISH and
+// SUBSCRIBE.
+type PubSub interface {
+	Publish(ctx context.Context, channel, message string) error
+	// Subscribe calls fn with every message published to channel until
+	// the returned function is called.
+	Subscribe(channel string, fn func(message string)) (unsubscribe func(), err error)
+}
+
+// invalidator publishes the keys a cache writes or deletes and drops the
+// keys other caches publish from its local tiers.
+type invalidator struct {
+	bus     PubSub
+	channel string
+	// node tags this cache's messages so it ignores its own.
+	node        string
+	unsubscribe func()
+}
+
+// subscribeInvalidations starts listening on channel of the remote tier.
+func (c *MultiTierCache) subscribeInvalidations(channel string) error {
+	bus, ok := c.remoteStore.(PubSub)
+	if !ok {
+		return &CacheError{Op: "subscribe", Tier: TierRemote, Err: ErrPubSubUnsupported}
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return err
+	}
+	// Set before subscribing, since messages may arrive at once.
+	c.invalidator = &invalidator{bus: bus, channel: channel, node: hex.EncodeToString(id[:])}
+	unsubscribe, err := bus.Subscribe(channel, c.handleInvalidation)
+	if err != nil {
+		return &CacheError{Op: "subscribe", Tier: TierRemote, Err: err}
+	}
+	c.invalidator.unsubscribe = unsubscribe
+	return nil
+}
+
+// publishInvalidation tells other caches to drop keys, which are store
+// keys. It must be called without holding c.mu, since delivery may run
+// another cache's handler synchronously. Failures are logged rather than
+// returned: the write itself has succeeded.
+func (c *MultiTierCache) publishInvalidation(ctx context.Context, keys ...string) {
+	if c.invalidator == nil {
+		return
+	}
+	for _, key := range keys {
+		err := c.invalidator.bus.Publish(ctx, c.invalidator.channel, c.invalidator.node+" "+key)
+		if err != nil {
+			c.logger.Warn("publishing invalidation failed", "op", "publish", "key", key, "err", err)
+		}
+	}
+}
+
+// handleInvalidation drops the key in message from the memory and disk
+// tiers, unless this cache published it.
+func (c *MultiTierCache) handleInvalidation(message string) {
+	node, key, ok := strings.Cut(message, " ")
+	if !ok || node == c.invalidator.node {
+		return
+	}
+	c.noteRemoteKey(key)
+
+	ctx := context.Background()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.writeBack != nil {
+		c.writeBack.forget(key)
+	}
+	c.dirty.remove(key)
+	c.memoryStore.Delete(ctx, key)
+	c.recordMemoryRemoval(key)
+	c.diskStore.Delete(ctx, key)
+}
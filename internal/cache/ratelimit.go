@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitPolicy decides what the remote tier does with a command over
+// its rate limit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitShed fails the command at once with ErrRateLimited. The
+	// cache treats a shed Get as a miss.
+	RateLimitShed RateLimitPolicy = iota
+	// RateLimitWait makes the command wait for its turn, or until its
+	// context is done. The cache calls the remote tier under its write
+	// lock, so a waiting Set stalls every other cache operation too.
+	RateLimitWait
+)
+
+// rateLimiter is a token bucket holding up to burst tokens, refilled at
+// rate tokens a second. Each command takes one. A nil rateLimiter lets
+// everything through.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	policy RateLimitPolicy
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing rate commands a second in
+// bursts of up to burst, at least 1, or nil if rate isn't positive.
+func newRateLimiter(rate float64, burst int, policy RateLimitPolicy) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	b := float64(max(burst, 1))
+	return &rateLimiter{rate: rate, burst: b, policy: policy, tokens: b, last: time.Now()}
+}
+
+// wait takes a token for one command. Under RateLimitWait it reserves the
+// next token to come and sleeps until then, giving it back if ctx is done
+// first; under RateLimitShed it fails with ErrRateLimited when the bucket
+// is empty.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.mu.Unlock()
+		return nil
+	}
+	if l.policy == RateLimitShed {
+		l.mu.Unlock()
+		return ErrRateLimited
+	}
+	// Tokens owed to earlier waiters push this one further back.
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
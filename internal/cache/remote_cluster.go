@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRemoteStoreCluster creates a RemoteStore backed by the Redis Cluster
+// reachable at addrs, any subset of its nodes. Connection settings come
+// from cfg; its Addr and DB are ignored, since a cluster only has database
+// 0. Keys are routed to the node owning their slot, while Keys, GetAll,
+// Clear and GetMetrics fan out to every master. Multi-key reads and
+// deletes are pipelined key by key, since a cluster rejects commands
+// spanning slots. As with NewRemoteStore, SIMULATE_REMOTE_STORE=true
+// returns a simulated store instead.
+func NewRemoteStoreCluster(addrs []string, cfg RemoteStoreConfig) (*RemoteStore, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	if simulateRemote() {
+		return newSimulatedRemoteStore(cfg, logger), nil
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("redis cluster needs at least one address")
+	}
+	client := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:       addrs,
+		Password:    cfg.Password,
+		TLSConfig:   cfg.TLSConfig,
+		DialTimeout: cfg.DialTimeout,
+
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+		MaxRetries:      cfg.MaxRetries,
+		MinRetryBackoff: cfg.MinRetryBackoff,
+		MaxRetryBackoff: cfg.MaxRetryBackoff,
+	})
+	s := newRemoteStore(client, cfg, logger)
+	s.cluster = client
+	if err := s.Ping(context.Background()); err != nil {
+		client.Close()
+		return nil, err
+	}
+	logger.Info("connected to remote store cluster", "addrs", addrs)
+	return s, nil
+}
+
+// nodes returns the clients to send keyspace-wide commands to: every
+// master of a cluster, or the one client otherwise.
+func (s *RemoteStore) nodes(ctx context.Context) ([]redis.Cmdable, error) {
+	if s.cluster == nil {
+		return []redis.Cmdable{s.client}, nil
+	}
+	var mu sync.Mutex
+	var nodes []redis.Cmdable
+	err := s.cluster.ForEachMaster(ctx, func(_ context.Context, node *redis.Client) error {
+		mu.Lock()
+		defer mu.Unlock()
+		nodes = append(nodes, node)
+		return nil
+	})
+	return nodes, err
+}
+
+// forEachNode calls fn with each of nodes in turn, stopping at the first
+// error.
+func (s *RemoteStore) forEachNode(ctx context.Context, fn func(ctx context.Context, node redis.Cmdable) error) error {
+	nodes, err := s.nodes(ctx)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if err := fn(ctx, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mget reads redisKeys with the TTLs they have left, with nil for missing
+// keys. MGET can't return TTLs, so each key is read with GET and PTTL in a
+// single pipeline, which a cluster client splits between the masters.
+func (s *RemoteStore) mget(ctx context.Context, redisKeys []string) ([]*remoteValue, error) {
+	gets := make([]*redis.StringCmd, len(redisKeys))
+	ttls := make([]*redis.DurationCmd, len(redisKeys))
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range redisKeys {
+			gets[i] = pipe.Get(ctx, key)
+			ttls[i] = pipe.PTTL(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	now := s.clock.Now()
+	values := make([]*remoteValue, len(redisKeys))
+	for i := range redisKeys {
+		data, err := gets[i].Bytes()
+		if err != nil {
+			continue
+		}
+		// PTTL reports -1 for a key without a TTL and -2 for one that
+		// expired since the GET.
+		ttl := ttls[i].Val()
+		switch {
+		case ttl == -2:
+			continue
+		case ttl < 0:
+			values[i] = &remoteValue{data: data}
+		default:
+			values[i] = &remoteValue{data: data, expiresAt: now.Add(ttl)}
+		}
+	}
+	return values, nil
+}
+
+// del deletes redisKeys like DEL, returning how many existed.
+func (s *RemoteStore) del(ctx context.Context, redisKeys []string) (int64, error) {
+	if s.cluster == nil {
+		return s.client.Del(ctx, redisKeys...).Result()
+	}
+	cmds := make([]*redis.IntCmd, len(redisKeys))
+	_, err := s.cluster.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range redisKeys {
+			cmds[i] = pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, cmd := range cmds {
+		n += cmd.Val()
+	}
+	return n, nil
+}
@@ -1,113 +1,812 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// scanBatchSize is the COUNT hint for SCAN and the batch size for DEL and
+// MGET when walking the keyspace.
+const scanBatchSize = 100
+
 type RemoteStore struct {
-	simulate    bool
-	client      *redis.Client
-	simulateMap map[string][]byte
+	simulate bool
+	client   redis.UniversalClient
+	// cluster is set when client talks to a Redis Cluster, whose
+	// keyspace commands have to be sent to every master.
+	cluster     *redis.ClusterClient
+	keyPrefix   string
+	simulateMap map[string]remoteValue
 	mu          sync.RWMutex
+	// simulateDelay is added to every simulated command, so tests can
+	// stand in for a slow server.
+	simulateDelay time.Duration
+	// simulateErr, if set, fails every simulated Get, Set, Delete,
+	// DeleteMulti, Increment, CompareAndSwap and Ping, so tests can stand
+	// in for an unreachable server.
+	simulateErr error
+	logger      Logger
+	clock       Clock
+	// subscribers holds the simulated pub/sub handlers by channel.
+	subscribers    map[string]map[int]func(string)
+	nextSubscriber int
+
+	breaker *circuitBreaker
+	// limiter throttles Sets, and Gets if limitGets is set; nil means no
+	// limit.
+	limiter   *rateLimiter
+	limitGets bool
+
+	getLatency, setLatency, deleteLatency latencyEWMA
 }
 
 type StoreMetrics struct {
 	Capacity     int64   // in bytes
 	Usage        int64   // in bytes
 	UsagePercent float64 // percentage of capacity used
+	KeyCount     int64   // keys in the selected database, from DBSIZE
+	// KeyspaceHits and KeyspaceMisses are the server-wide lookup counters
+	// from INFO stats. They are zero in simulate mode.
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+}
+
+// RemoteMetrics extends StoreMetrics with moving averages of how long Get,
+// Set and Delete take, so a slow Redis shows up before it times out, and
+// whether the circuit breaker is open.
+type RemoteMetrics struct {
+	StoreMetrics
+	GetLatency    time.Duration
+	SetLatency    time.Duration
+	DeleteLatency time.Duration
+	CircuitOpen   bool
+}
+
+// latencyEWMAWeight is the weight a new sample gets in latencyEWMA.
+const latencyEWMAWeight = 0.2
+
+// latencyEWMA is an exponentially weighted moving average of durations.
+type latencyEWMA struct {
+	mu     sync.Mutex
+	value  float64
+	seeded bool
+}
+
+// since records the time elapsed since start.
+func (l *latencyEWMA) since(start time.Time) {
+	d := float64(time.Since(start))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.seeded {
+		l.value, l.seeded = d, true
+		return
+	}
+	l.value += latencyEWMAWeight * (d - l.value)
+}
+
+func (l *latencyEWMA) get() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Duration(l.value)
+}
+
+// RemoteStoreConfig holds the connection settings for the Redis tier.
+type RemoteStoreConfig struct {
+	Addr        string
+	Password    string
+	DB          int
+	TLSConfig   *tls.Config
+	DialTimeout time.Duration
+	// ReadTimeout and WriteTimeout bound each command's socket reads and
+	// writes, so a hung server fails the call even when the caller's
+	// context has no deadline. Zero uses the go-redis default of 3s.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	// MaxRetries is how many times a command is retried after a transient
+	// network error such as a connection reset, waiting between
+	// MinRetryBackoff and MaxRetryBackoff with exponential backoff. Misses
+	// (redis.Nil) are never retried. Zero uses the go-redis default of 3;
+	// a negative value disables retries.
+	MaxRetries      int
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	// BreakerThreshold is the number of consecutive failed commands after
+	// which the store stops calling Redis for BreakerCooldown, treating Get
+	// as a miss and Set as a no-op so the cache keeps serving from its
+	// local tiers. Deletes, increments and compare-and-swaps fail with
+	// ErrCircuitOpen. Zero disables the breaker; a zero cooldown uses
+	// DefaultBreakerCooldown.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// RateLimit caps the Sets sent to Redis at this many a second, in
+	// bursts of up to RateBurst (at least 1), so a burst of writes can't
+	// overwhelm it; zero disables the limit. With RateLimitGets, Gets draw
+	// on the same budget. RateLimitPolicy chooses whether commands over
+	// the limit are shed with ErrRateLimited, the default, or wait their
+	// turn.
+	RateLimit       float64
+	RateBurst       int
+	RateLimitGets   bool
+	RateLimitPolicy RateLimitPolicy
+	// KeyPrefix is prepended to every key the store writes to Redis,
+	// marking the keys it owns so Clear can remove them without touching
+	// anything else in the database. Keys aren't prefixed by default, and
+	// Clear then removes every key in the database.
+	KeyPrefix string
+	// Logger receives connection events, failures and, at debug level,
+	// every simulated command. Defaults to discarding them.
+	Logger Logger
+	// Clock is used to turn entries' expiry times into Redis TTLs.
+	// Defaults to the real clock.
+	Clock Clock
 }
 
 func NewRemoteStore(addr string) (*RemoteStore, error) {
-	simulate, ok := os.LookupEnv("SIMULATE_REMOTE_STORE")
-	if ok && simulate == "true" {
-		log.Println("Simulating remote store connection")
-		return &RemoteStore{
-			simulate:    true,
-			simulateMap: make(map[string][]byte),
-		}, nil
+	return NewRemoteStoreWithConfig(RemoteStoreConfig{Addr: addr})
+}
+
+func NewRemoteStoreWithConfig(cfg RemoteStoreConfig) (*RemoteStore, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = nopLogger{}
+	}
+	if simulateRemote() {
+		return newSimulatedRemoteStore(cfg, logger), nil
 	}
 	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "", // no password set
-		DB:       0,  // use default DB
+		Addr:        cfg.Addr,
+		Password:    cfg.Password,
+		DB:          cfg.DB,
+		TLSConfig:   cfg.TLSConfig,
+		DialTimeout: cfg.DialTimeout,
+
+		ReadTimeout:     cfg.ReadTimeout,
+		WriteTimeout:    cfg.WriteTimeout,
+		MaxRetries:      cfg.MaxRetries,
+		MinRetryBackoff: cfg.MinRetryBackoff,
+		MaxRetryBackoff: cfg.MaxRetryBackoff,
 	})
 	_, err := client.Ping(context.Background()).Result()
 	if err != nil {
 		return nil, err
 	}
-	log.Println("Connected to remote store")
-	return &RemoteStore{client: client}, nil
+	logger.Info("connected to remote store", "addr", cfg.Addr)
+	return newRemoteStore(client, cfg, logger), nil
+}
+
+// simulateRemote reports whether SIMULATE_REMOTE_STORE asks for remote
+// stores to be simulated in memory instead of connecting to Redis.
+func simulateRemote() bool {
+	simulate, ok := os.LookupEnv("SIMULATE_REMOTE_STORE")
+	return ok && simulate == "true"
+}
+
+func newSimulatedRemoteStore(cfg RemoteStoreConfig, logger Logger) *RemoteStore {
+	logger.Info("simulating remote store connection")
+	return &RemoteStore{
+		simulate:    true,
+		logger:      logger,
+		clock:       clockOrReal(cfg.Clock),
+		simulateMap: make(map[string]remoteValue),
+		breaker:     newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		limiter:     newRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.RateLimitPolicy),
+		limitGets:   cfg.RateLimitGets,
+	}
+}
+
+// remoteValue is a value held in Redis, or by a simulated store, with the
+// time its TTL runs out, zero if it has none.
+type remoteValue struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// entry returns the entry for key holding v. Redis keeps a value through
+// its whole stale window and can't tell where the expiry fell within it, so
+// the entry expires when Redis would drop the value.
+func (v *remoteValue) entry(key string) *CacheEntry {
+	entry := remoteEntry(key, v.data)
+	entry.ExpiresAt = v.expiresAt
+	return entry
+}
+
+// expiringValue returns data held for ttl from now, or forever if ttl is
+// zero.
+func (s *RemoteStore) expiringValue(data []byte, ttl time.Duration) remoteValue {
+	v := remoteValue{data: data}
+	if ttl > 0 {
+		v.expiresAt = s.clock.Now().Add(ttl)
+	}
+	return v
+}
+
+// simulated returns the simulated value at key unless its TTL has run out,
+// as Redis would. The caller holds s.mu.
+func (s *RemoteStore) simulated(key string) (remoteValue, bool) {
+	v, ok := s.simulateMap[key]
+	if !ok || !v.expiresAt.IsZero() && !s.clock.Now().Before(v.expiresAt) {
+		return remoteValue{}, false
+	}
+	return v, true
+}
+
+func newRemoteStore(client redis.UniversalClient, cfg RemoteStoreConfig, logger Logger) *RemoteStore {
+	return &RemoteStore{
+		client:    client,
+		logger:    logger,
+		clock:     clockOrReal(cfg.Clock),
+		keyPrefix: cfg.KeyPrefix,
+		breaker:   newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		limiter:   newRateLimiter(cfg.RateLimit, cfg.RateBurst, cfg.RateLimitPolicy),
+		limitGets: cfg.RateLimitGets,
+	}
 }
 
 func (s *RemoteStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	if !s.breaker.allow() {
+		return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: ErrKeyNotFound}
+	}
+	if s.limitGets {
+		if err := s.limiter.wait(ctx); err != nil {
+			return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: err}
+		}
+	}
+	defer s.getLatency.since(time.Now())
+	entry, err := s.get(ctx, key)
+	s.breaker.record(err)
+	return entry, err
+}
+
+func (s *RemoteStore) get(ctx context.Context, key string) (*CacheEntry, error) {
 	if s.simulate {
+		time.Sleep(s.simulateDelay)
+		if s.simulateErr != nil {
+			return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: s.simulateErr}
+		}
 		s.mu.RLock()
 		defer s.mu.RUnlock()
-		if val, ok := s.simulateMap[key]; ok {
-			log.Println("Simulating GET request to remote store")
-			return &CacheEntry{Key: key, Value: val}, nil
+		if v, ok := s.simulated(key); ok {
+			s.logger.Debug("simulated remote command", "op", "get", "key", key)
+			return v.entry(key), nil
 		}
-		return nil, redis.Nil
+		return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: ErrKeyNotFound}
+	}
+	values, err := s.mget(ctx, []string{s.redisKey(key)})
+	if err == nil && values[0] == nil {
+		err = ErrKeyNotFound
 	}
-	val, err := s.client.Get(ctx, key).Result()
 	if err != nil {
-		return nil, err
+		return nil, &CacheError{Op: "get", Tier: TierRemote, Key: key, Err: err}
 	}
-	return &CacheEntry{Key: key, Value: []byte(val)}, nil
+	return values[0].entry(key), nil
+}
+
+// GetMulti reads keys with a single MGET. Keys that don't exist are left
+// out of the result.
+func (s *RemoteStore) GetMulti(ctx context.Context, keys []string) (map[string]*CacheEntry, error) {
+	if len(keys) == 0 || !s.breaker.allow() {
+		return map[string]*CacheEntry{}, nil
+	}
+	entries, err := s.getMulti(ctx, keys)
+	s.breaker.record(err)
+	return entries, err
+}
+
+func (s *RemoteStore) getMulti(ctx context.Context, keys []string) (map[string]*CacheEntry, error) {
+	entries := make(map[string]*CacheEntry, len(keys))
+	if s.simulate {
+		if s.simulateErr != nil {
+			return nil, &CacheError{Op: "get", Tier: TierRemote, Err: s.simulateErr}
+		}
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		s.logger.Debug("simulated remote command", "op", "mget", "keys", len(keys))
+		for _, key := range keys {
+			if v, ok := s.simulated(key); ok {
+				entries[key] = v.entry(key)
+			}
+		}
+		return entries, nil
+	}
+
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = s.redisKey(key)
+	}
+	values, err := s.mget(ctx, redisKeys)
+	if err != nil {
+		return nil, &CacheError{Op: "get", Tier: TierRemote, Err: err}
+	}
+	for i, v := range values {
+		if v != nil {
+			entries[keys[i]] = v.entry(keys[i])
+		}
+	}
+	return entries, nil
+}
+
+func (s *RemoteStore) Has(ctx context.Context, key string) bool {
+	if !s.breaker.allow() {
+		return false
+	}
+	if s.simulate {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, ok := s.simulated(key)
+		return ok
+	}
+	n, err := s.client.Exists(ctx, s.redisKey(key)).Result()
+	s.breaker.record(err)
+	return err == nil && n > 0
 }
 
 func (s *RemoteStore) Set(ctx context.Context, entry *CacheEntry) error {
+	if !s.breaker.allow() {
+		return nil
+	}
+	if err := s.limiter.wait(ctx); err != nil {
+		return &CacheError{Op: "set", Tier: TierRemote, Key: entry.Key, Err: err}
+	}
+	defer s.setLatency.since(time.Now())
+	err := s.set(ctx, entry)
+	s.breaker.record(err)
+	return err
+}
+
+func (s *RemoteStore) set(ctx context.Context, entry *CacheEntry) error {
+	data, err := encodeRemoteValue(entry)
+	if err != nil {
+		return err
+	}
+	ttl, ok := redisTTL(entry, s.clock.Now())
 	if s.simulate {
+		time.Sleep(s.simulateDelay)
+		if s.simulateErr != nil {
+			return s.simulateErr
+		}
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		log.Println("Simulating SET request to remote store")
-		s.simulateMap[entry.Key] = entry.Value
+		s.logger.Debug("simulated remote command", "op", "set", "key", entry.Key)
+		if ok {
+			s.simulateMap[entry.Key] = s.expiringValue(data, ttl)
+		}
+		return nil
+	}
+	if !ok {
+		return nil
+	}
+	return s.client.Set(ctx, s.redisKey(entry.Key), data, ttl).Err()
+}
+
+// redisTTL returns the TTL to store entry with in Redis at now, zero
+// meaning none, or false if it has already expired.
+func redisTTL(entry *CacheEntry, now time.Time) (time.Duration, bool) {
+	expiresAt := entry.ExpiresAt
+	if expiresAt.IsZero() {
+		return 0, true
+	}
+	// Redis can't tell a stale value from a fresh one, so keep it for the
+	// whole stale window.
+	if entry.StaleUntil.After(expiresAt) {
+		expiresAt = entry.StaleUntil
+	}
+	ttl := expiresAt.Sub(now)
+	return ttl, ttl > 0
+}
+
+// SetIfAbsent stores entry with SET NX, reporting false if the key already
+// exists.
+func (s *RemoteStore) SetIfAbsent(ctx context.Context, entry *CacheEntry) (bool, error) {
+	if !s.breaker.allow() {
+		return false, ErrCircuitOpen
+	}
+	defer s.setLatency.since(time.Now())
+	set, err := s.setIfAbsent(ctx, entry)
+	s.breaker.record(err)
+	return set, err
+}
+
+func (s *RemoteStore) setIfAbsent(ctx context.Context, entry *CacheEntry) (bool, error) {
+	data, err := encodeRemoteValue(entry)
+	if err != nil {
+		return false, err
+	}
+	ttl, ok := redisTTL(entry, s.clock.Now())
+	if s.simulate {
+		time.Sleep(s.simulateDelay)
+		if s.simulateErr != nil {
+			return false, s.simulateErr
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.logger.Debug("simulated remote command", "op", "setnx", "key", entry.Key)
+		if _, exists := s.simulated(entry.Key); exists || !ok {
+			return false, nil
+		}
+		s.simulateMap[entry.Key] = s.expiringValue(data, ttl)
+		return true, nil
+	}
+	if !ok {
+		return false, nil
+	}
+	return s.client.SetNX(ctx, s.redisKey(entry.Key), data, ttl).Result()
+}
+
+// Increment adjusts the integer at key with INCRBY. Redis creates missing
+// keys at zero and keeps any existing TTL.
+func (s *RemoteStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if !s.breaker.allow() {
+		return 0, ErrCircuitOpen
+	}
+	n, err := s.increment(ctx, key, delta)
+	s.breaker.record(err)
+	return n, err
+}
+
+func (s *RemoteStore) increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if s.simulate {
+		if s.simulateErr != nil {
+			return 0, s.simulateErr
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		v, ok := s.simulated(key)
+		if !ok {
+			v = remoteValue{data: []byte("0")}
+		}
+		n, err := incrementValue(v.data, delta)
+		if err != nil {
+			return 0, &CacheError{Op: "increment", Tier: TierRemote, Key: key, Err: err}
+		}
+		v.data = []byte(strconv.FormatInt(n, 10))
+		s.simulateMap[key] = v
+		return n, nil
+	}
+	n, err := s.client.IncrBy(ctx, s.redisKey(key), delta).Result()
+	if err != nil {
+		if msg := err.Error(); strings.Contains(msg, "not an integer") || strings.Contains(msg, "overflow") {
+			err = ErrNotInteger
+		}
+		return 0, &CacheError{Op: "increment", Tier: TierRemote, Key: key, Err: err}
+	}
+	return n, nil
+}
+
+// casScript sets the value of KEYS[1] to ARGV[2] only if it currently is
+// ARGV[1], keeping its TTL. A stored value starting with ARGV[3], the
+// metadataMagic, is compared without its metadata, which is kept, as
+// remoteEntry and encodeRemoteValue split and join them.
+var casScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if not current then
+	return 0
+end
+local magic, prefix, value = ARGV[3], "", current
+if string.sub(current, 1, #magic) == magic then
+	local n, scale, i = 0, 1, #magic + 1
+	local byte = string.byte(current, i)
+	while byte and byte >= 128 do
+		n = n + (byte - 128) * scale
+		scale = scale * 128
+		i = i + 1
+		byte = string.byte(current, i)
+	end
+	if byte then
+		n = n + byte * scale
+		if i + n <= #current and pcall(cjson.decode, string.sub(current, i + 1, i + n)) then
+			prefix, value = string.sub(current, 1, i + n), string.sub(current, i + n + 1)
+		end
+	end
+end
+if value ~= ARGV[1] then
+	return 0
+end
+redis.call("SET", KEYS[1], prefix .. ARGV[2], "KEEPTTL")
+return 1
+`)
+
+func (s *RemoteStore) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	if !s.breaker.allow() {
+		return false, ErrCircuitOpen
+	}
+	swapped, err := s.compareAndSwap(ctx, key, old, new)
+	s.breaker.record(err)
+	return swapped, err
+}
+
+func (s *RemoteStore) compareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	if s.simulate {
+		if s.simulateErr != nil {
+			return false, s.simulateErr
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		v, ok := s.simulated(key)
+		if !ok {
+			return false, nil
+		}
+		current := remoteEntry(key, v.data)
+		if current.Object != nil || !bytes.Equal(current.Value, old) {
+			return false, nil
+		}
+		v.data, _ = encodeRemoteValue(&CacheEntry{Value: new, Metadata: current.Metadata})
+		s.simulateMap[key] = v
+		return true, nil
+	}
+	n, err := casScript.Run(ctx, s.client, []string{s.redisKey(key)}, old, new, metadataMagic).Int()
+	if err != nil {
+		return false, &CacheError{Op: "cas", Tier: TierRemote, Key: key, Err: err}
+	}
+	return n == 1, nil
+}
+
+// Expire sets the TTL of key with EXPIRE, or removes it with PERSIST when
+// expiresAt is zero. As with Set, the TTL covers the stale window.
+func (s *RemoteStore) Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	if !s.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	defer s.setLatency.since(time.Now())
+	err := s.expire(ctx, key, expiresAt, staleUntil)
+	s.breaker.record(err)
+	return err
+}
+
+func (s *RemoteStore) expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	notFound := &CacheError{Op: "expire", Tier: TierRemote, Key: key, Err: ErrKeyNotFound}
+	ttl, ok := redisTTL(&CacheEntry{ExpiresAt: expiresAt, StaleUntil: staleUntil}, s.clock.Now())
+	if s.simulate {
+		time.Sleep(s.simulateDelay)
+		if s.simulateErr != nil {
+			return s.simulateErr
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		v, exists := s.simulated(key)
+		switch {
+		case !exists:
+			return notFound
+		case !ok:
+			delete(s.simulateMap, key)
+		default:
+			s.simulateMap[key] = s.expiringValue(v.data, ttl)
+		}
 		return nil
 	}
-	return s.client.Set(ctx, entry.Key, entry.Value, 0).Err()
+
+	var set bool
+	var err error
+	switch {
+	case !ok:
+		// Already past its expiry: drop it as Redis would.
+		return s.client.Del(ctx, s.redisKey(key)).Err()
+	case ttl == 0:
+		set, err = s.client.Persist(ctx, s.redisKey(key)).Result()
+		if err == nil && !set {
+			// PERSIST also reports false for a key without a TTL.
+			var n int64
+			n, err = s.client.Exists(ctx, s.redisKey(key)).Result()
+			set = n > 0
+		}
+	default:
+		set, err = s.client.Expire(ctx, s.redisKey(key), ttl).Result()
+	}
+	if err != nil {
+		return &CacheError{Op: "expire", Tier: TierRemote, Key: key, Err: err}
+	}
+	if !set {
+		return notFound
+	}
+	return nil
 }
 
 func (s *RemoteStore) Delete(ctx context.Context, key string) error {
+	if !s.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	defer s.deleteLatency.since(time.Now())
+	err := s.delete(ctx, key)
+	s.breaker.record(err)
+	return err
+}
+
+func (s *RemoteStore) delete(ctx context.Context, key string) error {
 	if s.simulate {
+		time.Sleep(s.simulateDelay)
+		if s.simulateErr != nil {
+			return s.simulateErr
+		}
 		s.mu.Lock()
 		defer s.mu.Unlock()
 		delete(s.simulateMap, key)
 		return nil
 	}
-	return s.client.Del(ctx, key).Err()
+	return s.client.Del(ctx, s.redisKey(key)).Err()
+}
+
+// Publish sends message to every subscriber of channel. Simulated
+// messages are delivered synchronously, before Publish returns.
+func (s *RemoteStore) Publish(ctx context.Context, channel, message string) error {
+	if s.simulate {
+		s.mu.RLock()
+		fns := make([]func(string), 0, len(s.subscribers[channel]))
+		for _, fn := range s.subscribers[channel] {
+			fns = append(fns, fn)
+		}
+		s.mu.RUnlock()
+		for _, fn := range fns {
+			fn(message)
+		}
+		return nil
+	}
+	return s.client.Publish(ctx, channel, message).Err()
+}
+
+// Subscribe calls fn with each message published to channel until the
+// returned function is called. Messages from Redis are delivered on a
+// background goroutine.
+func (s *RemoteStore) Subscribe(channel string, fn func(message string)) (func(), error) {
+	if s.simulate {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.subscribers == nil {
+			s.subscribers = make(map[string]map[int]func(string))
+		}
+		if s.subscribers[channel] == nil {
+			s.subscribers[channel] = make(map[int]func(string))
+		}
+		id := s.nextSubscriber
+		s.nextSubscriber++
+		s.subscribers[channel][id] = fn
+		return func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.subscribers[channel], id)
+		}, nil
+	}
+
+	ctx := context.Background()
+	pubsub := s.client.Subscribe(ctx, channel)
+	// Wait for the subscription to be confirmed so that no message
+	// published after Subscribe returns is missed.
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+	go func() {
+		for msg := range pubsub.Channel() {
+			fn(msg.Payload)
+		}
+	}()
+	return func() { pubsub.Close() }, nil
+}
+
+// Ping checks that Redis is reachable with PING. It bypasses the circuit
+// breaker, so it probes the server even while the breaker is open. In
+// simulate mode it only fails while simulateErr is set.
+func (s *RemoteStore) Ping(ctx context.Context) error {
+	if s.simulate {
+		return s.simulateErr
+	}
+	return s.forEachNode(ctx, func(ctx context.Context, node redis.Cmdable) error {
+		return node.Ping(ctx).Err()
+	})
+}
+
+// CircuitOpen reports whether the circuit breaker is currently
+// short-circuiting calls to Redis.
+func (s *RemoteStore) CircuitOpen() bool {
+	return s.breaker.open()
+}
+
+// DeleteMulti removes keys with a single DEL and returns how many existed.
+// On a cluster the keys are deleted one by one in a pipeline.
+func (s *RemoteStore) DeleteMulti(ctx context.Context, keys []string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	if !s.breaker.allow() {
+		return 0, ErrCircuitOpen
+	}
+	deleted, err := s.deleteMulti(ctx, keys)
+	s.breaker.record(err)
+	return deleted, err
+}
+
+func (s *RemoteStore) deleteMulti(ctx context.Context, keys []string) (int, error) {
+	if s.simulate {
+		if s.simulateErr != nil {
+			return 0, s.simulateErr
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		deleted := 0
+		for _, key := range keys {
+			if _, ok := s.simulated(key); ok {
+				deleted++
+			}
+			delete(s.simulateMap, key)
+		}
+		return deleted, nil
+	}
+	redisKeys := make([]string, len(keys))
+	for i, key := range keys {
+		redisKeys[i] = s.redisKey(key)
+	}
+	n, err := s.del(ctx, redisKeys)
+	return int(n), err
 }
 
 func (s *RemoteStore) Clear(ctx context.Context) error {
 	if s.simulate {
 		s.mu.Lock()
 		defer s.mu.Unlock()
-		log.Println("Simulating CLEAR request to remote store")
-		s.simulateMap = make(map[string][]byte)
+		s.logger.Debug("simulated remote command", "op", "clear")
+		s.simulateMap = make(map[string]remoteValue)
 		return nil
 	}
-	return s.client.FlushDB(ctx).Err()
+	return s.ClearPrefix(ctx, "")
+}
+
+// ClearPrefix deletes only the store's keys starting with prefix, scanning
+// incrementally instead of flushing the whole database.
+func (s *RemoteStore) ClearPrefix(ctx context.Context, prefix string) error {
+	if s.simulate {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for k := range s.simulateMap {
+			if strings.HasPrefix(k, prefix) {
+				delete(s.simulateMap, k)
+			}
+		}
+		return nil
+	}
+	var delErr error
+	err := s.scanKeys(ctx, escapeGlob(prefix)+"*", func(batch []string) bool {
+		_, delErr = s.del(ctx, batch)
+		return delErr == nil
+	})
+	if err != nil {
+		return err
+	}
+	return delErr
 }
 
 func (s *RemoteStore) Keys(ctx context.Context) []string {
+	return s.KeysMatching(ctx, "*")
+}
+
+// KeysMatching returns the keys matching the glob pattern, which Redis
+// evaluates with SCAN MATCH.
+func (s *RemoteStore) KeysMatching(ctx context.Context, pattern string) []string {
 	if s.simulate {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
-		log.Println("Simulating KEYS request to remote store")
+		s.logger.Debug("simulated remote command", "op", "keys", "pattern", pattern)
 		keys := make([]string, 0, len(s.simulateMap))
 		for k := range s.simulateMap {
-			keys = append(keys, k)
+			if _, ok := s.simulated(k); ok && matchGlob(pattern, k) {
+				keys = append(keys, k)
+			}
 		}
 		return keys
 	}
-	keys, err := s.client.Keys(ctx, "*").Result()
+	keys := []string{}
+	err := s.scanKeys(ctx, pattern, func(batch []string) bool {
+		for _, key := range batch {
+			keys = append(keys, strings.TrimPrefix(key, s.keyPrefix))
+		}
+		return true
+	})
 	if err != nil {
 		return []string{}
 	}
@@ -115,49 +814,163 @@ func (s *RemoteStore) Keys(ctx context.Context) []string {
 }
 
 func (s *RemoteStore) GetAll(ctx context.Context) []*CacheEntry {
+	entries := []*CacheEntry{}
+	s.Iterate(ctx, func(entry *CacheEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+	return entries
+}
+
+// Iterate calls fn for every entry in the store until fn returns false.
+// Against Redis it walks the keyspace with SCAN and fetches values in
+// batches with MGET, so the whole dataset is never held in memory at once.
+// On a cluster every master is scanned in turn.
+func (s *RemoteStore) Iterate(ctx context.Context, fn func(entry *CacheEntry) bool) error {
 	if s.simulate {
 		s.mu.RLock()
-		defer s.mu.RUnlock()
-		log.Println("Simulating GETALL request to remote store")
+		s.logger.Debug("simulated remote command", "op", "getall")
 		entries := make([]*CacheEntry, 0, len(s.simulateMap))
-		for k, v := range s.simulateMap {
-			entries = append(entries, &CacheEntry{Key: k, Value: v})
+		for k := range s.simulateMap {
+			if v, ok := s.simulated(k); ok {
+				entries = append(entries, v.entry(k))
+			}
+		}
+		s.mu.RUnlock()
+
+		for _, entry := range entries {
+			if !fn(entry) {
+				return nil
+			}
 		}
-		return entries
+		return nil
 	}
-	keys, err := s.client.Keys(ctx, "*").Result()
+
+	var mgetErr error
+	err := s.scanKeys(ctx, "*", func(batch []string) bool {
+		values, err := s.mget(ctx, batch)
+		if err != nil {
+			mgetErr = err
+			return false
+		}
+		for i, v := range values {
+			if v == nil {
+				// Deleted or expired between SCAN and the read.
+				continue
+			}
+			if !fn(v.entry(strings.TrimPrefix(batch[i], s.keyPrefix))) {
+				return false
+			}
+		}
+		return true
+	})
 	if err != nil {
-		return []*CacheEntry{}
+		return err
 	}
-	entries := make([]*CacheEntry, 0, len(keys))
-	for _, key := range keys {
-		val, err := s.client.Get(ctx, key).Result()
+	return mgetErr
+}
+
+// scanKeys walks the store's Redis keys matching pattern with SCAN, passing
+// each batch to fn until fn returns false. On a cluster each master's
+// keyspace is scanned in turn. SCAN may return a key more than once, so
+// batches are deduplicated.
+func (s *RemoteStore) scanKeys(ctx context.Context, pattern string, fn func(keys []string) bool) error {
+	nodes, err := s.nodes(ctx)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{})
+	for _, node := range nodes {
+		more, err := s.scanNode(ctx, node, pattern, seen, fn)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanNode is scanKeys for a single node. It reports false once fn has.
+func (s *RemoteStore) scanNode(ctx context.Context, node redis.Cmdable, pattern string, seen map[string]struct{}, fn func(keys []string) bool) (bool, error) {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, escapeGlob(s.keyPrefix)+pattern, scanBatchSize).Result()
 		if err != nil {
-			continue
+			return false, err
+		}
+
+		batch := keys[:0]
+		for _, key := range keys {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			batch = append(batch, key)
+		}
+		if len(batch) > 0 && !fn(batch) {
+			return false, nil
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return true, nil
 		}
-		entries = append(entries, &CacheEntry{Key: key, Value: []byte(val)})
 	}
-	return entries
+}
+
+func (s *RemoteStore) redisKey(key string) string {
+	return s.keyPrefix + key
 }
 
 func (s *RemoteStore) GetMetrics(ctx context.Context) (StoreMetrics, error) {
 	if s.simulate {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
-		usage := int64(0)
-		for _, v := range s.simulateMap {
-			usage += int64(len(v))
+		usage, count := int64(0), int64(0)
+		for k := range s.simulateMap {
+			if v, ok := s.simulated(k); ok {
+				usage += int64(len(v.data))
+				count++
+			}
 		}
 		capacity := int64(1024 * 1024 * 100) // Simulate 100MB capacity
 		return StoreMetrics{
 			Capacity:     capacity,
 			Usage:        usage,
 			UsagePercent: float64(usage) / float64(capacity) * 100,
+			KeyCount:     count,
 		}, nil
 	}
 
+	var total StoreMetrics
+	bounded := true
+	err := s.forEachNode(ctx, func(ctx context.Context, node redis.Cmdable) error {
+		metrics, err := nodeMetrics(ctx, node)
+		if err != nil {
+			return err
+		}
+		bounded = bounded && metrics.Capacity > 0
+		total.Capacity += metrics.Capacity
+		total.Usage += metrics.Usage
+		total.KeyCount += metrics.KeyCount
+		total.KeyspaceHits += metrics.KeyspaceHits
+		total.KeyspaceMisses += metrics.KeyspaceMisses
+		return nil
+	})
+	if err != nil {
+		return StoreMetrics{}, err
+	}
+	// A node without a memory limit makes the whole store unbounded.
+	if !bounded {
+		total.Capacity = 0
+	}
+	total.UsagePercent = float64(total.Usage) / float64(total.Capacity) * 100
+	return total, nil
+}
+
+// nodeMetrics reads the metrics of a single Redis server.
+func nodeMetrics(ctx context.Context, node redis.Cmdable) (StoreMetrics, error) {
 	// Get the maximum memory limit set for Redis
-	maxMemoryConfig, err := s.client.ConfigGet(ctx, "maxmemory").Result()
+	maxMemoryConfig, err := node.ConfigGet(ctx, "maxmemory").Result()
 	if err != nil {
 		return StoreMetrics{}, fmt.Errorf("failed to get maxmemory: %w", err)
 	}
@@ -173,40 +986,91 @@ func (s *RemoteStore) GetMetrics(ctx context.Context) (StoreMetrics, error) {
 	}
 
 	// Get the current memory usage of Redis
-	info, err := s.client.Info(ctx, "memory").Result()
+	info, err := node.Info(ctx, "memory").Result()
 	if err != nil {
 		return StoreMetrics{}, fmt.Errorf("failed to get memory info: %w", err)
 	}
 
-	var usage int64
-	for _, line := range strings.Split(info, "\r\n") {
-		if strings.HasPrefix(line, "used_memory:") {
-			usedMemory := strings.TrimPrefix(line, "used_memory:")
-			usage, err = strconv.ParseInt(usedMemory, 10, 64)
-			if err != nil {
-				return StoreMetrics{}, fmt.Errorf("failed to parse used_memory: %w", err)
-			}
-			break
-		}
+	usedMemory, ok := infoValue(info, "used_memory")
+	if !ok {
+		return StoreMetrics{}, fmt.Errorf("failed to find used_memory in Redis info")
+	}
+	usage, err := strconv.ParseInt(usedMemory, 10, 64)
+	if err != nil {
+		return StoreMetrics{}, fmt.Errorf("failed to parse used_memory: %w", err)
 	}
 
-	if usage == 0 {
-		return StoreMetrics{}, fmt.Errorf("failed to find used_memory in Redis info")
+	keyCount, err := node.DBSize(ctx).Result()
+	if err != nil {
+		return StoreMetrics{}, fmt.Errorf("failed to get key count: %w", err)
+	}
+
+	stats, err := node.Info(ctx, "stats").Result()
+	if err != nil {
+		return StoreMetrics{}, fmt.Errorf("failed to get stats info: %w", err)
+	}
+	hits, misses, err := parseKeyspaceStats(stats)
+	if err != nil {
+		return StoreMetrics{}, err
 	}
 
 	usagePercent := float64(usage) / float64(capacity) * 100
 
 	return StoreMetrics{
-		Capacity:     capacity,
-		Usage:        usage,
-		UsagePercent: usagePercent,
+		Capacity:       capacity,
+		Usage:          usage,
+		UsagePercent:   usagePercent,
+		KeyCount:       keyCount,
+		KeyspaceHits:   hits,
+		KeyspaceMisses: misses,
+	}, nil
+}
+
+// infoValue returns the value of field in the output of INFO.
+func infoValue(info, field string) (string, bool) {
+	for _, line := range strings.Split(info, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// parseKeyspaceStats reads keyspace_hits and keyspace_misses from the
+// output of INFO stats. Missing fields are reported as zero.
+func parseKeyspaceStats(info string) (hits, misses int64, err error) {
+	for field, dst := range map[string]*int64{"keyspace_hits": &hits, "keyspace_misses": &misses} {
+		value, ok := infoValue(info, field)
+		if !ok {
+			continue
+		}
+		if *dst, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("failed to parse %s: %w", field, err)
+		}
+	}
+	return hits, misses, nil
+}
+
+// GetRemoteMetrics returns GetMetrics along with the average latency of
+// recent Get, Set and Delete calls.
+func (s *RemoteStore) GetRemoteMetrics(ctx context.Context) (RemoteMetrics, error) {
+	metrics, err := s.GetMetrics(ctx)
+	if err != nil {
+		return RemoteMetrics{}, err
+	}
+	return RemoteMetrics{
+		StoreMetrics:  metrics,
+		GetLatency:    s.getLatency.get(),
+		SetLatency:    s.setLatency.get(),
+		DeleteLatency: s.deleteLatency.get(),
+		CircuitOpen:   s.CircuitOpen(),
 	}, nil
 }
 
 func (s *RemoteStore) GetCapacity() int {
 	metrics, err := s.GetMetrics(context.Background())
 	if err != nil {
-		log.Printf("Error getting capacity: %v", err)
+		s.logger.Warn("reading remote capacity failed", "op", "capacity", "err", err)
 		return -1
 	}
 	return int(metrics.Capacity)
@@ -215,7 +1079,7 @@ func (s *RemoteStore) GetCapacity() int {
 func (s *RemoteStore) GetUsage() int {
 	metrics, err := s.GetMetrics(context.Background())
 	if err != nil {
-		log.Printf("Error getting usage: %v", err)
+		s.logger.Warn("reading remote usage failed", "op", "usage", "err", err)
 		return 0
 	}
 	return int(metrics.Usage)
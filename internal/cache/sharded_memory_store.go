@@ -0,0 +1,412 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShardedMemoryStore is a memory store split into shards by key hash, each
+// with its own lock, so concurrent operations on different keys rarely
+// contend. Capacity and MaxEntries apply to the store as a whole: shards
+// reserve their usage from shared counters, so evicting any entry makes
+// room for any other. Entries are copied in and out as in MemoryStore.
+type ShardedMemoryStore struct {
+	shards       []*memoryShard
+	capacity     atomic.Int64
+	maxEntries   int
+	maxEntrySize int
+	sizeFunc     SizeFunc
+	clock        Clock
+
+	usage atomic.Int64
+	count atomic.Int64
+	// ticks stamps accesses so LeastRecentlyUsed can compare entries held
+	// by different shards.
+	ticks atomic.Uint64
+}
+
+type memoryShard struct {
+	mu    sync.RWMutex
+	items map[string]*list.Element
+	// order holds *shardItem values, most recently used at the front.
+	order *list.List
+}
+
+type shardItem struct {
+	entry *CacheEntry
+	tick  uint64
+}
+
+// NewShardedMemoryStore creates a store of capacity bytes split into
+// shards shards. Fewer than one shard is treated as one.
+func NewShardedMemoryStore(capacity, shards int, opts MemoryStoreOptions) *ShardedMemoryStore {
+	sizeFunc := opts.SizeFunc
+	if sizeFunc == nil {
+		sizeFunc = DefaultSizeFunc
+	}
+	s := &ShardedMemoryStore{
+		shards:       make([]*memoryShard, max(shards, 1)),
+		maxEntries:   opts.MaxEntries,
+		maxEntrySize: opts.MaxEntrySize,
+		sizeFunc:     sizeFunc,
+		clock:        clockOrReal(opts.Clock),
+	}
+	for i := range s.shards {
+		s.shards[i] = &memoryShard{items: make(map[string]*list.Element), order: list.New()}
+	}
+	s.capacity.Store(int64(capacity))
+	return s
+}
+
+func (s *ShardedMemoryStore) shard(key string) *memoryShard {
+	// Inline FNV-1a, as hash/fnv would allocate on every call.
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return s.shards[h%uint32(len(s.shards))]
+}
+
+func (s *ShardedMemoryStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	return s.get(ctx, key, (*CacheEntry).Clone)
+}
+
+// GetShared is like Get without copying the entry's Value and Metadata;
+// see MemoryStore.GetShared.
+func (s *ShardedMemoryStore) GetShared(ctx context.Context, key string) (*CacheEntry, error) {
+	return s.get(ctx, key, (*CacheEntry).shallow)
+}
+
+func (s *ShardedMemoryStore) get(ctx context.Context, key string, copyEntry func(*CacheEntry) *CacheEntry) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if elem, ok := sh.items[key]; ok {
+		sh.order.MoveToFront(elem)
+		item := elem.Value.(*shardItem)
+		item.tick = s.ticks.Add(1)
+		return copyEntry(item.entry), nil
+	}
+	return nil, &CacheError{Op: "get", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+}
+
+// Peek returns the entry for key without affecting its recency.
+func (s *ShardedMemoryStore) Peek(ctx context.Context, key string) (*CacheEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if elem, ok := sh.items[key]; ok {
+		return elem.Value.(*shardItem).entry.Clone(), nil
+	}
+	return nil, &CacheError{Op: "get", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+}
+
+// Touch records an access to key at the given time, bumping its
+// Frequency.
+func (s *ShardedMemoryStore) Touch(ctx context.Context, key string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	elem, ok := sh.items[key]
+	if !ok {
+		return &CacheError{Op: "touch", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+	}
+	entry := elem.Value.(*shardItem).entry
+	entry.LastAccess = at
+	entry.AccessSeq = nextAccessSeq()
+	entry.Frequency++
+	return nil
+}
+
+// Has reports whether key is present and unexpired without affecting its
+// recency.
+func (s *ShardedMemoryStore) Has(_ context.Context, key string) bool {
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	elem, ok := sh.items[key]
+	return ok && !elem.Value.(*shardItem).entry.expired(s.clock.Now())
+}
+
+func (s *ShardedMemoryStore) Set(ctx context.Context, entry *CacheEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sh := s.shard(entry.Key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return s.set(sh, entry)
+}
+
+// set stores a copy of entry in sh, which the caller has locked, recording
+// the size it is charged in the copy's Size. The caller's entry is left as
+// it was.
+func (s *ShardedMemoryStore) set(sh *memoryShard, entry *CacheEntry) error {
+	size := s.sizeFunc(entry)
+	if s.maxEntrySize > 0 && size > s.maxEntrySize {
+		return ErrEntryTooLarge
+	}
+	delta := int64(size)
+	existing, ok := sh.items[entry.Key]
+	if ok {
+		delta -= int64(existing.Value.(*shardItem).entry.Size)
+	}
+
+	maxEntries := int64(s.maxEntries)
+	if maxEntries <= 0 {
+		maxEntries = math.MaxInt64
+	}
+	if !ok && !reserve(&s.count, 1, maxEntries) {
+		return ErrInsufficientCapacity
+	}
+	if !reserve(&s.usage, delta, s.capacity.Load()) {
+		if !ok {
+			s.count.Add(-1)
+		}
+		return ErrInsufficientCapacity
+	}
+
+	stored := entry.Clone()
+	stored.Size = size
+	item := &shardItem{entry: stored, tick: s.ticks.Add(1)}
+	if ok {
+		existing.Value = item
+		sh.order.MoveToFront(existing)
+	} else {
+		sh.items[entry.Key] = sh.order.PushFront(item)
+	}
+	return nil
+}
+
+// reserve adds delta to counter unless that would take it above limit.
+func reserve(counter *atomic.Int64, delta, limit int64) bool {
+	for {
+		cur := counter.Load()
+		if delta > 0 && cur+delta > limit {
+			return false
+		}
+		if counter.CompareAndSwap(cur, cur+delta) {
+			return true
+		}
+	}
+}
+
+func (s *ShardedMemoryStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	now := s.clock.Now()
+	entry := newCounterEntry(key, 0, now)
+	if elem, ok := sh.items[key]; ok && !elem.Value.(*shardItem).entry.expired(now) {
+		entry = elem.Value.(*shardItem).entry.Clone()
+		entry.LastAccess = now
+		entry.AccessSeq = nextAccessSeq()
+		entry.Frequency++
+		entry.Version++
+	}
+
+	n, err := incrementValue(entry.Value, delta)
+	if err != nil {
+		return 0, &CacheError{Op: "increment", Tier: TierMemory, Key: key, Err: err}
+	}
+	entry.Value = []byte(strconv.FormatInt(n, 10))
+	entry.Size = len(entry.Value)
+	if err := s.set(sh, entry); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *ShardedMemoryStore) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	elem, ok := sh.items[key]
+	if !ok {
+		return false, nil
+	}
+	swapped := swapEntry(elem.Value.(*shardItem).entry, old, new, s.clock.Now())
+	if swapped == nil {
+		return false, nil
+	}
+	if err := s.set(sh, swapped); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Expire sets the expiry of the unexpired entry at key in place.
+func (s *ShardedMemoryStore) Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	elem, ok := sh.items[key]
+	if !ok || elem.Value.(*shardItem).entry.expired(s.clock.Now()) {
+		return &CacheError{Op: "expire", Tier: TierMemory, Key: key, Err: ErrKeyNotFound}
+	}
+	entry := elem.Value.(*shardItem).entry
+	entry.ExpiresAt, entry.StaleUntil = expiresAt, staleUntil
+	return nil
+}
+
+func (s *ShardedMemoryStore) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if elem, ok := sh.items[key]; ok {
+		s.usage.Add(-int64(elem.Value.(*shardItem).entry.Size))
+		s.count.Add(-1)
+		sh.order.Remove(elem)
+		delete(sh.items, key)
+	}
+	return nil
+}
+
+func (s *ShardedMemoryStore) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		var usage int64
+		for elem := sh.order.Front(); elem != nil; elem = elem.Next() {
+			usage += int64(elem.Value.(*shardItem).entry.Size)
+		}
+		s.usage.Add(-usage)
+		s.count.Add(-int64(len(sh.items)))
+		sh.items = make(map[string]*list.Element)
+		sh.order.Init()
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *ShardedMemoryStore) GetCapacity() int {
+	return int(s.capacity.Load())
+}
+
+// SetCapacity changes the store's capacity to n bytes. Entries that no
+// longer fit are kept until the caller evicts them.
+func (s *ShardedMemoryStore) SetCapacity(n int) {
+	s.capacity.Store(int64(n))
+}
+
+func (s *ShardedMemoryStore) GetUsage() int {
+	return int(s.usage.Load())
+}
+
+// Len returns the number of entries in the store.
+func (s *ShardedMemoryStore) Len() int {
+	return int(s.count.Load())
+}
+
+func (s *ShardedMemoryStore) MaxEntries() int {
+	return s.maxEntries
+}
+
+func (s *ShardedMemoryStore) Keys(_ context.Context) []string {
+	keys := make([]string, 0, s.Len())
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for k := range sh.items {
+			keys = append(keys, k)
+		}
+		sh.mu.RUnlock()
+	}
+	return keys
+}
+
+func (s *ShardedMemoryStore) GetAll(_ context.Context) []*CacheEntry {
+	entries := make([]*CacheEntry, 0, s.Len())
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for elem := sh.order.Front(); elem != nil; elem = elem.Next() {
+			entries = append(entries, elem.Value.(*shardItem).entry.Clone())
+		}
+		sh.mu.RUnlock()
+	}
+	return entries
+}
+
+// ForEach visits each shard in turn, so entries are only ordered by recency
+// within a shard.
+func (s *ShardedMemoryStore) ForEach(ctx context.Context, fn func(*CacheEntry) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for elem := sh.order.Front(); elem != nil; elem = elem.Next() {
+			if !fn(elem.Value.(*shardItem).entry) {
+				sh.mu.RUnlock()
+				return nil
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return nil
+}
+
+// LeastRecentlyUsed returns the least recently used entry across all
+// shards by comparing the tail of each shard.
+func (s *ShardedMemoryStore) LeastRecentlyUsed() (*CacheEntry, bool) {
+	var oldest *shardItem
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		if elem := sh.order.Back(); elem != nil {
+			if item := elem.Value.(*shardItem); oldest == nil || item.tick < oldest.tick {
+				oldest = &shardItem{entry: item.entry.Clone(), tick: item.tick}
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	if oldest == nil {
+		return nil, false
+	}
+	return oldest.entry, true
+}
@@ -0,0 +1,290 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// ringReplicas is the number of points each node gets on the hash ring.
+// More points spread keys more evenly between nodes.
+const ringReplicas = 128
+
+// ShardedRemoteStore spreads keys across several Redis servers with
+// consistent hashing, so adding or removing a server only moves the keys on
+// its share of the ring. Operations on one key go to its node; listing,
+// clearing and metrics fan out to every node and combine the results.
+type ShardedRemoteStore struct {
+	nodes []*RemoteStore
+	addrs []string
+	// ring holds each node's points sorted by hash.
+	ring []ringPoint
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// NewShardedRemoteStore connects to a RemoteStore for each of cfgs. Nodes
+// are placed on the ring by Addr, which must be distinct, so the same
+// addresses give the same key placement in every process.
+func NewShardedRemoteStore(cfgs []RemoteStoreConfig) (*ShardedRemoteStore, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("sharded remote store needs at least one node")
+	}
+	s := &ShardedRemoteStore{ring: make([]ringPoint, 0, len(cfgs)*ringReplicas)}
+	for i, cfg := range cfgs {
+		if slices.Contains(s.addrs, cfg.Addr) {
+			return nil, fmt.Errorf("duplicate remote address %q", cfg.Addr)
+		}
+		node, err := NewRemoteStoreWithConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", cfg.Addr, err)
+		}
+		s.nodes = append(s.nodes, node)
+		s.addrs = append(s.addrs, cfg.Addr)
+		for r := 0; r < ringReplicas; r++ {
+			s.ring = append(s.ring, ringPoint{hash: ringHash(cfg.Addr + "#" + strconv.Itoa(r)), node: i})
+		}
+	}
+	slices.SortFunc(s.ring, func(a, b ringPoint) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+	return s, nil
+}
+
+// ringHash is 64-bit FNV-1a followed by the MurmurHash3 finalizer, which
+// FNV needs to spread similar strings such as a node's point names.
+func ringHash(s string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// nodeIndex returns the index of the node owning key: the first point on
+// the ring at or after the key's hash, wrapping around.
+func (s *ShardedRemoteStore) nodeIndex(key string) int {
+	h := ringHash(key)
+	i, _ := slices.BinarySearchFunc(s.ring, h, func(p ringPoint, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(s.ring) {
+		i = 0
+	}
+	return s.ring[i].node
+}
+
+func (s *ShardedRemoteStore) node(key string) *RemoteStore {
+	return s.nodes[s.nodeIndex(key)]
+}
+
+// byNode groups keys by the index of the node owning them.
+func (s *ShardedRemoteStore) byNode(keys []string) map[int][]string {
+	groups := make(map[int][]string)
+	for _, key := range keys {
+		i := s.nodeIndex(key)
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+func (s *ShardedRemoteStore) Get(ctx context.Context, key string) (*CacheEntry, error) {
+	return s.node(key).Get(ctx, key)
+}
+
+// GetMulti fetches keys with one MGET per node that owns any of them.
+func (s *ShardedRemoteStore) GetMulti(ctx context.Context, keys []string) (map[string]*CacheEntry, error) {
+	entries := make(map[string]*CacheEntry, len(keys))
+	var errs []error
+	for i, group := range s.byNode(keys) {
+		found, err := s.nodes[i].GetMulti(ctx, group)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for key, entry := range found {
+			entries[key] = entry
+		}
+	}
+	return entries, errors.Join(errs...)
+}
+
+func (s *ShardedRemoteStore) Has(ctx context.Context, key string) bool {
+	return s.node(key).Has(ctx, key)
+}
+
+func (s *ShardedRemoteStore) Set(ctx context.Context, entry *CacheEntry) error {
+	return s.node(entry.Key).Set(ctx, entry)
+}
+
+func (s *ShardedRemoteStore) SetIfAbsent(ctx context.Context, entry *CacheEntry) (bool, error) {
+	return s.node(entry.Key).SetIfAbsent(ctx, entry)
+}
+
+func (s *ShardedRemoteStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	return s.node(key).Increment(ctx, key, delta)
+}
+
+func (s *ShardedRemoteStore) CompareAndSwap(ctx context.Context, key string, old, new []byte) (bool, error) {
+	return s.node(key).CompareAndSwap(ctx, key, old, new)
+}
+
+func (s *ShardedRemoteStore) Expire(ctx context.Context, key string, expiresAt, staleUntil time.Time) error {
+	return s.node(key).Expire(ctx, key, expiresAt, staleUntil)
+}
+
+func (s *ShardedRemoteStore) Delete(ctx context.Context, key string) error {
+	return s.node(key).Delete(ctx, key)
+}
+
+func (s *ShardedRemoteStore) DeleteMulti(ctx context.Context, keys []string) (int, error) {
+	deleted := 0
+	var errs []error
+	for i, group := range s.byNode(keys) {
+		n, err := s.nodes[i].DeleteMulti(ctx, group)
+		deleted += n
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// Clear clears every node, carrying on past failures.
+func (s *ShardedRemoteStore) Clear(ctx context.Context) error {
+	var errs []error
+	for _, node := range s.nodes {
+		if err := node.Clear(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ShardedRemoteStore) ClearPrefix(ctx context.Context, prefix string) error {
+	var errs []error
+	for _, node := range s.nodes {
+		if err := node.ClearPrefix(ctx, prefix); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ShardedRemoteStore) Keys(ctx context.Context) []string {
+	var keys []string
+	for _, node := range s.nodes {
+		keys = append(keys, node.Keys(ctx)...)
+	}
+	return keys
+}
+
+func (s *ShardedRemoteStore) KeysMatching(ctx context.Context, pattern string) []string {
+	var keys []string
+	for _, node := range s.nodes {
+		keys = append(keys, node.KeysMatching(ctx, pattern)...)
+	}
+	return keys
+}
+
+func (s *ShardedRemoteStore) GetAll(ctx context.Context) []*CacheEntry {
+	var entries []*CacheEntry
+	for _, node := range s.nodes {
+		entries = append(entries, node.GetAll(ctx)...)
+	}
+	return entries
+}
+
+// Iterate streams every node's entries in turn, stopping when fn returns
+// false.
+func (s *ShardedRemoteStore) Iterate(ctx context.Context, fn func(entry *CacheEntry) bool) error {
+	stopped := false
+	for _, node := range s.nodes {
+		err := node.Iterate(ctx, func(entry *CacheEntry) bool {
+			stopped = !fn(entry)
+			return !stopped
+		})
+		if err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publish and Subscribe use the first node, so every cache sharing the
+// store sees the same channel.
+func (s *ShardedRemoteStore) Publish(ctx context.Context, channel, message string) error {
+	return s.nodes[0].Publish(ctx, channel, message)
+}
+
+func (s *ShardedRemoteStore) Subscribe(channel string, fn func(message string)) (func(), error) {
+	return s.nodes[0].Subscribe(channel, fn)
+}
+
+// Ping pings every node, returning their errors joined.
+func (s *ShardedRemoteStore) Ping(ctx context.Context) error {
+	var errs []error
+	for _, node := range s.nodes {
+		errs = append(errs, node.Ping(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// CircuitOpen reports whether any node's circuit breaker is open.
+func (s *ShardedRemoteStore) CircuitOpen() bool {
+	return slices.ContainsFunc(s.nodes, (*RemoteStore).CircuitOpen)
+}
+
+// GetMetrics sums the metrics of every node. A node without a memory limit
+// makes the total capacity zero, meaning unbounded.
+func (s *ShardedRemoteStore) GetMetrics(ctx context.Context) (StoreMetrics, error) {
+	var total StoreMetrics
+	unbounded := false
+	for _, node := range s.nodes {
+		m, err := node.GetMetrics(ctx)
+		if err != nil {
+			return StoreMetrics{}, err
+		}
+		unbounded = unbounded || m.Capacity <= 0
+		total.Capacity += m.Capacity
+		total.Usage += m.Usage
+		total.KeyCount += m.KeyCount
+		total.KeyspaceHits += m.KeyspaceHits
+		total.KeyspaceMisses += m.KeyspaceMisses
+	}
+	if unbounded {
+		total.Capacity = 0
+	}
+	if total.Capacity > 0 {
+		total.UsagePercent = float64(total.Usage) / float64(total.Capacity) * 100
+	}
+	return total, nil
+}
+
+func (s *ShardedRemoteStore) GetCapacity() int {
+	metrics, err := s.GetMetrics(context.Background())
+	if err != nil {
+		return -1
+	}
+	return int(metrics.Capacity)
+}
+
+func (s *ShardedRemoteStore) GetUsage() int {
+	metrics, err := s.GetMetrics(context.Background())
+	if err != nil {
+		return 0
+	}
+	return int(metrics.Usage)
+}
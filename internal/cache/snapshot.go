@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+)
+
+// Snapshot gob-encodes the memory tier's unexpired entries, including their
+// expiry and access metadata, to w.
+func (c *MultiTierCache) Snapshot(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now()
+	entries := c.memoryStore.GetAll(context.Background())
+	live := make([]*CacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.expired(now) {
+			live = append(live, entry)
+		}
+	}
+	return gob.NewEncoder(w).Encode(live)
+}
+
+// Restore loads entries written by Snapshot into the memory tier. Entries
+// that have expired since are skipped. If they don't all fit in the current
+// capacity, the policy evicts as it would for ordinary writes.
+func (c *MultiTierCache) Restore(r io.Reader) error {
+	var entries []*CacheEntry
+	if err := gob.NewDecoder(r).Decode(&entries); err != nil {
+		return err
+	}
+
+	defer c.dispatchEvictions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx := context.Background()
+	now := c.clock.Now()
+	// Snapshots list the most recently used entry first; restore oldest
+	// first so recency order survives.
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.expired(now) || entry.Size > c.memoryStore.GetCapacity() {
+			continue
+		}
+		if c.setInStore(ctx, c.memoryStore, entry) == nil {
+			c.dirty.add(entry.Key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// GetStaleWhileRevalidate returns the value for key, calling loader and
+// caching its result with ttl on a miss like GetOrLoad. If the entry has
+// expired but is still within the stale window set by WithStaleWindow, the
+// stale value is returned immediately and loader refreshes it in the
+// background. Past the stale window the call blocks on loader.
+func (c *MultiTierCache) GetStaleWhileRevalidate(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	if value, err := c.getValue(ctx, key); err == nil {
+		return value, nil
+	}
+
+	if entry, ok := c.getStale(ctx, c.storeKey(key)); ok {
+		go c.revalidate(context.WithoutCancel(ctx), key, ttl, loader)
+		return entry.Value, nil
+	}
+	return c.getOrLoad(ctx, key, ttl, loader)
+}
+
+// getStale returns the entry for key from memory or disk if it is stale.
+// The remote tier doesn't keep expiry metadata, so it can't tell.
+func (c *MultiTierCache) getStale(ctx context.Context, key string) (*CacheEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := c.clock.Now()
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		if entry, err := peekEntry(ctx, store, key); err == nil && entry.stale(now) {
+			return entry, true
+		}
+	}
+	return nil, false
+}
+
+// revalidate reloads key in the background. It shares the load group with
+// GetOrLoad, so concurrent refreshes of one key call loader once.
+func (c *MultiTierCache) revalidate(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) ([]byte, error)) {
+	c.loads.Do(key, func() (interface{}, error) {
+		value, err := loader(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.SetWithTTL(ctx, key, value, ttl); err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+}
@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Streamer is implemented by stores that can write and read a value as a
+// stream instead of holding all of it in memory at once.
+type Streamer interface {
+	// SetStream stores entry with the first entry.Size bytes read from r
+	// as its value.
+	SetStream(ctx context.Context, entry *CacheEntry, r io.Reader) error
+	// GetStream returns the entry for key without its value, and a reader
+	// for the value that the caller must close.
+	GetStream(ctx context.Context, key string) (*CacheEntry, io.ReadCloser, error)
+}
+
+// SetStream stores the first size bytes read from r at key. When the disk
+// tier is a Streamer with room for them, they are copied straight to disk
+// as they are read, bypassing memory, so large blobs never have to be held
+// whole. Otherwise, and under write-through or write-back, which write
+// every tier, the value is read into memory and stored as with Set.
+func (c *MultiTierCache) SetStream(ctx context.Context, key string, r io.Reader, size int) error {
+	streamer, ok := c.diskStore.(Streamer)
+	if !ok || c.writeThrough || c.writeBack != nil || size > c.diskStore.GetCapacity() {
+		value, err := readValue(r, size)
+		if err != nil {
+			return &CacheError{Op: "set", Key: key, Err: err}
+		}
+		return c.Set(ctx, key, value)
+	}
+
+	defer c.observeLatency("set", time.Now())
+	defer c.dispatchEvictions()
+
+	entry, err := c.newEntry(key, nil, SetOptions{})
+	if err != nil {
+		return err
+	}
+	entry.Size = size
+	if c.tooLarge(size) {
+		return &CacheError{Op: "set", Key: key, Err: ErrEntryTooLarge}
+	}
+	c.negatives.remove(entry.Key)
+	c.recordAccess(entry.Key)
+
+	if err := c.setStreamLocked(ctx, streamer, entry, r); err != nil {
+		return err
+	}
+	c.publishInvalidation(ctx, entry.Key)
+	return nil
+}
+
+// setStreamLocked makes room on disk for entry and streams it there from r,
+// dropping any copy in memory that would shadow it.
+func (c *MultiTierCache) setStreamLocked(ctx context.Context, streamer Streamer, entry *CacheEntry, r io.Reader) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.assignVersion(ctx, entry, nil); err != nil {
+		return err
+	}
+	c.dirty.remove(entry.Key)
+	c.memoryStore.Delete(ctx, entry.Key)
+	c.recordMemoryRemoval(entry.Key)
+
+	// r can only be read once, so evict before writing rather than
+	// retrying the write.
+	c.diskStore.Delete(ctx, entry.Key)
+	for !hasRoom(c.diskStore, entry.Size) {
+		victim := c.chooseVictim(ctx, c.diskStore)
+		if victim == "" || !c.evict(ctx, c.diskStore, victim) {
+			return &CacheError{Op: "set", Tier: TierDisk, Key: entry.Key, Err: ErrInsufficientCapacity}
+		}
+	}
+	if err := streamer.SetStream(ctx, entry, r); err != nil {
+		return &CacheError{Op: "set", Tier: TierDisk, Key: entry.Key, Err: err}
+	}
+	return nil
+}
+
+// GetStream returns a reader for the value at key, which the caller must
+// close. A value on disk in a Streamer is read from its file as the caller
+// reads, without being promoted to memory; values in other tiers are read
+// as with Get and served from memory.
+func (c *MultiTierCache) GetStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	if rc, ok := c.getDiskStream(ctx, key); ok {
+		return rc, nil
+	}
+	value, err := c.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+// getDiskStream opens the disk tier's stream for key if memory doesn't
+// hold a fresher copy. It reports false to fall back to Get.
+func (c *MultiTierCache) getDiskStream(ctx context.Context, key string) (io.ReadCloser, bool) {
+	streamer, ok := c.diskStore.(Streamer)
+	if !ok {
+		return nil, false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	sk := c.storeKey(key)
+	now := c.clock.Now()
+	if c.negatives.has(sk, now) || storeHas(ctx, c.memoryStore, sk, now) {
+		return nil, false
+	}
+	entry, rc, err := streamer.GetStream(ctx, sk)
+	if err != nil {
+		return nil, false
+	}
+	if entry.expired(now) {
+		rc.Close()
+		return nil, false
+	}
+	c.recordAccess(sk)
+	atomic.AddInt64(&c.statsDiskHits, 1)
+	return rc, true
+}
+
+// readValue reads exactly size bytes from r.
+func readValue(r io.Reader, size int) ([]byte, error) {
+	value := make([]byte, size)
+	if _, err := io.ReadFull(r, value); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return value, nil
+}
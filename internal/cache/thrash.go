@@ -0,0 +1,136 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Suggested settings for WithThrashProtection; thrash protection is off
+// unless enabled with it.
+const (
+	DefaultThrashWindow    = time.Second
+	DefaultThrashThreshold = 3
+	DefaultThrashCooldown  = 30 * time.Second
+)
+
+// thrashPruneSize is how many keys a thrashGuard tracks before it drops
+// the ones it no longer needs.
+const thrashPruneSize = 4096
+
+// thrashGuard spots entries that are evicted from memory soon after being
+// promoted to it, again and again, as happens when memory is too small for
+// the working set. Such entries are held in the lower tiers for a while, so
+// that serving them doesn't keep evicting and promoting.
+type thrashGuard struct {
+	mu        sync.Mutex
+	window    time.Duration
+	threshold int
+	cooldown  time.Duration
+	keys      map[string]*thrashRecord
+	pruneAt   int
+}
+
+type thrashRecord struct {
+	promotedAt time.Time
+	// strikes counts the evictions in a row that came within the window
+	// of the promotion before them.
+	strikes   int
+	heldUntil time.Time
+}
+
+func newThrashGuard(window time.Duration, threshold int, cooldown time.Duration) *thrashGuard {
+	return &thrashGuard{
+		window:    window,
+		threshold: threshold,
+		cooldown:  cooldown,
+		keys:      make(map[string]*thrashRecord),
+		pruneAt:   thrashPruneSize,
+	}
+}
+
+// held reports whether key must not be promoted at now.
+func (g *thrashGuard) held(key string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r, ok := g.keys[key]
+	if !ok || r.heldUntil.IsZero() {
+		return false
+	}
+	if now.Before(r.heldUntil) {
+		return true
+	}
+	delete(g.keys, key)
+	return false
+}
+
+// promoted records that key was promoted to memory at now.
+func (g *thrashGuard) promoted(key string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r, ok := g.keys[key]
+	if !ok {
+		if len(g.keys) >= g.pruneAt {
+			g.prune(now)
+		}
+		r = &thrashRecord{}
+		g.keys[key] = r
+	}
+	r.promotedAt = now
+}
+
+// evicted records that key was evicted from memory at now, reporting
+// whether that was thrash: an eviction within the window of its promotion.
+// After threshold of those in a row the key is held for the cooldown.
+func (g *thrashGuard) evicted(key string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	r, ok := g.keys[key]
+	if !ok || r.promotedAt.IsZero() {
+		return false
+	}
+	if now.Sub(r.promotedAt) > g.window {
+		delete(g.keys, key)
+		return false
+	}
+	r.promotedAt = time.Time{}
+	r.strikes++
+	if r.strikes >= g.threshold {
+		r.strikes = 0
+		r.heldUntil = now.Add(g.cooldown)
+	}
+	return true
+}
+
+// prune drops the keys that are neither held nor recently promoted.
+func (g *thrashGuard) prune(now time.Time) {
+	for key, r := range g.keys {
+		if now.After(r.heldUntil) && now.Sub(r.promotedAt) > g.window {
+			delete(g.keys, key)
+		}
+	}
+	g.pruneAt = max(thrashPruneSize, 2*len(g.keys))
+}
+
+// promotionHeld reports whether thrash protection is keeping key out of
+// memory.
+func (c *MultiTierCache) promotionHeld(key string) bool {
+	return c.thrash != nil && c.thrash.held(key, c.clock.Now())
+}
+
+func (c *MultiTierCache) notePromotion(key string) {
+	if c.thrash != nil {
+		c.thrash.promoted(key, c.clock.Now())
+	}
+}
+
+// noteMemoryEviction counts key's eviction from memory as thrash if it
+// came soon after its promotion.
+func (c *MultiTierCache) noteMemoryEviction(key string) {
+	if c.thrash != nil && c.thrash.evicted(key, c.clock.Now()) {
+		atomic.AddInt64(&c.statsThrashes, 1)
+	}
+}
@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"cmp"
+	"context"
+	"slices"
+)
+
+// SortKey selects what TopEntries ranks entries by.
+type SortKey int
+
+const (
+	// SortBySize ranks the largest entries first.
+	SortBySize SortKey = iota
+	// SortByLastAccess ranks the least recently used entries first.
+	SortByLastAccess
+	// SortByFrequency ranks the most often accessed entries first.
+	SortByFrequency
+)
+
+// TopEntries returns copies of the first n unexpired entries across all
+// tiers ranked by by, for diagnosing what is filling the cache. A key held
+// by several tiers is counted once, from the highest tier, and each copy
+// records its Tier as with GetWithMetadata. Entries read from the remote
+// tier carry no access metadata, so they rank as never accessed. Listing
+// the remote tier scans all of its keys.
+func (c *MultiTierCache) TopEntries(ctx context.Context, by SortKey, n int) []*CacheEntry {
+	if n <= 0 {
+		return nil
+	}
+	now := c.clock.Now()
+	seen := make(map[string]struct{})
+	var entries []*CacheEntry
+	add := func(entry *CacheEntry, tier Tier) {
+		if _, ok := seen[entry.Key]; ok || entry.expired(now) {
+			return
+		}
+		key, ok := c.userKey(entry.Key)
+		if !ok {
+			return
+		}
+		seen[entry.Key] = struct{}{}
+		entry = entry.Clone()
+		entry.Key = key
+		entry.Tier = tier
+		entries = append(entries, entry)
+	}
+
+	c.mu.RLock()
+	for _, entry := range c.memoryStore.GetAll(ctx) {
+		add(entry, TierMemory)
+	}
+	for _, entry := range c.diskStore.GetAll(ctx) {
+		add(entry, TierDisk)
+	}
+	c.mu.RUnlock()
+	for _, entry := range c.remoteStore.GetAll(ctx) {
+		add(entry, TierRemote)
+	}
+
+	slices.SortStableFunc(entries, func(a, b *CacheEntry) int {
+		switch by {
+		case SortByLastAccess:
+			return compareLastAccess(a, b)
+		case SortByFrequency:
+			return cmp.Compare(b.Frequency, a.Frequency)
+		default:
+			return cmp.Compare(b.Size, a.Size)
+		}
+	})
+	return entries[:min(n, len(entries))]
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// Tracer starts spans around cache operations. It has the shape of the
+// part of OpenTelemetry's trace.Tracer the cache needs, so an adapter
+// wrapping an OpenTelemetry tracer is a few lines, without the cache
+// depending on OpenTelemetry.
+type Tracer interface {
+	// Start begins a span named name as a child of any span in ctx and
+	// returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttribute(key string, value string)
+	RecordError(err error)
+	End()
+}
+
+// Span attributes set by the cache.
+const (
+	// TraceAttrKey is the key operated on, or its hash with
+	// WithTraceKeyHashing.
+	TraceAttrKey = "cache.key"
+	// TraceAttrTier is the tier that served a Get, or "none".
+	TraceAttrTier = "cache.tier"
+	// TraceAttrResult is "hit" or "miss" for a Get, "ok" for a successful
+	// Set or Delete, and "error" for any failure.
+	TraceAttrResult = "cache.result"
+)
+
+// startSpan starts the span for op on key, returning a nil Span without a
+// tracer so that untraced caches do no tracing work.
+func (c *MultiTierCache) startSpan(ctx context.Context, op, key string) (context.Context, Span) {
+	if c.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := c.tracer.Start(ctx, "cache."+op)
+	if c.hashTraceKeys {
+		sum := sha256.Sum256([]byte(key))
+		key = hex.EncodeToString(sum[:])
+	}
+	span.SetAttribute(TraceAttrKey, key)
+	return ctx, span
+}
+
+// endSpan records the outcome of an operation on span, if there is one,
+// and ends it. tier is TierNone for operations other than Get.
+func endSpan(span Span, op string, tier Tier, err error) {
+	if span == nil {
+		return
+	}
+	result := "ok"
+	switch {
+	case errors.Is(err, ErrKeyNotFound) || errors.Is(err, ErrNegativeCached):
+		result = "miss"
+	case err != nil:
+		result = "error"
+		span.RecordError(err)
+	case op == "Get":
+		result = "hit"
+	}
+	if op == "Get" {
+		span.SetAttribute(TraceAttrTier, tier.String())
+	}
+	span.SetAttribute(TraceAttrResult, result)
+	span.End()
+}
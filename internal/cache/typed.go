@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec converts typed values to and from the bytes stored in the cache.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type MsgpackCodec struct{}
+
+func (MsgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte, v any) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// TypedCache wraps a MultiTierCache to store values of type T, encoding
+// them with a Codec.
+type TypedCache[T any] struct {
+	cache *MultiTierCache
+	codec Codec
+}
+
+// NewTypedCache wraps c. A nil codec defaults to JSONCodec.
+func NewTypedCache[T any](c *MultiTierCache, codec Codec) *TypedCache[T] {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+	return &TypedCache[T]{cache: c, codec: codec}
+}
+
+// Get returns the value stored under key. Values that can't be decoded into
+// T are reported with ErrDecode, distinct from ErrKeyNotFound.
+func (t *TypedCache[T]) Get(ctx context.Context, key string) (T, error) {
+	var value T
+	data, err := t.cache.Get(ctx, key)
+	if err != nil {
+		return value, err
+	}
+	if err := t.codec.Unmarshal(data, &value); err != nil {
+		return value, &CacheError{Op: "get", Key: key, Err: fmt.Errorf("%w: %v", ErrDecode, err)}
+	}
+	return value, nil
+}
+
+func (t *TypedCache[T]) Set(ctx context.Context, key string, value T) error {
+	return t.SetWithTTL(ctx, key, value, 0)
+}
+
+func (t *TypedCache[T]) SetWithTTL(ctx context.Context, key string, value T, ttl time.Duration) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return &CacheError{Op: "set", Key: key, Err: err}
+	}
+	return t.cache.SetWithTTL(ctx, key, data, ttl)
+}
+
+func (t *TypedCache[T]) Delete(ctx context.Context, key string) error {
+	return t.cache.Delete(ctx, key)
+}
+
+// Cache returns the underlying MultiTierCache.
+func (t *TypedCache[T]) Cache() *MultiTierCache {
+	return t.cache
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testUser struct {
+	ID    int
+	Name  string
+	Roles []string
+}
+
+func TestTypedCache(t *testing.T) {
+	c, err := NewCache(WithMemoryCapacity(1000), WithDiskCapacity(1000))
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	want := testUser{ID: 1, Name: "alice", Roles: []string{"admin", "dev"}}
+
+	for name, codec := range map[string]Codec{
+		"default": nil,
+		"json":    JSONCodec{},
+		"gob":     GobCodec{},
+		"msgpack": MsgpackCodec{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			users := NewTypedCache[testUser](c, codec)
+			if err := users.Set(ctx, "user:"+name, want); err != nil {
+				t.Fatalf("Failed to set user: %v", err)
+			}
+			got, err := users.Get(ctx, "user:"+name)
+			if err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Unexpected user. Got %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	t.Run("DecodeErrorIsNotMiss", func(t *testing.T) {
+		c.Set(ctx, "garbage", []byte("not json"))
+		users := NewTypedCache[testUser](c, JSONCodec{})
+
+		_, err := users.Get(ctx, "garbage")
+		if !errors.Is(err, ErrDecode) {
+			t.Errorf("Expected ErrDecode, got %v", err)
+		}
+		if errors.Is(err, ErrKeyNotFound) {
+			t.Error("Decode error should not look like a cache miss")
+		}
+
+		_, err = users.Get(ctx, "missing")
+		if !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound for missing key, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,298 @@
+package cache
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// BackpressurePolicy decides what a write-back Set does when the flush
+// queue is full.
+type BackpressurePolicy int
+
+const (
+	// BackpressureBlock makes Set wait for room in the queue.
+	BackpressureBlock BackpressurePolicy = iota
+	// BackpressureSync makes Set persist the entry synchronously instead.
+	BackpressureSync
+)
+
+type writeBackQueue struct {
+	mu      sync.RWMutex
+	closed  bool
+	policy  BackpressurePolicy
+	entries chan *CacheEntry
+	done    chan struct{}
+
+	// pending holds the latest queued entry per key so that entries
+	// superseded by a newer Set, or removed by Delete, are not persisted.
+	pendingMu sync.Mutex
+	pending   map[string]*CacheEntry
+}
+
+func newWriteBackQueue(size int, policy BackpressurePolicy) *writeBackQueue {
+	return &writeBackQueue{
+		policy:  policy,
+		entries: make(chan *CacheEntry, size),
+		done:    make(chan struct{}),
+		pending: make(map[string]*CacheEntry),
+	}
+}
+
+// enqueue hands entry to the flush worker. It returns false if the caller
+// must persist the entry itself, either because the queue is closed or
+// because it is full under BackpressureSync.
+func (q *writeBackQueue) enqueue(entry *CacheEntry) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false
+	}
+
+	q.pendingMu.Lock()
+	q.pending[entry.Key] = entry
+	q.pendingMu.Unlock()
+
+	if q.policy == BackpressureBlock {
+		q.entries <- entry
+		return true
+	}
+
+	select {
+	case q.entries <- entry:
+		return true
+	default:
+		q.forget(entry.Key)
+		return false
+	}
+}
+
+// take reports whether entry is still the latest pending write for its key
+// and clears it.
+func (q *writeBackQueue) take(entry *CacheEntry) bool {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+
+	if q.pending[entry.Key] != entry {
+		return false
+	}
+	delete(q.pending, entry.Key)
+	return true
+}
+
+func (q *writeBackQueue) forget(key string) {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	delete(q.pending, key)
+}
+
+func (q *writeBackQueue) forgetAll() {
+	q.pendingMu.Lock()
+	defer q.pendingMu.Unlock()
+	q.pending = make(map[string]*CacheEntry)
+}
+
+// close stops accepting entries and waits for the worker to drain the queue.
+func (q *writeBackQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.entries)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+func (c *MultiTierCache) runWriteBack() {
+	defer close(c.writeBack.done)
+
+	ctx := context.Background()
+	for entry := range c.writeBack.entries {
+		// take runs under the cache lock so a concurrent Delete either
+		// forgets the entry first or runs after it has been persisted.
+		c.mu.Lock()
+		persisted := c.writeBack.take(entry)
+		if persisted {
+			c.persistLocked(ctx, entry)
+			c.fitTotalCapacity(ctx, entry.Key)
+		}
+		c.mu.Unlock()
+		c.dispatchEvictions()
+		// Other caches may have reloaded the old remote value since Set
+		// published the key.
+		if persisted {
+			c.publishInvalidation(ctx, entry.Key)
+		}
+	}
+}
+
+// setWriteBack places entry in memory and defers persisting it to the lower
+// tiers to the flush worker. Entries that don't fit in memory, or that the
+// queue can't accept, are persisted synchronously.
+func (c *MultiTierCache) setWriteBack(ctx context.Context, entry *CacheEntry, expectedVersion *uint64) error {
+	c.mu.Lock()
+	if err := c.assignVersion(ctx, entry, expectedVersion); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	err := c.setInStore(ctx, c.memoryStore, entry)
+	if err == nil {
+		c.dirty.add(entry.Key)
+	}
+	c.fitTotalCapacity(ctx, entry.Key)
+	c.mu.Unlock()
+
+	if err == nil && c.writeBack.enqueue(entry) {
+		return nil
+	}
+	return c.persist(ctx, entry)
+}
+
+// persist writes entry to the disk and remote tiers.
+func (c *MultiTierCache) persist(ctx context.Context, entry *CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.persistLocked(ctx, entry)
+}
+
+func (c *MultiTierCache) persistLocked(ctx context.Context, entry *CacheEntry) error {
+	c.dirty.remove(entry.Key)
+	c.setInStore(ctx, c.diskStore, entry)
+	if err := c.remoteStore.Set(ctx, entry); err != nil {
+		return &CacheError{Op: "set", Tier: TierRemote, Key: entry.Key, Err: err}
+	}
+	c.noteRemoteKey(entry.Key)
+	return nil
+}
+
+// persistUpdated copies the memory entry for key to the lower tiers after
+// an in-place update, if they are meant to mirror memory, and otherwise
+// marks it dirty. It persists synchronously because enqueueing can block on
+// the worker, which needs the lock the caller holds.
+func (c *MultiTierCache) persistUpdated(ctx context.Context, key string) error {
+	if !c.writeThrough && c.writeBack == nil {
+		c.dirty.add(key)
+		return nil
+	}
+	if c.writeBack != nil {
+		c.writeBack.forget(key)
+	}
+	entry, err := c.memoryStore.Get(ctx, key)
+	if err != nil {
+		return nil
+	}
+	return c.persistLocked(ctx, entry)
+}
+
+// refreshLocal replaces the memory and disk copies of an entry whose
+// authoritative value was updated in the remote tier, keeping the existing
+// expiry, stale window, metadata and priority: those of a live local copy
+// or, failing that, what the remote tier holds for the key. The version
+// moves on from the existing one, as for a Set.
+func (c *MultiTierCache) refreshLocal(ctx context.Context, entry *CacheEntry) {
+	var version uint64
+	if existing := c.liveEntry(ctx, entry.Key); existing != nil {
+		entry.ExpiresAt = existing.ExpiresAt
+		entry.StaleUntil = existing.StaleUntil
+		entry.Metadata = existing.Metadata
+		entry.Priority = existing.Priority
+		version = existing.Version
+	}
+	entry.Version = version + 1
+	c.setInStore(ctx, c.memoryStore, entry)
+	c.setInStore(ctx, c.diskStore, entry)
+}
+
+// liveEntry returns the unexpired copy of key from the first local tier that
+// holds one, falling back to the remote tier, or nil if none does.
+func (c *MultiTierCache) liveEntry(ctx context.Context, key string) *CacheEntry {
+	now := c.clock.Now()
+	for _, store := range []Store{c.memoryStore, c.diskStore} {
+		if entry, err := peekEntry(ctx, store, key); err == nil && !entry.expired(now) {
+			return entry
+		}
+	}
+	if entry, err := c.remoteStore.Get(ctx, key); err == nil {
+		return entry
+	}
+	return nil
+}
+
+// Flush writes every dirty memory entry, one that has been written but not
+// yet persisted, to the disk and remote tiers without removing it from
+// memory. Entries already persisted are skipped.
+func (c *MultiTierCache) Flush(ctx context.Context) error {
+	defer c.dispatchEvictions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for _, key := range c.dirty.list() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.writeBack != nil {
+			c.writeBack.forget(key)
+		}
+
+		entry, err := peekEntry(ctx, c.memoryStore, key)
+		if err != nil || entry.expired(now) {
+			c.dirty.remove(key)
+			continue
+		}
+		if err := c.persistLocked(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dirtySet holds the keys of dirty memory entries.
+type dirtySet struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+func (d *dirtySet) add(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.keys == nil {
+		d.keys = make(map[string]struct{})
+	}
+	d.keys[key] = struct{}{}
+}
+
+func (d *dirtySet) has(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.keys[key]
+	return ok
+}
+
+func (d *dirtySet) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.keys, key)
+}
+
+func (d *dirtySet) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys = nil
+}
+
+// list returns the keys held, in no particular order.
+func (d *dirtySet) list() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Collect(maps.Keys(d.keys))
+}
+
+func (d *dirtySet) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.keys)
+}